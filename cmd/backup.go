@@ -1,33 +1,23 @@
 package cmd
 
 import (
-	"bufio"
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"net/url"
 	"os"
-	"strconv"
-
-	//"strconv"
 	"strings"
-	//"sync"
+	"sync"
 	"time"
 
-	"encoding/json"
-	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 	"github.com/schollz/progressbar/v3"
 	"github.com/spf13/cobra"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/tools/remotecommand"
-	"net/http"
-	"net/url"
-	_ "path/filepath"
 )
 
 var (
@@ -44,595 +34,721 @@ var (
 	containers  string
 	clusterName string
 	uploadOSS   bool
+	storageURL  string
+	notify      bool
 )
 
 func init() {
-	backupCmd.Flags().StringSliceVar(&pods, "pods", []string{}, "Comma-separated list of pod names")
-	backupCmd.Flags().StringVarP(&label, "label", "l", "", "Label selector to filter pods")
-	backupCmd.Flags().StringVarP(&dataDir, "datadir", "d", "/iotdb/data/datanode", "Data directory inside the pod")
-	backupCmd.Flags().StringVarP(&outName, "outname", "o", "", "Output file name for the backup")
-	backupCmd.Flags().StringVarP(&bucketName, "bucketname", "b", "", "OSS bucket name")
-	backupCmd.Flags().IntVarP(&verbose, "verbose", "v", 0, "Verbose level (0: silent, 1: basic, 2: detailed)")
-	backupCmd.Flags().StringVar(&configPath, "config", "/root/.kube/config", "Path to the kubeconfig file")
-	backupCmd.Flags().StringVar(&namespace, "namespace", "default", "Kubernetes namespace")
-	backupCmd.Flags().BoolVar(&keepLocal, "keep-local", false, "是否将备份文件保存到本地")
-	backupCmd.Flags().Int64Var(&chunkSize, "chunksize", 10*1024*1024, "下载和上传的分片大小（字节）")
-	backupCmd.Flags().StringVar(&containers, "containers", "iotdb-datanode", "要操作的容器，多个容器用逗号分隔")
-	backupCmd.Flags().StringVar(&clusterName, "cluster-name", "", "Kubernetes 集群名称")
-	backupCmd.Flags().BoolVar(&uploadOSS, "uploadoss", true, "是否上传备份文件到 OSS")
+	addBackupFlags(backupCmd)
+	backupCmd.Flags().StringVar(&clustersFile, "clusters-file", "", "集群清单文件（YAML），依次备份清单中的每个集群，清单中的参数覆盖命令行参数")
+	backupCmd.Flags().IntVar(&clusterConcurrency, "cluster-concurrency", 2, "与 --clusters-file 一起使用，同时备份的集群数")
+	backupCmd.Flags().StringVar(&clustersReport, "clusters-report", "", "与 --clusters-file 一起使用，将汇总报告以 JSON 保存到指定文件")
+	backupCmd.Flags().StringVar(&pushgatewayURL, "pushgateway-url", "", "Pushgateway 地址，如 http://pushgateway:9091，运行结束时推送本次运行的监控指标")
+	backupCmd.Flags().StringVar(&pushgatewayJob, "pushgateway-job", "iotdbtools", "推送到 Pushgateway 时的 job 名称")
 
 	rootCmd.AddCommand(backupCmd)
 }
 
+// addBackupFlags 添加备份参数，backup 和 daemon 共用
+func addBackupFlags(cmd *cobra.Command) {
+	addPodFlags(cmd)
+	addScopeFlags(cmd)
+	addStorageFlags(cmd)
+	cmd.Flags().StringVarP(&outName, "outname", "o", "", "Output file name for the backup")
+	cmd.Flags().BoolVar(&keepLocal, "keep-local", false, "是否将备份文件保存到本地")
+	cmd.Flags().BoolVar(&uploadOSS, "uploadoss", true, "是否上传备份文件到 OSS")
+	cmd.Flags().BoolVar(&incremental, "incremental", false, "增量备份：只备份相对上一次备份新增或变化的 TsFile，第一次运行时备份全部 TsFile")
+	cmd.Flags().BoolVar(&dedup, "dedup", false, "去重备份：每个 TsFile 按内容只上传一次，每次备份只上传引用这些文件的快照")
+	cmd.Flags().StringVar(&compression, "compression", compressionGzip, "压缩算法: gzip、zstd、lz4、none，默认在本地多线程压缩，pod 传出未压缩的 tar，经 apiserver 的流量更大")
+	cmd.Flags().IntVar(&compressionLevel, "compression-level", 0, "压缩级别，0 表示使用算法的默认级别（gzip/lz4: 1-9，zstd: 1-22）")
+	cmd.Flags().IntVar(&compressionThreads, "compression-threads", 0, "压缩线程数，0 表示使用全部 CPU")
+	cmd.Flags().BoolVar(&compressInPod, "compress-in-pod", false, "在 pod 中压缩后再传出，减少经 apiserver 的流量但占用 pod 的 CPU，pod 中没有压缩程序时回退到本地压缩")
+	cmd.Flags().StringVar(&encryptionKeyFile, "encryption-key-file", "", "age 密钥文件，备份在客户端加密后再上传/保存，可以只包含公钥（age1...）")
+	cmd.Flags().StringVar(&encryptionPassphraseEnv, "encryption-passphrase-env", "", "保存加密口令的环境变量名，与 --encryption-key-file 二选一")
+	cmd.Flags().IntVar(&uploadConcurrency, "upload-concurrency", 3, "每个备份文件同时上传的分片数，需要 (并发数+1)*chunksize 的内存")
+	cmd.Flags().StringVar(&bandwidthLimit, "bandwidth-limit", "", "所有 pod 共用的上传带宽上限（每秒），如 20MB、512KiB，为空时不限速")
+	cmd.Flags().IntVar(&retryPolicy.MaxAttempts, "retry-attempts", retryPolicy.MaxAttempts, "Kubernetes exec、存储读写、通知遇到临时性错误时的最大尝试次数，1 表示不重试")
+	cmd.Flags().DurationVar(&retryPolicy.InitialBackoff, "retry-backoff", retryPolicy.InitialBackoff, "第一次重试前的等待时间，之后每次翻倍")
+	cmd.Flags().DurationVar(&retryPolicy.MaxBackoff, "retry-max-backoff", retryPolicy.MaxBackoff, "重试等待时间的上限")
+	cmd.Flags().DurationVar(&flushTimeout, "flush-timeout", 5*time.Minute, "刷新数据的时间上限，0 表示不限制")
+	cmd.Flags().DurationVar(&compressTimeout, "compress-timeout", 0, "在 pod 中打包（去重备份时为计算校验和）的时间上限，0 表示不限制")
+	cmd.Flags().DurationVar(&uploadTimeout, "upload-timeout", 0, "每个备份文件写入存储的时间上限，0 表示不限制")
+	cmd.Flags().StringVar(&checkpointDir, "checkpoint-dir", ".iotdbtools-checkpoints", "分片上传的断点目录，上传中断后重新运行相同的备份命令从断点继续，为空时不保存断点")
+	cmd.Flags().BoolVar(&notify, "notify", true, "是否发送通知，通知渠道在配置文件的 notifiers 中配置")
+}
+
 var backupCmd = &cobra.Command{
 	Use:   "backup",
 	Short: "Backup IoTDB data",
 	Long:  `Backup IoTDB data from Kubernetes pods and upload to OSS.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		startTime := time.Now()
-		log(2, "开始时间: %s", startTime.Format("2006-01-02 15:04:05"))
-
-		client, err := getClientSet(configPath)
-		if err != nil {
-			log(0, "创建 Kubernetes 客户端失败: %v", err)
-			err := sendFailureNotification(clusterName, namespace, "", err)
-			if err != nil {
-				log(2, "发送失败通知失败: %v", err)
-				return
-			}
-			os.Exit(1)
+		ctx := cmd.Context()
+		keepInterruptedUploads = flagSet(cmd, "checkpoint-dir")
+		if clustersFile != "" {
+			os.Exit(backupClusters(ctx))
 		}
-
-		podList, err := getPodList(client, namespace, pods, label)
+		metrics := newBackupMetrics()
+		err := runBackup(ctx, metrics)
+		// CronJob 等单次运行结束后进程退出，将本次运行的指标推送到 Pushgateway
+		pushBackupMetrics(metrics, clusterName, namespace)
 		if err != nil {
-			log(0, "列出 pods 失败: %v", err)
 			os.Exit(1)
 		}
+	},
+}
 
-		// 使用 goroutine 和 channel 并行处理 pod 备份
-		podCount := len(podList.Items)
-		doneChan := make(chan bool, podCount)
-
-		for _, pod := range podList.Items {
-			go func(pod v1.Pod) {
-				err := backupPod(client, pod)
-				if err != nil {
-					log(0, "pod %s 备份失败: %v", pod.Name, err)
-				}
-				doneChan <- true
-			}(pod)
-		}
+// runBackup 按命令行参数备份所有 pod 一次，结束后保存清单并发送汇总通知。
+// 无法开始备份或运行被中断时返回错误，部分 pod 失败时只记录在清单和通知中
+func runBackup(ctx context.Context, metrics *backupMetrics) error {
+	_, err := backupCluster(ctx, flagTarget(), &backupEnv{}, metrics, true)
+	return err
+}
 
-		// 等待所有 pod 备份完成
-		for i := 0; i < podCount; i++ {
-			<-doneChan
-		}
+// pushBackupMetrics 在设置了 --pushgateway-url 时推送一个集群本次运行的指标
+func pushBackupMetrics(metrics *backupMetrics, cluster, namespace string) {
+	if pushgatewayURL == "" {
+		return
+	}
+	ctx, cancel := cleanupContext()
+	defer cancel()
+	if err := metrics.push(ctx, cluster, namespace); err != nil {
+		log(0, "推送集群 %s 的监控指标失败: %v", metricsCluster(cluster), err)
+	} else {
+		log(2, "集群 %s 的监控指标已推送到 %s", metricsCluster(cluster), pushgatewayURL)
+	}
+}
 
-		endTime := time.Now()
-		log(1, "结束时间: %s", endTime.Format("2006-01-02 15:04:05"))
-		log(1, "总耗时: %v", endTime.Sub(startTime))
-	},
+// backupTarget 是一次备份的集群、pod 范围和存储位置，单集群备份时来自命令行参数，多集群备份时来自集群清单
+type backupTarget struct {
+	cluster     string
+	namespace   string
+	pods        []string
+	label       string
+	containers  string
+	dataDir     string
+	storage     string
+	kubeconfig  string
+	kubeContext string
 }
 
-func backupPod(clientset *kubernetes.Clientset, pod v1.Pod) error {
-	podStartTime := time.Now()
-	log(1, "正在处理 pod: %s", pod.Name)
+// flagTarget 返回命令行参数指定的备份范围
+func flagTarget() backupTarget {
+	return backupTarget{
+		cluster:     clusterName,
+		namespace:   namespace,
+		pods:        pods,
+		label:       label,
+		containers:  containers,
+		dataDir:     dataDir,
+		storage:     effectiveStorageURL(),
+		kubeconfig:  configPath,
+		kubeContext: kubeContext,
+	}
+}
 
-	containerList := strings.Split(containers, ",")
-	for _, container := range containerList {
-		container = strings.TrimSpace(container)
-		log(1, "正在处理容器: %s", container)
+// kubeClients 返回访问集群的客户端，与命令行参数使用同一个 kubeconfig 和 context 时共用 getKubeClients 的客户端
+func (t backupTarget) kubeClients() (kubernetes.Interface, PodExecutor, error) {
+	if t.kubeconfig == configPath && t.kubeContext == kubeContext {
+		return getKubeClients()
+	}
+	return newKubeClients(t.kubeconfig, t.kubeContext)
+}
 
-		//if !uploadOSS { // 如果不需要上传到 OSS，则跳过 ossutil 工具
-		//	trackStepDuration("env check", func() error {
-		//		return ensureOssutilAvailable(clientset, namespace, pod.Name, container, configPath)
-		//	})
-		//}
-		// 生成备份文件名
-		backupFileName := getBackupFileName(pod.Name, outName)
+// backupEnv 是一次运行中所有集群共用的压缩、加密设置和存储，上传限速器也由所有集群共用
+type backupEnv struct {
+	once   sync.Once
+	comp   *compressor
+	enc    *encryption
+	reason string
+	err    error
 
-		//var err error
+	mu     sync.Mutex
+	stores map[string]Storage
+}
 
-		// 刷新数据
-		if dataDir != "/iotdb/data/datanode" {
-			log(2, "hook is no action,please continue...")
-		} else {
-			if err := trackStepDuration("刷新数据", func() error {
-				return flushData(clientset, namespace, pod.Name, container, configPath)
-			}); err != nil {
-				return handleBackupError(err, clusterName, namespace, pod.Name, podStartTime)
-			}
+// prepare 检查备份参数并创建压缩、加密设置和上传限速器，只在第一次调用时执行，返回失败原因和错误
+func (e *backupEnv) prepare() (string, error) {
+	e.once.Do(func() {
+		fail := func(format string, args ...interface{}) {
+			e.reason, e.err = failureConfig, fmt.Errorf(format, args...)
 		}
-		// 压缩数据
-		if err := trackStepDuration("压缩数据", func() error {
-			return compressData(clientset, namespace, pod.Name, dataDir, backupFileName, container, configPath, outName)
-		}); err != nil {
-			return handleBackupError(err, clusterName, namespace, pod.Name, podStartTime)
+		if !uploadOSS && !keepLocal {
+			fail("--uploadoss 和 --keep-local 不能同时为 false")
+			return
 		}
-
-		if keepLocal {
-			// 复制备份文件到本地
-			if err := trackStepDuration("复制备份文件到本地", func() error {
-				return copyFileFromPod(clientset, namespace, pod.Name, container, backupFileName, configPath)
-			}); err != nil {
-				return handleBackupError(err, clusterName, namespace, pod.Name, podStartTime)
-			}
+		if incremental && dedup {
+			fail("--incremental 和 --dedup 不能同时使用，去重备份本身只上传新增的文件")
+			return
 		}
-
-		if uploadOSS {
-			var uploadErr error
-			if keepLocal {
-				// 备份先落到从本地上传到OSS，使用oss-go-sdk
-				uploadErr = trackStepDuration("从本地上传到OSS", func() error {
-					return uploadToOSS(backupFileName, bucketName)
-				})
-			} else {
-				// 从Pod直接上传到OSS, 使用ossutil
-				uploadErr = trackStepDuration("从Pod上传到OSS", func() error {
-					return uploadToOSSFromPod(clientset, namespace, pod.Name, backupFileName, container, bucketName, configPath)
-				})
-			}
-			if uploadErr != nil {
-				return handleBackupError(uploadErr, clusterName, namespace, pod.Name, podStartTime)
-			}
+		if (incremental || dedup) && !uploadOSS {
+			fail("增量备份和去重备份需要上传到存储（--uploadoss）")
+			return
 		}
-
-		if !keepLocal {
-			// 删除Pod中的文件
-			if err := trackStepDuration("删除Pod中的文件", func() error {
-				return deletePodFile(clientset, namespace, pod.Name, backupFileName, container, configPath)
-			}); err != nil {
-				return handleBackupError(err, clusterName, namespace, pod.Name, podStartTime)
-			}
+		if err := setBandwidthLimit(bandwidthLimit); err != nil {
+			fail("%v", err)
+			return
 		}
-
-		podEndTime := time.Now()
-		duration := podEndTime.Sub(podStartTime)
-		log(1, "pod %s 的备份完成。耗时: %v", pod.Name, duration)
-
-		// 发送成功通知
-		if uploadOSS {
-			if err := sendWeChatNotification(clusterName, namespace, pod.Name, bucketName, duration, backupFileName); err != nil {
-				log(0, "发送企业微信通知失败: %v", err)
-			} else {
-				log(1, "已发送企业微信通知")
-			}
+		comp, err := newCompressor(compression, compressionLevel, compressionThreads)
+		if err != nil {
+			fail("压缩参数错误: %v", err)
+			return
 		}
-	}
-
-	return nil
+		enc, err := loadEncryption()
+		if err != nil {
+			fail("加载加密密钥失败: %v", err)
+			return
+		}
+		e.comp, e.enc = comp, enc
+	})
+	return e.reason, e.err
 }
 
-func handleBackupError(err error, clusterName, namespace, podName string, startTime time.Time) error {
-	duration := time.Since(startTime)
-	log(0, "pod %s 的备份失败。耗时: %v, 错误: %v", podName, duration, err)
-
-	// 发送失败通知
-	notifyErr := sendFailureNotification(clusterName, namespace, podName, err)
-	if notifyErr != nil {
-		log(0, "发送失败通知失败: %v", notifyErr)
+// storage 返回 target 的存储，多个集群备份到同一个存储时共用。--credentials-secret 从 clientset 的集群中读取
+func (e *backupEnv) storage(ctx context.Context, target backupTarget, clientset kubernetes.Interface) (Storage, error) {
+	// 凭证从各集群的 Secret 读取时，不同集群的存储不能共用
+	key := target.storage
+	if credentialsSecret != "" {
+		key += "\x00" + target.kubeconfig + "\x00" + target.kubeContext + "\x00" + target.namespace
 	}
-
-	return err
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if store, ok := e.stores[key]; ok {
+		return store, nil
+	}
+	store, err := openStorageURL(ctx, target.storage, clientset, target.namespace)
+	if err != nil {
+		return nil, err
+	}
+	if e.stores == nil {
+		e.stores = map[string]Storage{}
+	}
+	e.stores[key] = store
+	return store, nil
 }
 
-func copyFileFromPod(clientset *kubernetes.Clientset, namespace, podName, containerName, fileName, configPath string) error {
-	cmd := []string{"cat", fileName}
-
-	output, err := executePodCommand(clientset, namespace, podName, containerName, cmd, configPath)
-	if err != nil {
-		return fmt.Errorf("从 pod 读取文件失败: %v", err)
+// backupCluster 备份 target 中的所有 pod 一次，结束后保存清单，notify 为 true 时发送汇总通知。
+// 返回本次运行的清单，无法开始备份时清单为 nil
+func backupCluster(ctx context.Context, target backupTarget, env *backupEnv, metrics *backupMetrics, notify bool) (*BackupManifest, error) {
+	startTime := time.Now()
+	retries := &retryCounter{metric: metrics.retries}
+	ctx = withRetryCounter(ctx, retries)
+	log(2, "开始时间: %s", startTime.Format("2006-01-02 15:04:05"))
+
+	// abort 结束无法开始备份的运行，发送一条失败通知，reason 为监控指标中的失败原因
+	abort := func(reason, format string, args ...interface{}) (*BackupManifest, error) {
+		err := fmt.Errorf(format, args...)
+		log(0, "%v", err)
+		metrics.recordFailure(reason)
+		summary := newRunSummary(nil, err)
+		summary.Cluster, summary.Namespace = target.cluster, target.namespace
+		summary.StartTime, summary.EndTime = startTime, time.Now()
+		metrics.recordRun(summary)
+		if notify {
+			if err := sendRunNotification(ctx, summary); err != nil {
+				log(0, "发送通知失败: %v", err)
+			}
+		}
+		return nil, err
 	}
 
-	err = ioutil.WriteFile(fileName, []byte(output), 0644)
+	client, executor, err := target.kubeClients()
 	if err != nil {
-		return fmt.Errorf("写入本地文件失败: %v", err)
+		return abort(failureKubeClient, "创建 Kubernetes 客户端失败: %v", err)
 	}
 
-	log(1, "文件 %s 已从 pod %s 复制到本地", fileName, podName)
-	return nil
-}
-
-func uploadToOSSFromPod(clientset *kubernetes.Clientset, namespace, podName, fileName, containerName, bucketName, configPath string) error {
-	err := trackStepDuration("env check", func() error {
-		return ensureOssutilAvailable(clientset, namespace, podName, containerName, configPath)
-	})
+	podList, missing, err := getPodList(ctx, client, target.namespace, target.pods, target.label)
 	if err != nil {
-		log(2, "env check 失败: %v", err)
-		return err
+		return abort(failureListPods, "列出 pods 失败: %v", err)
 	}
 
-	credentials, err := loadCredentials(".credentials")
-	if err != nil {
-		return err
+	if reason, err := env.prepare(); err != nil {
+		return abort(reason, "%v", err)
 	}
 
-	// 首先创建 ossutil 配置文件
-	configContent := fmt.Sprintf(`
-[Credentials]
-language=EN
-endpoint=%s
-accessKeyID=%s
-accessKeySecret=%s
-`, credentials["ENDPOINT"], credentials["AK"], credentials["SK"])
+	var store Storage
+	if uploadOSS {
+		store, err = env.storage(ctx, target, client)
+		if err != nil {
+			return abort(failureStorage, "打开存储失败: %v", err)
+		}
+	}
 
-	configFileName := ".ossutilconfig"
-	createConfigCmd := fmt.Sprintf(`echo '%s' > %s`, configContent, configFileName)
+	manifest := newBackupManifest(startTime, target.cluster, target.namespace)
+	if uploadOSS {
+		manifest.Storage = target.storage
+	}
 
-	_, err = executePodCommand(clientset, namespace, podName, containerName, []string{"sh", "-c", createConfigCmd}, configPath)
-	if err != nil {
-		return fmt.Errorf("创建 ossutil 配置文件失败: %v", err)
+	run := &backupRun{target: target, store: store, comp: env.comp, enc: env.enc, manifest: manifest, metrics: metrics, notify: notify}
+	// 增量备份根据存储中的历史清单确定每个 pod 的父备份，去重备份沿用上一次快照中未变化文件的校验和
+	if incremental || dedup {
+		run.history, err = manifestEntries(ctx, store, target.cluster, target.namespace)
+		if err != nil {
+			return abort(failureHistory, "读取历史备份清单失败: %v", err)
+		}
 	}
 
-	// 使用配置文件上传
-	uploadCmd := fmt.Sprintf(`
-		./ossutil64 cp %s oss://%s/ \
-		-c %s \
-		--force
-	`, fileName, bucketName, configFileName)
+	// 无法获取的 pod 同样记录到清单中，在汇总通知中展示
+	for podName, err := range missing {
+		metrics.recordFailure(failurePodNotFound)
+		now := time.Now()
+		manifest.addEntry(ManifestEntry{Pod: podName, StartTime: now, EndTime: now, Status: backupStatusFailed, Error: err.Error()})
+	}
 
-	log(2, "上传文件到 OSS 命令: %s", uploadCmd)
-	// 在 pod 中执行上传命令
-	cmd := []string{"sh", "-c", uploadCmd}
+	// 使用 goroutine 和 channel 并行处理 pod 备份
+	podCount := len(podList.Items)
+	doneChan := make(chan bool, podCount)
 
-	stdout, stderr, err := executePodCommandWithStderr(clientset, namespace, podName, containerName, cmd, configPath)
-	if err != nil {
-		return fmt.Errorf("上传文件到 OSS 失败: %v, stderr: %s", err, stderr)
+	for _, pod := range podList.Items {
+		go func(pod v1.Pod) {
+			err := backupPod(ctx, executor, run, pod)
+			if err != nil {
+				log(0, "pod %s 备份失败: %v", pod.Name, err)
+			}
+			doneChan <- true
+		}(pod)
 	}
 
-	// 删除配置文件
-	deleteConfigCmd := fmt.Sprintf("rm -f %s", configFileName)
-	_, err = executePodCommand(clientset, namespace, podName, containerName, []string{"sh", "-c", deleteConfigCmd}, configPath)
-	if err != nil {
-		log(2, "警告: 删除 ossutil 配置文件失败: %v", err)
+	// 等待所有 pod 备份完成
+	for i := 0; i < podCount; i++ {
+		<-doneChan
 	}
 
-	log(2, "文件 %s 已从 pod %s 上传到 OSS。输出: %s", fileName, podName, stdout)
-	return nil
-}
+	endTime := time.Now()
+	manifest.EndTime = endTime
+	manifest.Retries = retries.summary()
+	if manifest.Retries != nil {
+		log(0, "重试统计: %s", formatRetrySummary(manifest.Retries))
+	}
+	// 中断后同样保存清单，记录哪些 pod 的备份没有完成
+	saveCtx, cancel := cleanupContext()
+	defer cancel()
+	if err := saveManifest(saveCtx, store, manifest); err != nil {
+		log(0, "保存备份清单失败: %v", err)
+	} else if store != nil {
+		log(1, "备份清单已保存到 %s", store.URL(manifest.key()))
+	}
+	if keepLocal {
+		if fileName, err := saveLocalManifest(manifest); err != nil {
+			log(0, "保存本地备份清单失败: %v", err)
+		} else {
+			log(1, "备份清单已保存到本地文件 %s", fileName)
+		}
+	}
 
-func deletePodFile(clientset *kubernetes.Clientset, namespace, podName, fileName, containerName, configPath string) error {
-	cmd := []string{"rm", "-f", fileName}
+	log(1, "结束时间: %s", endTime.Format("2006-01-02 15:04:05"))
+	log(1, "总耗时: %v", endTime.Sub(startTime))
 
-	_, err := executePodCommand(clientset, namespace, podName, containerName, cmd, configPath)
-	if err != nil {
-		return fmt.Errorf("删除 pod 中的文件失败: %v", err)
+	// 所有 pod 的结果汇总为一条通知
+	var runErr error
+	if interrupted(ctx) {
+		runErr = errors.New("备份已中断")
+	}
+	summary := newRunSummary(manifest, runErr)
+	metrics.recordRun(summary)
+	if notify {
+		if err := sendRunNotification(saveCtx, summary); err != nil {
+			log(0, "发送通知失败: %v", err)
+		}
+	}
+	if runErr != nil {
+		log(0, "%v", runErr)
 	}
+	return manifest, runErr
+}
 
-	log(2, "已删除 pod %s 中的文件: %s", podName, fileName)
-	return nil
+// backupRun 是一次备份运行中所有 pod 共用的备份范围、存储、压缩、加密设置、清单和监控指标
+type backupRun struct {
+	target   backupTarget
+	store    Storage
+	comp     *compressor
+	enc      *encryption
+	manifest *BackupManifest
+	// 增量备份时的历史备份记录，按备份文件 key 索引
+	history map[string]runEntry
+	metrics *backupMetrics
+	// 多集群备份时为 false，所有集群结束后只发送一条汇总通知
+	notify bool
 }
 
-func getClientSet(kubeconfig string) (*kubernetes.Clientset, error) {
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+// trackStep 执行备份步骤，耗时计入监控指标
+func (run *backupRun) trackStep(stepName string, stepFunc func() error) error {
+	startTime := time.Now()
+	err := trackStepDuration(stepName, stepFunc)
+	status := backupStatusSuccess
 	if err != nil {
-		//sendFailureNotification("", "", "", err)
-		return nil, err
+		status = backupStatusFailed
 	}
-	return kubernetes.NewForConfig(config)
+	run.metrics.stepDuration.WithLabelValues(stepLabel(stepName), status).Observe(time.Since(startTime).Seconds())
+	return err
 }
 
-func getPodList(clientset *kubernetes.Clientset, namespace string, pods []string, label string) (*v1.PodList, error) {
-	var options metav1.ListOptions
+func backupPod(ctx context.Context, executor PodExecutor, run *backupRun, pod v1.Pod) error {
+	store, comp, enc, manifest, target := run.store, run.comp, run.enc, run.manifest, run.target
+	podStartTime := time.Now()
+	log(1, "正在处理 pod: %s", pod.Name)
 
-	if label != "" {
-		options.LabelSelector = label
-	}
+	containerList := strings.Split(target.containers, ",")
+	for _, container := range containerList {
+		container = strings.TrimSpace(container)
+		log(1, "正在处理容器: %s", container)
 
-	if len(pods) > 0 {
-		podList := &v1.PodList{
-			Items: []v1.Pod{},
+		// 生成备份文件名及其在存储中的 key
+		backupFileName := getBackupFileName(pod.Name, outName, comp.extension())
+		if enc != nil {
+			backupFileName += encryptedSuffix
 		}
-		for _, podName := range pods {
-			pod, err := clientset.CoreV1().Pods(namespace).Get(context.TODO(), podName, metav1.GetOptions{})
+		if dedup {
+			backupFileName = getBackupFileName(pod.Name, outName, snapshotSuffix)
+		}
+		objectKey := backupObjectKey(target.cluster, target.namespace, pod.Name, podStartTime, backupFileName)
+
+		// 分片上传的断点，有未完成的上传时沿用上一次的文件名和 key 继续上传。加密备份每次的密文都不同，无法续传
+		var cp *uploadCheckpoint
+		if _, ok := store.(multipartUploader); ok && checkpointDir != "" && enc == nil && !dedup {
+			identity := backupIdentity(target, pod.Name, container, comp.extension())
+			previous, err := loadUploadCheckpoint(identity)
 			if err != nil {
-				log(0, "获取 pod %s 失败: %v", podName, err)
-				sendFailureNotification("", namespace, podName, err)
-				continue
+				log(0, "%v", err)
+			}
+			if previous != nil {
+				cp, backupFileName, objectKey = previous, previous.FileName, previous.Key
+				log(1, "pod %s 有未完成的上传，继续上传 %s", pod.Name, objectKey)
+			} else {
+				cp = newUploadCheckpoint(identity, objectKey, backupFileName)
 			}
-			podList.Items = append(podList.Items, *pod)
 		}
-		return podList, nil
-	}
 
-	return clientset.CoreV1().Pods(namespace).List(context.TODO(), options)
-}
+		entry := ManifestEntry{
+			Pod:       pod.Name,
+			Container: container,
+			DataDir:   target.dataDir,
+			Flush:     "skipped",
+			StartTime: time.Now(),
+		}
+		entry.Image, entry.IoTDBVersion = containerImage(pod, container)
+		entry.Compression = comp.codec
+		if enc != nil {
+			entry.Encryption = &enc.info
+		}
+		if uploadOSS {
+			entry.Key = objectKey
+			entry.URL = store.URL(objectKey)
+		}
+		if keepLocal && !dedup {
+			entry.LocalFile = backupFileName
+		}
+		// 失败时同样记录到清单中
+		fail := func(reason string, err error) error {
+			run.metrics.recordFailure(reason)
+			entry.Status = backupStatusFailed
+			entry.Error = err.Error()
+			entry.EndTime = time.Now()
+			manifest.addEntry(entry)
+			return handleBackupError(ctx, err, run, pod.Name, podStartTime)
+		}
 
-func flushData(clientset *kubernetes.Clientset, namespace, podName, containerName, configPath string) error {
-	cmd := []string{"/iotdb/sbin/start-cli.sh", "-h", "iotdb-datanode", "-e", "flush on cluster"}
-	kubeconfigPath := configPath
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
-	if err != nil {
-		return fmt.Errorf("error building config from kubeconfig: %v", err)
-	}
-
-	req := clientset.CoreV1().RESTClient().Post().
-		Name(podName).
-		Namespace(namespace).
-		Resource("pods").
-		SubResource("exec").
-		VersionedParams(&v1.PodExecOptions{
-			Container: containerName,
-			Command:   cmd,
-			Stdin:     false,
-			Stdout:    true,
-			Stderr:    true,
-			TTY:       false,
-		}, scheme.ParameterCodec)
-
-	exec, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
-	if err != nil {
-		return fmt.Errorf("error creating executor: %v", err)
-	}
+		// 刷新数据
+		if target.dataDir != "/iotdb/data/datanode" {
+			log(2, "hook is no action,please continue...")
+		} else {
+			if err := run.trackStep("刷新数据", func() error {
+				flushCtx, cancel := stepContext(ctx, flushTimeout)
+				defer cancel()
+				err := flushData(flushCtx, executor, target.namespace, pod.Name, container)
+				return stepTimeout(flushCtx, "--flush-timeout", flushTimeout, err)
+			}); err != nil {
+				entry.Flush = "failed"
+				return fail(stepLabel("刷新数据"), err)
+			}
+			entry.Flush = "ok"
+		}
 
-	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
-	err = exec.Stream(remotecommand.StreamOptions{
-		Stdin:  nil,
-		Stdout: stdout,
-		Stderr: stderr,
-		Tty:    false,
-	})
-	if err != nil {
-		return fmt.Errorf("error streaming command: %v, stderr: %s", err, stderr.String())
-	}
+		if dedup {
+			if err := run.trackStep("去重备份", func() error {
+				podFiles, err := listPodFiles(ctx, executor, target.namespace, pod.Name, container, target.dataDir)
+				if err != nil {
+					return err
+				}
+				var previous []BackupFile
+				if parent := findParent(run.history, pod.Name, container, target.dataDir, backupTypeSnapshot); parent != nil {
+					previous = parent.Files
+				}
+				hashCtx, cancelHash := stepContext(ctx, compressTimeout)
+				defer cancelHash()
+				if err := hashPodFiles(hashCtx, executor, target.namespace, pod.Name, container, podFiles, previous); err != nil {
+					return stepTimeout(hashCtx, "--compress-timeout", compressTimeout, err)
+				}
+				entry.Type, entry.Files = backupTypeSnapshot, podFiles
+				uploadCtx, cancelUpload := stepContext(ctx, uploadTimeout)
+				defer cancelUpload()
+				archive, uploaded, err := backupSnapshot(uploadCtx, executor, run, objectKey, pod.Name, container, podFiles)
+				entry.Size, entry.SHA256, entry.Uploaded = archive.size, archive.sha256, uploaded
+				run.metrics.transferredBytes.WithLabelValues(pod.Name).Add(float64(uploaded))
+				return stepTimeout(uploadCtx, "--upload-timeout", uploadTimeout, err)
+			}); err != nil {
+				return fail(stepLabel("去重备份"), err)
+			}
+			entry.Status = backupStatusSuccess
+			entry.EndTime = time.Now()
+			manifest.addEntry(entry)
+			run.metrics.lastSuccessTime.WithLabelValues(pod.Name).SetToCurrentTime()
+			log(1, "pod %s 的去重备份完成，新上传 %s。耗时: %v", pod.Name, formatSize(entry.Uploaded), time.Since(podStartTime))
+			continue
+		}
 
-	log(2, "Flush command output: %s", stdout.String())
+		// 增量模式下只打包相对父备份新增或变化的 TsFile，files 为 nil 时打包整个数据目录
+		var files []string
+		if incremental {
+			podFiles, err := listPodFiles(ctx, executor, target.namespace, pod.Name, container, target.dataDir)
+			if err != nil {
+				return fail(failureListFiles, err)
+			}
+			entry.Type, entry.Files = backupTypeFull, podFiles
+			var parentFiles []BackupFile
+			if parent := findParent(run.history, pod.Name, container, target.dataDir, backupTypeFull, backupTypeIncremental); parent != nil {
+				entry.Type, entry.Parent, parentFiles = backupTypeIncremental, parent.Key, parent.Files
+			}
+			files = changedFiles(podFiles, parentFiles)
+			if files == nil {
+				files = []string{}
+			}
+			entry.ChangedFiles = len(files)
+			log(1, "pod %s 共 %d 个 TsFile 相关文件，本次备份 %d 个，父备份: %s", pod.Name, len(podFiles), len(files), entry.Parent)
+		}
 
-	time.Sleep(5 * time.Second)
-	return nil
-}
+		// 在 pod 中打包数据，以流的方式直接写入存储和/或本地文件
+		// 传输中断时整个备份流重新开始，有断点时已上传的分片不再上传
+		if err := run.trackStep("备份数据", func() error {
+			return retry(ctx, "备份数据", func() error {
+				entry.Attempts++
+				archive, err := streamBackup(withUploadCheckpoint(ctx, cp), executor, run, objectKey, pod.Name, container, backupFileName, files)
+				entry.Size, entry.SHA256 = archive.size, archive.sha256
+				return err
+			})
+		}); err != nil {
+			return fail(stepLabel("备份数据"), err)
+		}
 
-func compressData(clientset *kubernetes.Clientset, namespace, podName, dataDir, outputFileName, containerName, configPath, outName string) error {
-	cmd := []string{"tar", "--warning=no-file-changed", "-czf", outputFileName, dataDir}
-	kubeconfigPath := configPath
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
-	if err != nil {
-		return fmt.Errorf("error building config from kubeconfig: %v", err)
-	}
-
-	req := clientset.CoreV1().RESTClient().Post().
-		Name(podName).
-		Namespace(namespace).
-		Resource("pods").
-		SubResource("exec").
-		VersionedParams(&v1.PodExecOptions{
-			Container: containerName,
-			Command:   cmd,
-			Stdin:     false,
-			Stdout:    true,
-			Stderr:    true,
-			TTY:       false,
-		}, scheme.ParameterCodec)
-
-	exec, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
-	if err != nil {
-		return fmt.Errorf("error creating executor: %v", err)
-	}
+		entry.Status = backupStatusSuccess
+		entry.EndTime = time.Now()
+		manifest.addEntry(entry)
+		run.metrics.lastSuccessTime.WithLabelValues(pod.Name).SetToCurrentTime()
 
-	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
-	err = exec.Stream(remotecommand.StreamOptions{
-		Stdin:  nil,
-		Stdout: stdout,
-		Stderr: stderr,
-		Tty:    false,
-	})
-	if err != nil {
-		return fmt.Errorf("error streaming command: %v, stderr: %s", err, stderr.String())
+		podEndTime := time.Now()
+		duration := podEndTime.Sub(podStartTime)
+		log(1, "pod %s 的备份完成。耗时: %v", pod.Name, duration)
 	}
 
-	log(2, "压缩文件 %s 成功", outputFileName)
 	return nil
 }
 
-func ensureOssutilAvailable(clientset *kubernetes.Clientset, namespace, podName, containerName, configPath string) error {
-	// 检查 ossutil64 是否已存在
-	checkCmd := "if [ -f ./ossutil64 ] && [ -x ./ossutil64 ]; then echo 'exists'; else echo 'not found'; fi"
-	output, err := executePodCommand(clientset, namespace, podName, containerName, []string{"sh", "-c", checkCmd}, configPath)
-	if err != nil {
-		return fmt.Errorf("检查 ossutil64 是否存在失败: %v", err)
-	}
-
-	if strings.TrimSpace(output) == "exists" {
-		return nil
-	}
+func handleBackupError(ctx context.Context, err error, run *backupRun, podName string, startTime time.Time) error {
+	duration := time.Since(startTime)
+	log(0, "pod %s 的备份失败。耗时: %v, 错误: %v", podName, duration, err)
 
-	// 如果不存在，下载并安装 ossutil64
-	log(1, "install ossutil64 in pod %s", podName)
-	downloadCmd := `
-		curl -o ossutil64 http://gosspublic.alicdn.com/ossutil/1.7.7/ossutil64 && \
-		chmod 755 ossutil64
-	`
-	_, err = executePodCommand(clientset, namespace, podName, containerName, []string{"sh", "-c", downloadCmd}, configPath)
-	if err != nil {
-		return fmt.Errorf("下载并安装 ossutil64 失败: %v", err)
+	// 运行结束时的汇总通知包含所有失败的 pod，这里只通知在 events 中选择了 pod-failure 的渠道
+	if run.notify {
+		if notifyErr := sendPodFailureNotification(ctx, run.target.cluster, run.target.namespace, podName, err); notifyErr != nil {
+			log(0, "发送失败通知失败: %v", notifyErr)
+		}
 	}
 
-	log(1, " pod %s install ossutil64 successful", podName)
-	return nil
+	return err
 }
 
-func getFileSizeFromPod(clientset *kubernetes.Clientset, namespace, podName, containerName, fileName, configPath string) (int64, error) {
-	cmd := []string{"stat", "-c", "%s", fileName}
-
-	output, err := executePodCommand(clientset, namespace, podName, containerName, cmd, configPath)
-	if err != nil {
-		return 0, err
+// streamBackup 在 pod 中执行 tar，标准输出经 exec 流直接写入存储（uploadOSS）和本地文件（keepLocal），
+// pod 中不产生临时文件。pod 中的 tar 不压缩，由本地按 comp 多线程压缩，
+// enc 不为 nil 时压缩后再加密，存储和本地文件中都只有密文。
+// files 不为 nil 时只打包其中的文件（增量备份），文件列表通过标准输入交给 tar。
+// 上传完成后校验和与 runID 写入对象元数据
+func streamBackup(ctx context.Context, executor PodExecutor, run *backupRun, key, podName, containerName, fileName string, files []string) (archiveInfo, error) {
+	store, comp, enc, namespace := run.store, run.comp, run.enc, run.target.namespace
+	cmd := []string{"tar", "--warning=no-file-changed", "-cf", "-", run.target.dataDir}
+	var stdin io.Reader
+	if files != nil {
+		cmd = []string{"tar", "--warning=no-file-changed", "-cf", "-", "-T", "-"}
+		list := strings.Join(files, "\n")
+		if list != "" {
+			list += "\n"
+		}
+		stdin = strings.NewReader(list)
 	}
-
-	size, err := strconv.ParseInt(strings.TrimSpace(output), 10, 64)
-	if err != nil {
-		return 0, fmt.Errorf("无法解析文件大小: %v", err)
+	program := podCompressProgram(ctx, executor, namespace, podName, containerName, comp)
+	if program != "" {
+		cmd = append(cmd, "--use-compress-program="+program)
 	}
 
-	return size, nil
-}
+	// --compress-timeout 限制 pod 中打包的时间，--upload-timeout 限制写入存储的时间，超时后 exec 流和上传都会结束
+	tarCtx, cancelTar := stepContext(ctx, compressTimeout)
+	defer cancelTar()
+	uploadCtx, cancelUpload := stepContext(ctx, uploadTimeout)
+	defer cancelUpload()
 
-func executePodCommand(clientset *kubernetes.Clientset, namespace, podName, containerName string, cmd []string, configPath string) (string, error) {
-	kubeconfigPath := configPath
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
-	if err != nil {
-		return "", fmt.Errorf("error building config from kubeconfig: %v", err)
-	}
-
-	req := clientset.CoreV1().RESTClient().Post().
-		Resource("pods").
-		Name(podName).
-		Namespace(namespace).
-		SubResource("exec").
-		VersionedParams(&v1.PodExecOptions{
-			Container: containerName,
-			Command:   cmd,
-			Stdin:     false,
-			Stdout:    true,
-			Stderr:    true,
-			TTY:       false,
-		}, scheme.ParameterCodec)
-
-	exec, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
-	if err != nil {
-		return "", fmt.Errorf("error creating executor: %v", err)
-	}
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(streamPodCommand(tarCtx, executor, namespace, podName, containerName, cmd, stdin, writer))
+	}()
 
-	var stdout, stderr bytes.Buffer
-	err = exec.Stream(remotecommand.StreamOptions{
-		Stdin:  nil,
-		Stdout: &stdout,
-		Stderr: &stderr,
-		Tty:    false,
-	})
+	// 创建进度条，总大小未知
+	bar := progressbar.DefaultBytes(
+		-1,
+		fileName+" 正在备份",
+	)
 
+	// 数据依次经过 tar、压缩、加密，出错时需要关闭每一级管道，避免上游的 goroutine 阻塞在写入上
+	pipes := []*io.PipeReader{reader}
+	if program == "" {
+		pipes = append(pipes, comp.compressStream(reader))
+	}
+	if enc != nil {
+		pipes = append(pipes, enc.encryptStream(pipes[len(pipes)-1]))
+	}
+	source := pipes[len(pipes)-1]
+
+	// 边传输边计算大小和 SHA-256，校验和针对存储中的数据（加密时为密文）
+	hasher := sha256.New()
+	counter := &countingWriter{}
+	meta := map[string]string{metaRunID: run.manifest.RunID}
+	err := writeBackup(uploadCtx, store, key, fileName, io.TeeReader(source, io.MultiWriter(bar, hasher, counter)), meta)
+	run.metrics.transferredBytes.WithLabelValues(podName).Add(float64(counter.n))
+	for _, pipe := range pipes {
+		pipe.CloseWithError(err)
+	}
+	archive := archiveInfo{size: counter.n, sha256: hex.EncodeToString(hasher.Sum(nil))}
 	if err != nil {
-		return "", fmt.Errorf("error executing command: %v, stderr: %s", err, stderr.String())
+		err = stepTimeout(tarCtx, "--compress-timeout", compressTimeout, err)
+		return archive, stepTimeout(uploadCtx, "--upload-timeout", uploadTimeout, err)
 	}
 
-	return stdout.String(), nil
+	// 保存校验和，restore 和 verify 据此校验备份内容
+	if uploadOSS {
+		if err := recordChecksum(uploadCtx, store, key, archive.sha256); err != nil {
+			return archive, fmt.Errorf("保存校验和失败: %v", err)
+		}
+		log(2, "pod %s 的备份已上传到 %s，SHA-256: %s", podName, store.URL(key), archive.sha256)
+	}
+	if keepLocal {
+		if err := os.WriteFile(checksumKey(fileName), formatChecksum(archive.sha256, fileName), 0644); err != nil {
+			return archive, fmt.Errorf("保存本地校验和失败: %v", err)
+		}
+		log(1, "pod %s 的备份已保存到本地文件 %s", podName, fileName)
+	}
+	return archive, nil
 }
 
-func executePodCommandWithStderr(clientset *kubernetes.Clientset, namespace, podName, containerName string, cmd []string, configPath string) (string, string, error) {
-	kubeconfigPath := configPath
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
-	if err != nil {
-		return "", "", fmt.Errorf("error building config from kubeconfig: %v", err)
-	}
-
-	req := clientset.CoreV1().RESTClient().Post().
-		Resource("pods").
-		Name(podName).
-		Namespace(namespace).
-		SubResource("exec").
-		VersionedParams(&v1.PodExecOptions{
-			Container: containerName,
-			Command:   cmd,
-			Stdin:     false,
-			Stdout:    true,
-			Stderr:    true,
-			TTY:       false,
-		}, scheme.ParameterCodec)
-
-	exec, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
-	if err != nil {
-		return "", "", fmt.Errorf("error creating executor: %v", err)
+// podCompressProgram 返回 --compress-in-pod 时 pod 中 tar 使用的压缩命令，
+// 未开启、不压缩或者 pod 中没有对应的压缩程序时返回空，由本地压缩
+func podCompressProgram(ctx context.Context, executor PodExecutor, namespace, podName, containerName string, comp *compressor) string {
+	program := comp.podProgram()
+	if !compressInPod || program == "" {
+		return ""
 	}
+	name := strings.Fields(program)[0]
+	if _, err := executePodCommand(ctx, executor, namespace, podName, containerName, []string{"sh", "-c", "command -v " + name}); err != nil {
+		log(1, "pod %s 中没有 %s，改为在本地压缩: %v", podName, name, err)
+		return ""
+	}
+	return program
+}
 
-	var stdout, stderr bytes.Buffer
-	err = exec.Stream(remotecommand.StreamOptions{
-		Stdin:  nil,
-		Stdout: &stdout,
-		Stderr: &stderr,
-		Tty:    false,
-	})
+// archiveInfo 是传输完成的备份文件的大小和校验和
+type archiveInfo struct {
+	size   int64
+	sha256 string
+}
 
-	return stdout.String(), stderr.String(), err
+// countingWriter 统计写入的字节数
+type countingWriter struct {
+	n int64
 }
 
-func getBackupFileName(podName, customName string) string {
-	if customName != "" {
-		return fmt.Sprintf("%s_%s_%s.tar.gz", customName, podName, time.Now().Format("20060102150405"))
-	}
-	return fmt.Sprintf("%s_%s.tar.gz", podName, time.Now().Format("20060102150405"))
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
 }
 
-func uploadToOSS(fileName, bucketName string) error {
-	credentials, err := loadCredentials(".credentials")
-	if err != nil {
-		return err
+// writeBackup 将备份流写入存储和/或本地文件，meta 为存储中对象的元数据。本地文件先写入 .part 临时文件，全部成功后再重命名
+func writeBackup(ctx context.Context, store Storage, key, fileName string, r io.Reader, meta map[string]string) error {
+	if !keepLocal {
+		return store.Put(ctx, key, r, -1, meta)
 	}
 
-	client, err := oss.New(credentials["ENDPOINT"], credentials["AK"], credentials["SK"])
+	partName := fileName + ".part"
+	local, err := os.Create(partName)
 	if err != nil {
-		return err
+		return fmt.Errorf("创建本地文件失败: %v", err)
 	}
+	defer os.Remove(partName)
 
-	bucket, err := client.Bucket(bucketName)
+	if uploadOSS {
+		err = store.Put(ctx, key, io.TeeReader(r, local), -1, meta)
+	} else {
+		_, err = io.Copy(local, r)
+	}
+	if closeErr := local.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return err
 	}
 
-	// 设置对象的过期时间为7天后
-	expirationTime := time.Now().AddDate(0, 0, 7)
-	options := []oss.Option{
-		oss.Expires(expirationTime),
-		oss.Routines(3),
-	}
-
-	// 获取文件大小
-	fileInfo, err := os.Stat(fileName)
-	if err != nil {
-		return fmt.Errorf("获取文件信息失败: %v", err)
+	if err := os.Rename(partName, fileName); err != nil {
+		return fmt.Errorf("写入本地文件失败: %v", err)
 	}
-	fileSize := fileInfo.Size()
+	return nil
+}
 
-	// 设置分片大小为配置的 chunkSize
-	partSize := chunkSize
+// getPodList 返回要处理的 pod，指定了 pods 时逐个获取，无法获取的 pod 及其错误在 missing 中返回
+func getPodList(ctx context.Context, clientset kubernetes.Interface, namespace string, pods []string, label string) (*v1.PodList, map[string]error, error) {
+	var options metav1.ListOptions
 
-	// 打开文件
-	file, err := os.Open(fileName)
-	if err != nil {
-		return fmt.Errorf("打开文件失败: %v", err)
+	if label != "" {
+		options.LabelSelector = label
 	}
-	defer file.Close()
 
-	// 初始化分片上传
-	imur, err := bucket.InitiateMultipartUpload(fileName, options...)
-	if err != nil {
-		return fmt.Errorf("初始化分片上传失败: %v", err)
+	if len(pods) > 0 {
+		podList := &v1.PodList{
+			Items: []v1.Pod{},
+		}
+		missing := map[string]error{}
+		for _, podName := range pods {
+			pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+			if err != nil {
+				log(0, "获取 pod %s 失败: %v", podName, err)
+				missing[podName] = fmt.Errorf("获取 pod 失败: %v", err)
+				continue
+			}
+			podList.Items = append(podList.Items, *pod)
+		}
+		return podList, missing, nil
 	}
 
-	// 创建进度条
-	bar := progressbar.DefaultBytes(
-		fileSize,
-		fileName+" 正在上传",
-	)
+	podList, err := clientset.CoreV1().Pods(namespace).List(ctx, options)
+	return podList, nil, err
+}
 
-	// 分片上传
-	var parts []oss.UploadPart
-	for i := int64(0); i < fileSize; i += partSize {
-		end := i + partSize
-		if end > fileSize {
-			end = fileSize
-		}
-		partSize := end - i
+func flushData(ctx context.Context, executor PodExecutor, namespace, podName, containerName string) error {
+	cmd := []string{"/iotdb/sbin/start-cli.sh", "-h", "iotdb-datanode", "-e", "flush on cluster"}
+	stdout, stderr, err := retryPodCommand(ctx, executor, "刷新数据", namespace, podName, containerName, cmd)
+	if err != nil {
+		return fmt.Errorf("error streaming command: %w, stderr: %s", err, stderr)
+	}
 
-		// 创一个制读取大小的 Reader
-		partReader := io.LimitReader(file, partSize)
+	log(2, "Flush command output: %s", stdout)
 
-		part, err := bucket.UploadPart(imur, partReader, partSize, int(i/partSize)+1)
-		if err != nil {
-			bucket.AbortMultipartUpload(imur)
-			return fmt.Errorf("上传分片失败: %v", err)
-		}
-		parts = append(parts, part)
-		bar.Add(int(partSize))
+	select {
+	case <-time.After(5 * time.Second):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	// 完成分片上传
-	_, err = bucket.CompleteMultipartUpload(imur, parts)
-	if err != nil {
-		return fmt.Errorf("完成分片上传失败: %v", err)
+func getBackupFileName(podName, customName, ext string) string {
+	if customName != "" {
+		return fmt.Sprintf("%s_%s_%s%s", customName, podName, time.Now().Format("20060102150405"), ext)
 	}
-
-	log(2, "文件 %s 已上传到OSS，将在 %s 后自动删除", fileName, expirationTime.Format("2006-01-02 15:04:05"))
-	return nil
+	return fmt.Sprintf("%s_%s%s", podName, time.Now().Format("20060102150405"), ext)
 }
 
 func deleteLocalFile(fileName string) error {
@@ -644,128 +760,81 @@ func deleteLocalFile(fileName string) error {
 	return nil
 }
 
-func loadCredentials(path string) (map[string]string, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	creds := make(map[string]string)
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) == 2 {
-			creds[parts[0]] = parts[1]
-		}
-	}
-	if err := scanner.Err(); err != nil {
-		return nil, err
-	}
-
-	return creds, nil
-}
-
 func log(level int, format string, args ...interface{}) {
 	if verbose >= level {
 		fmt.Printf(format+"\n", args...)
 	}
 }
 
-func sendWeChatNotification(clusterName, namespace, podName, bucketName string, duration time.Duration, backupFileName string) error {
-	webhookURL := "https://qyapi.weixin.qq.com/cgi-bin/webhook/send?key=key"
-
-	credentials, err := loadCredentials(".credentials")
-	if err != nil {
-		return fmt.Errorf("加载凭证失败: %v", err)
-	}
-
-	// 构造 OSS 下载 URL
-	ossURL := constructOSSURL(credentials["ENDPOINT"], bucketName, backupFileName)
-
-	content := fmt.Sprintf(`备份完成通知
-> **集群**：%s
-> **命名空间**：%s
-> **Pod**：%s
-> **OSS 下载地址**：%s
-> **耗时**：%.2f 秒`, clusterName, namespace, podName, ossURL, duration.Seconds())
-
-	payload := map[string]interface{}{
-		"msgtype": "markdown",
-		"markdown": map[string]string{
-			"content": content,
-		},
-	}
-
-	jsonPayload, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("JSON 编码失败: %v", err)
-	}
-
-	resp, err := http.Post(webhookURL, "application/json", bytes.NewBuffer(jsonPayload))
-	if err != nil {
-		return fmt.Errorf("发送通知失败: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("通知发送失败，状态码: %d", resp.StatusCode)
-	}
-
-	return nil
+// sendRunNotification 发送一次备份运行的汇总通知，全部成功时类型为 success，否则为 failure
+func sendRunNotification(ctx context.Context, summary *RunSummary) error {
+	kind, title := notifySuccess, "备份完成通知"
+	switch summary.Status {
+	case backupStatusPartial:
+		kind, title = notifyFailure, "备份部分失败通知"
+	case backupStatusFailed:
+		kind, title = notifyFailure, "备份失败通知"
+	}
+	n := newNotification(kind, title, summary.fields()...)
+	n.Runs = []*RunSummary{summary}
+	return sendNotification(ctx, n)
 }
 
-func sendFailureNotification(clusterName, namespace, podName string, err error) error {
-	webhookURL := "https://qyapi.weixin.qq.com/cgi-bin/webhook/send?key=key"
-
-	content := fmt.Sprintf(`备份失败通知
-> **集群**：%s
-> **命名空间**：%s
-> **Pod**：%s
-> **错误信息**：%v`, clusterName, namespace, podName, err)
-
-	payload := map[string]interface{}{
-		"msgtype": "markdown",
-		"markdown": map[string]string{
-			"content": content,
-		},
-	}
-
-	jsonPayload, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("JSON 编码失败: %v", err)
-	}
+// sendPodFailureNotification 在一个 pod 备份失败时立即发送通知
+func sendPodFailureNotification(ctx context.Context, clusterName, namespace, podName string, err error) error {
+	return sendNotification(ctx, newNotification(notifyPodFailure, "Pod 备份失败通知",
+		field("集群", clusterName),
+		field("命名空间", namespace),
+		field("Pod", podName),
+		field("错误信息", err)))
+}
 
-	resp, err := http.Post(webhookURL, "application/json", bytes.NewBuffer(jsonPayload))
-	if err != nil {
-		return fmt.Errorf("发送通知失败: %v", err)
+// sendClustersNotification 发送多集群备份的汇总通知，每个集群一个字段
+func sendClustersNotification(ctx context.Context, results []*ClusterResult, duration time.Duration) error {
+	failed := 0
+	fields := []NotificationField{}
+	runs := make([]*RunSummary, 0, len(results))
+	for _, r := range results {
+		runs = append(runs, r.runSummary())
+		succeeded, failedPods, size := r.counts()
+		value := fmt.Sprintf("%s，成功 %d，失败 %d，%s", r.Status, succeeded, failedPods, formatSize(size))
+		if r.Error != "" {
+			value += "，" + r.Error
+		}
+		if r.Status != backupStatusSuccess {
+			failed++
+		}
+		fields = append(fields, field(r.Cluster+"/"+r.Namespace, value))
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("通知发送失败，状态码: %d", resp.StatusCode)
+	title := "多集群备份完成通知"
+	if failed > 0 {
+		title = "多集群备份失败通知"
 	}
-
-	return nil
+	fields = append([]NotificationField{field("集群数", fmt.Sprintf("%d，失败 %d", len(results), failed))}, fields...)
+	fields = append(fields, field("耗时", formatSeconds(duration)))
+	n := newNotification(notifySummary, title, fields...)
+	n.Runs = runs
+	return sendNotification(ctx, n)
 }
 
 func constructOSSURL(endpoint, bucketName, fileName string) string {
 	// 移除 endpoint 中的 "http://" 或 "https://"
 	endpoint = strings.TrimPrefix(strings.TrimPrefix(endpoint, "http://"), "https://")
 
-	// 构造 OSS URL
-	ossURL := fmt.Sprintf("https://%s.%s/%s", bucketName, endpoint, url.PathEscape(fileName))
+	// 构造 OSS URL，fileName 可能包含多级目录，逐级转义
+	segments := strings.Split(fileName, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	escapedName := strings.Join(segments, "/")
+	ossURL := fmt.Sprintf("https://%s.%s/%s", bucketName, endpoint, escapedName)
 
 	// 处理多级目录的情况
 	if strings.Contains(bucketName, "/") {
 		parts := strings.SplitN(bucketName, "/", 2)
 		bucketName = parts[0]
 		prefix := parts[1]
-		ossURL = fmt.Sprintf("https://%s.%s/%s/%s", bucketName, endpoint, prefix, url.PathEscape(fileName))
+		ossURL = fmt.Sprintf("https://%s.%s/%s/%s", bucketName, endpoint, prefix, escapedName)
 	}
 
 	return ossURL
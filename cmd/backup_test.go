@@ -0,0 +1,482 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	testNamespace = "iotdb"
+	testPod       = "iotdb-datanode-0"
+	testContainer = "iotdb-datanode"
+	testDataDir   = "/iotdb/data/test"
+)
+
+// fakePod 用 fakeExecutor 模拟 pod 中备份和恢复用到的命令：tar 打包 files，
+// 解压到 extracted，find 列出文件，cat 和 sha256sum 读取文件，start-cli.sh 加载 tsfile
+type fakePod struct {
+	mu        sync.Mutex
+	files     map[string]string
+	modTimes  map[string]time.Time
+	extracted map[string]string
+	loaded    []string
+	// reading 不为空时 cat 输出它而不是文件内容，模拟文件在读取过程中被修改
+	reading string
+	// programs 是 pod 中安装的压缩程序，tar 只模拟 gzip
+	programs []string
+}
+
+func newFakePod(executor *fakeExecutor) *fakePod {
+	p := &fakePod{files: map[string]string{}, modTimes: map[string]time.Time{}, extracted: map[string]string{}}
+	executor.on("tar", "--warning=no-file-changed", "-cf", "-").do(p.tar)
+	executor.on("find", testDataDir).do(p.find)
+	executor.on("cat", "--").do(p.cat)
+	executor.on("rm", "-rf").do(func(cmd []string, stdin io.Reader, stdout, stderr io.Writer) error {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.extracted = map[string]string{}
+		return nil
+	})
+	executor.on("sh", "-c").do(p.shell)
+	return p
+}
+
+func (p *fakePod) write(name, content string, modTime time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.files[name], p.modTimes[name] = content, modTime
+}
+
+func (p *fakePod) remove(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.files, name)
+	delete(p.modTimes, name)
+}
+
+// tar 打包整个数据目录，或者 -T - 时打包标准输入中列出的文件，--use-compress-program=gzip 时压缩
+func (p *fakePod) tar(cmd []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if program := strings.TrimPrefix(cmd[len(cmd)-1], "--use-compress-program="); program != cmd[len(cmd)-1] {
+		if program != "gzip" {
+			return fmt.Errorf("fake: 不支持的压缩程序: %s", program)
+		}
+		gw := gzip.NewWriter(stdout)
+		defer gw.Close()
+		stdout = gw
+	}
+	var names []string
+	if cmd[4] == "-T" {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return err
+		}
+		names = strings.Fields(string(data))
+	} else {
+		for name := range p.files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	tw := tar.NewWriter(stdout)
+	for _, name := range names {
+		content, ok := p.files[name]
+		if !ok {
+			return fmt.Errorf("tar: %s: No such file or directory", name)
+		}
+		header := &tar.Header{Name: strings.TrimPrefix(name, "/"), Mode: 0644, Size: int64(len(content)), ModTime: p.modTimes[name]}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(tw, content); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// find 按 listPodFiles 的 -printf 格式输出数据目录中的文件
+func (p *fakePod) find(cmd []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var names []string
+	for name := range p.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		content, modTime := p.files[name], p.modTimes[name]
+		fmt.Fprintf(stdout, "%d %d.%09d %s\n", len(content), modTime.Unix(), modTime.Nanosecond(), name)
+	}
+	return nil
+}
+
+func (p *fakePod) cat(cmd []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	content, ok := p.files[cmd[2]]
+	if !ok {
+		return fmt.Errorf("cat: %s: No such file or directory", cmd[2])
+	}
+	if p.reading != "" {
+		content = p.reading
+	}
+	_, err := io.WriteString(stdout, content)
+	return err
+}
+
+func (p *fakePod) shell(cmd []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	script := cmd[2]
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case strings.HasPrefix(script, "command -v "):
+		name := strings.TrimPrefix(script, "command -v ")
+		for _, program := range p.programs {
+			if program == name {
+				fmt.Fprintln(stdout, "/usr/bin/"+name)
+				return nil
+			}
+		}
+		return fmt.Errorf("exit status 127")
+	case strings.HasPrefix(script, "mkdir -p"):
+		tr := tar.NewReader(stdin)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			content, err := io.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			p.extracted[path.Join(restoreDir, header.Name)] = string(content)
+		}
+	case strings.HasPrefix(script, "find "):
+		dir := strings.Split(script, "'")[1]
+		for name := range p.extracted {
+			if strings.HasPrefix(name, dir+"/") && strings.HasSuffix(name, ".tsfile") {
+				fmt.Fprintln(stdout, name)
+			}
+		}
+	case strings.Contains(script, "sha256sum"):
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return err
+		}
+		for _, name := range strings.Fields(string(data)) {
+			sum := sha256.Sum256([]byte(p.files[name]))
+			fmt.Fprintf(stdout, "%s  %s\n", hex.EncodeToString(sum[:]), name)
+		}
+	case strings.HasPrefix(script, "while IFS= read"):
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return err
+		}
+		for _, name := range strings.Fields(string(data)) {
+			delete(p.extracted, name)
+		}
+	case strings.Contains(script, "start-cli.sh"):
+		p.loaded = append(p.loaded, script)
+	default:
+		return fmt.Errorf("fake: 未知的脚本: %s", script)
+	}
+	return nil
+}
+
+// restoredFiles 返回解压出的文件，路径去掉解压目录前缀后与 pod 中的原路径相同
+func (p *fakePod) restoredFiles() map[string]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	files := map[string]string{}
+	for name, content := range p.extracted {
+		files[strings.TrimPrefix(name, restoreDir)] = content
+	}
+	return files
+}
+
+// setupBackupTest 设置备份和恢复用到的全局参数，使用临时目录作为存储，测试结束后恢复原来的参数
+func setupBackupTest(t *testing.T) (*fileStorage, v1.Pod) {
+	savedNamespace, savedCluster, savedContainers, savedDataDir, savedRestoreDir := namespace, clusterName, containers, dataDir, restoreDir
+	savedUpload, savedKeepLocal, savedIncremental, savedDedup, savedOutName := uploadOSS, keepLocal, incremental, dedup, outName
+	savedRetry, savedCompressInPod := retryPolicy, compressInPod
+	t.Cleanup(func() {
+		namespace, clusterName, containers, dataDir, restoreDir = savedNamespace, savedCluster, savedContainers, savedDataDir, savedRestoreDir
+		uploadOSS, keepLocal, incremental, dedup, outName = savedUpload, savedKeepLocal, savedIncremental, savedDedup, savedOutName
+		retryPolicy, compressInPod = savedRetry, savedCompressInPod
+	})
+	// 数据目录不是 /iotdb/data/datanode 时跳过刷新数据
+	namespace, clusterName, containers, dataDir, restoreDir = testNamespace, "test", testContainer, testDataDir, "/iotdb/data/restore"
+	uploadOSS, keepLocal, incremental, dedup, outName = true, false, false, false, ""
+	retryPolicy.MaxAttempts, compressInPod = 1, false
+
+	store, err := newFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientset := fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: testPod, Namespace: testNamespace},
+		Spec: v1.PodSpec{Containers: []v1.Container{
+			{Name: testContainer, Image: "apache/iotdb:1.3.2-datanode"},
+		}},
+	})
+	podList, missing, err := getPodList(context.Background(), clientset, testNamespace, []string{testPod}, "")
+	if err != nil || len(missing) > 0 || len(podList.Items) != 1 {
+		t.Fatalf("获取 pod 失败: %v %v", err, missing)
+	}
+	return store, podList.Items[0]
+}
+
+// runTestBackup 备份一次 pod 并保存清单，返回清单中的记录
+func runTestBackup(t *testing.T, executor PodExecutor, store Storage, pod v1.Pod, history map[string]runEntry) ManifestEntry {
+	t.Helper()
+	comp, err := newCompressor(compressionGzip, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := flagTarget()
+	run := &backupRun{target: target, store: store, comp: comp, manifest: newBackupManifest(time.Now(), target.cluster, target.namespace), history: history, metrics: newBackupMetrics()}
+	if err := backupPod(context.Background(), executor, run, pod); err != nil {
+		t.Fatalf("备份失败: %v", err)
+	}
+	if err := saveManifest(context.Background(), store, run.manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(run.manifest.Entries) != 1 {
+		t.Fatalf("清单中有 %d 条记录，预期 1 条", len(run.manifest.Entries))
+	}
+	entry := run.manifest.Entries[0]
+	if entry.Status != backupStatusSuccess {
+		t.Fatalf("备份状态为 %s: %s", entry.Status, entry.Error)
+	}
+	return entry
+}
+
+// restoreTestBackup 按 restore 命令的流程恢复 key 到 pod 中
+func restoreTestBackup(t *testing.T, executor PodExecutor, store Storage, pod v1.Pod, key string) error {
+	t.Helper()
+	steps, removed, err := restorePlan(context.Background(), store, key)
+	if err != nil {
+		t.Fatalf("读取备份清单失败: %v", err)
+	}
+	return restorePod(context.Background(), executor, store, nil, pod, steps, removed)
+}
+
+func TestBackupAndRestoreFull(t *testing.T) {
+	store, pod := setupBackupTest(t)
+	executor := newFakeExecutor()
+	p := newFakePod(executor)
+	now := time.Now().UTC()
+	p.write(testDataDir+"/sequence/root.sg/0/0/1-1-0-0.tsfile", "tsfile 1", now)
+	p.write(testDataDir+"/sequence/root.sg/0/0/1-1-0-0.tsfile.resource", "resource 1", now)
+	p.write(testDataDir+"/sequence/root.sg/0/0/2-2-0-0.tsfile", "tsfile 2", now)
+
+	entry := runTestBackup(t, executor, store, pod, nil)
+	if entry.Key == "" || entry.Image != "apache/iotdb:1.3.2-datanode" {
+		t.Fatalf("清单记录不完整: %+v", entry)
+	}
+
+	// 校验和与运行 ID 写入对象元数据，verify 和 restore 不需要读取清单
+	info, err := store.Stat(context.Background(), entry.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Metadata[metaSHA256] != entry.SHA256 || info.Size != entry.Size {
+		t.Fatalf("对象元数据 %v 大小 %d 与清单 %s %d 不一致", info.Metadata, info.Size, entry.SHA256, entry.Size)
+	}
+	if _, err := verifyObject(context.Background(), store, entry.Key, entry.SHA256); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := restoreTestBackup(t, executor, store, pod, entry.Key); err != nil {
+		t.Fatalf("恢复失败: %v", err)
+	}
+	// 加载完成后删除解压目录
+	if restored := p.restoredFiles(); len(restored) != 0 {
+		t.Fatalf("解压目录没有删除: %v", restored)
+	}
+	if len(p.loaded) != 2 {
+		t.Fatalf("加载了 %d 个 tsfile，预期 2 个: %v", len(p.loaded), p.loaded)
+	}
+}
+
+func TestRestoreUsesBackupDataDir(t *testing.T) {
+	store, pod := setupBackupTest(t)
+	executor := newFakeExecutor()
+	p := newFakePod(executor)
+	p.write(testDataDir+"/a.tsfile", "a", time.Now().UTC())
+	entry := runTestBackup(t, executor, store, pod, nil)
+
+	// 恢复时的 --datadir 与备份时不同，按清单中记录的数据目录查找解压出的 tsfile
+	dataDir = "/iotdb/data/datanode"
+	if err := restoreTestBackup(t, executor, store, pod, entry.Key); err != nil {
+		t.Fatalf("恢复失败: %v", err)
+	}
+	if len(p.loaded) != 1 || !strings.Contains(p.loaded[0], path.Join(restoreDir, testDataDir, "a.tsfile")) {
+		t.Fatalf("加载的 tsfile: %v", p.loaded)
+	}
+}
+
+func TestBackupCompressInPod(t *testing.T) {
+	store, pod := setupBackupTest(t)
+	compressInPod = true
+	executor := newFakeExecutor()
+	p := newFakePod(executor)
+	p.write(testDataDir+"/a.tsfile", "a", time.Now().UTC())
+
+	// pod 中没有 gzip 时回退到本地压缩
+	first := runTestBackup(t, executor, store, pod, nil)
+	if lines := strings.Join(executor.commandLines(), "\n"); strings.Contains(lines, "--use-compress-program") {
+		t.Fatalf("pod 中没有 gzip 时不应在 pod 中压缩: %s", lines)
+	}
+
+	// 有 gzip 时由 pod 中的 tar 压缩，备份格式不变，restore 不需要区分
+	p.programs = []string{"gzip"}
+	second := runTestBackup(t, executor, store, pod, nil)
+	if lines := strings.Join(executor.commandLines(), "\n"); !strings.Contains(lines, "--use-compress-program=gzip") {
+		t.Fatalf("没有在 pod 中压缩: %s", lines)
+	}
+	for _, entry := range []ManifestEntry{first, second} {
+		if err := restoreTestBackup(t, executor, store, pod, entry.Key); err != nil {
+			t.Fatalf("恢复 %s 失败: %v", entry.Key, err)
+		}
+		if len(p.loaded) == 0 || !strings.Contains(p.loaded[len(p.loaded)-1], "a.tsfile") {
+			t.Fatalf("加载的 tsfile: %v", p.loaded)
+		}
+	}
+}
+
+func TestBackupAndRestoreIncremental(t *testing.T) {
+	store, pod := setupBackupTest(t)
+	incremental = true
+	executor := newFakeExecutor()
+	p := newFakePod(executor)
+	first := time.Now().UTC().Add(-time.Hour)
+	p.write(testDataDir+"/a.tsfile", "a", first)
+	p.write(testDataDir+"/b.tsfile", "b", first)
+	p.write(testDataDir+"/c.tsfile", "c", first)
+
+	full := runTestBackup(t, executor, store, pod, nil)
+	if full.Type != backupTypeFull || full.ChangedFiles != 3 {
+		t.Fatalf("第一次备份应为全量备份: %+v", full)
+	}
+
+	// b 被修改、c 被合并删除、新增 d
+	second := first.Add(time.Minute)
+	p.write(testDataDir+"/b.tsfile", "b v2", second)
+	p.remove(testDataDir + "/c.tsfile")
+	p.write(testDataDir+"/d.tsfile", "d", second)
+
+	history, err := manifestEntries(context.Background(), store, clusterName, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 与第一次备份在同一秒内时文件名相同，用 --outname 区分
+	outName = "incr"
+	inc := runTestBackup(t, executor, store, pod, history)
+	if inc.Type != backupTypeIncremental || inc.Parent != full.Key || inc.ChangedFiles != 2 {
+		t.Fatalf("第二次备份应为以 %s 为父备份、包含 2 个文件的增量备份: %+v", full.Key, inc)
+	}
+	var tarStdin string
+	for _, call := range executor.calls {
+		if len(call.command) > 4 && call.command[0] == "tar" && call.command[4] == "-T" {
+			tarStdin = string(call.stdin)
+		}
+	}
+	if tarStdin != testDataDir+"/b.tsfile\n"+testDataDir+"/d.tsfile\n" {
+		t.Fatalf("增量备份打包的文件: %q", tarStdin)
+	}
+
+	// 恢复时依次解压全量和增量备份，删除已不存在的 c，在加载前检查解压目录
+	steps, removed, err := restorePlan(context.Background(), store, inc.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 || steps[0].key != full.Key || steps[1].key != inc.Key {
+		t.Fatalf("恢复步骤: %+v", steps)
+	}
+	for _, step := range steps {
+		if err := extractToPod(context.Background(), executor, store, nil, step.key, step.codec, step.checksum, pod.Name, testContainer); err != nil {
+			t.Fatalf("解压 %s 失败: %v", step.key, err)
+		}
+	}
+	if err := removeRestoredFiles(context.Background(), executor, pod.Name, testContainer, removed); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{testDataDir + "/a.tsfile": "a", testDataDir + "/b.tsfile": "b v2", testDataDir + "/d.tsfile": "d"}
+	if restored := p.restoredFiles(); fmt.Sprint(restored) != fmt.Sprint(want) {
+		t.Fatalf("恢复的文件 %v，预期 %v", restored, want)
+	}
+}
+
+func TestRestoreChecksumMismatch(t *testing.T) {
+	store, pod := setupBackupTest(t)
+	executor := newFakeExecutor()
+	p := newFakePod(executor)
+	p.write(testDataDir+"/a.tsfile", "a", time.Now().UTC())
+	entry := runTestBackup(t, executor, store, pod, nil)
+
+	// 用另一个有效的备份替换存储中的文件，解压可以成功，只有校验和不一致
+	p.write(testDataDir+"/a.tsfile", "tampered", time.Now().UTC())
+	var tampered bytes.Buffer
+	comp, _ := newCompressor(compressionGzip, 0, 1)
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(p.tar([]string{"tar", "--warning=no-file-changed", "-cf", "-", testDataDir}, nil, writer, io.Discard))
+	}()
+	if _, err := io.Copy(&tampered, comp.compressStream(reader)); err != nil {
+		t.Fatal(err)
+	}
+	objectPath, _ := store.filePath(entry.Key)
+	if err := os.WriteFile(objectPath, tampered.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// verify-first 时在解压前发现不一致
+	var mismatch *checksumMismatchError
+	if _, err := verifyObject(context.Background(), store, entry.Key, entry.SHA256); !errors.As(err, &mismatch) {
+		t.Fatalf("预期校验和不一致，实际: %v", err)
+	}
+
+	// 边解压边校验时，恢复失败、删除已解压的文件且不加载任何 tsfile
+	err := restoreTestBackup(t, executor, store, pod, entry.Key)
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("预期校验和不一致，实际: %v", err)
+	}
+	if mismatch.expected != entry.SHA256 {
+		t.Fatalf("预期的校验和 %s，清单中为 %s", mismatch.expected, entry.SHA256)
+	}
+	if restored := p.restoredFiles(); len(restored) != 0 {
+		t.Fatalf("校验失败后解压目录没有删除: %v", restored)
+	}
+	if len(p.loaded) != 0 {
+		t.Fatalf("校验失败后不应加载 tsfile: %v", p.loaded)
+	}
+	lines := executor.commandLines()
+	if last := lines[len(lines)-1]; last != "rm -rf "+restoreDir {
+		t.Fatalf("最后执行的命令: %s", last)
+	}
+}
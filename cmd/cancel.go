@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var (
+	flushTimeout    time.Duration
+	compressTimeout time.Duration
+	uploadTimeout   time.Duration
+)
+
+// cleanupTimeout 是中断后取消分片上传、删除 pod 中临时文件、保存清单的时间上限
+const cleanupTimeout = time.Minute
+
+// signalContext 返回收到 SIGINT/SIGTERM 时取消的 context。第一次收到信号时取消正在进行的操作并清理，
+// 之后恢复默认的信号处理，再次按 Ctrl-C 立即退出
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			fmt.Printf("收到信号 %v，正在取消上传并清理临时文件，再次按 Ctrl-C 立即退出\n", sig)
+			signal.Stop(signals)
+			cancel()
+		case <-ctx.Done():
+			signal.Stop(signals)
+		}
+	}()
+	return ctx, cancel
+}
+
+// cleanupContext 返回清理操作使用的 context，不随 ctx 取消，避免中断后无法取消上传或删除临时文件
+func cleanupContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), cleanupTimeout)
+}
+
+// stepContext 返回一个步骤使用的 context，timeout 为 0 时不限制时间
+func stepContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// stepTimeoutError 是步骤超过 --flush-timeout 等时间上限的错误，不会重试
+type stepTimeoutError struct {
+	flag    string
+	timeout time.Duration
+	err     error
+}
+
+func (e *stepTimeoutError) Error() string {
+	return fmt.Sprintf("超过 %s %v: %v", e.flag, e.timeout, e.err)
+}
+
+func (e *stepTimeoutError) Unwrap() error {
+	return e.err
+}
+
+// stepTimeout 在 stepCtx 因超时结束时用 stepTimeoutError 说明是哪个超时参数，其他错误原样返回
+func stepTimeout(stepCtx context.Context, flag string, timeout time.Duration, err error) error {
+	var timeoutErr *stepTimeoutError
+	if err == nil || errors.As(err, &timeoutErr) || !errors.Is(stepCtx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	return &stepTimeoutError{flag: flag, timeout: timeout, err: err}
+}
+
+// interrupted 判断 ctx 是否因收到信号而取消
+func interrupted(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.Canceled)
+}
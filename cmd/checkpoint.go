@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	checkpointDir string
+	// keepInterruptedUploads 为 true 时，Ctrl-C 中断后保留有断点的分片上传以便续传，
+	// 只在命令行或配置文件中明确设置了 --checkpoint-dir 时开启，否则中断时取消分片上传并删除断点
+	keepInterruptedUploads bool
+)
+
+// checkpointMaxAge 之后的断点不再续传，重新运行时开始新的备份，原来的分片上传由 cleanup-uploads 清理
+const checkpointMaxAge = 24 * time.Hour
+
+// uploadCheckpoint 记录一个备份的分片上传进度，上传中断后重新运行同一个备份时从断点继续。
+// 续传时重新生成备份流，与断点中 SHA-256 相同的分片不再上传，其他分片重新上传
+type uploadCheckpoint struct {
+	Identity  string           `json:"identity"`
+	Key       string           `json:"key"`
+	FileName  string           `json:"fileName"`
+	UploadID  string           `json:"uploadId,omitempty"`
+	PartSize  int64            `json:"partSize,omitempty"`
+	Parts     []checkpointPart `json:"parts,omitempty"`
+	StartTime time.Time        `json:"startTime"`
+
+	path string
+	// 多个分片同时上传时保护 Parts 和断点文件
+	mu sync.Mutex
+}
+
+// checkpointPart 是断点中一个已上传的分片
+type checkpointPart struct {
+	Number int    `json:"number"`
+	ETag   string `json:"etag"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// backupIdentity 标识"同一个备份"：存储、集群、命名空间、pod、容器、数据目录、文件名前缀和扩展名都相同
+func backupIdentity(target backupTarget, podName, containerName, ext string) string {
+	return strings.Join([]string{target.storage, target.cluster, target.namespace, podName, containerName, target.dataDir, outName, ext}, "|")
+}
+
+func checkpointPath(identity string) string {
+	sum := sha256.Sum256([]byte(identity))
+	return filepath.Join(checkpointDir, hex.EncodeToString(sum[:8])+".json")
+}
+
+// loadUploadCheckpoint 读取备份的断点，没有断点或断点已过期时返回 nil
+func loadUploadCheckpoint(identity string) (*uploadCheckpoint, error) {
+	path := checkpointPath(identity)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取断点文件 %s 失败: %v", path, err)
+	}
+	cp := &uploadCheckpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("解析断点文件 %s 失败: %v", path, err)
+	}
+	cp.path = path
+	if cp.Identity != identity {
+		return nil, nil
+	}
+	if time.Since(cp.StartTime) > checkpointMaxAge {
+		log(1, "断点 %s 已超过 %v，开始新的备份", path, checkpointMaxAge)
+		cp.remove()
+		return nil, nil
+	}
+	return cp, nil
+}
+
+func newUploadCheckpoint(identity, key, fileName string) *uploadCheckpoint {
+	return &uploadCheckpoint{
+		Identity:  identity,
+		Key:       key,
+		FileName:  fileName,
+		StartTime: time.Now(),
+		path:      checkpointPath(identity),
+	}
+}
+
+// part 返回断点中编号为 number 的分片
+func (cp *uploadCheckpoint) part(number int) (checkpointPart, bool) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	for _, part := range cp.Parts {
+		if part.Number == number {
+			return part, true
+		}
+	}
+	return checkpointPart{}, false
+}
+
+// setPart 记录上传完成的分片并保存断点
+func (cp *uploadCheckpoint) setPart(part checkpointPart) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	parts := cp.Parts[:0]
+	for _, p := range cp.Parts {
+		if p.Number != part.Number {
+			parts = append(parts, p)
+		}
+	}
+	cp.Parts = append(parts, part)
+	sort.Slice(cp.Parts, func(i, j int) bool {
+		return cp.Parts[i].Number < cp.Parts[j].Number
+	})
+	if err := cp.save(); err != nil {
+		log(0, "保存断点失败: %v", err)
+	}
+}
+
+// save 先写临时文件再重命名，避免中断时留下不完整的断点文件
+func (cp *uploadCheckpoint) save() error {
+	if err := os.MkdirAll(filepath.Dir(cp.path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := cp.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cp.path)
+}
+
+func (cp *uploadCheckpoint) remove() {
+	if err := os.Remove(cp.path); err != nil && !os.IsNotExist(err) {
+		log(1, "删除断点文件 %s 失败: %v", cp.path, err)
+	}
+}
+
+// reset 放弃断点中的分片上传，从头开始
+func (cp *uploadCheckpoint) reset() {
+	cp.UploadID, cp.PartSize, cp.Parts = "", 0, nil
+}
+
+// resume 向存储确认断点中的分片上传仍然存在，只保留存储中存在且 ETag 一致的分片。
+// 分片上传已不存在或分片大小变化时返回 false，需要开始新的分片上传
+func (cp *uploadCheckpoint) resume(ctx context.Context, u multipartUploader, partSize int64) bool {
+	if cp.UploadID == "" {
+		return false
+	}
+	if cp.PartSize != partSize {
+		log(1, "分片大小已从 %d 变为 %d，放弃未完成的上传 %s", cp.PartSize, partSize, cp.Key)
+		u.abortUpload(ctx, cp.Key, cp.UploadID)
+		cp.reset()
+		return false
+	}
+	remote, err := u.listParts(ctx, cp.Key, cp.UploadID)
+	if err != nil {
+		log(1, "未完成的上传 %s 已不存在，重新上传: %v", cp.Key, err)
+		cp.reset()
+		return false
+	}
+	etags := map[int]string{}
+	for _, part := range remote {
+		etags[part.Number] = normalizeETag(part.ETag)
+	}
+	parts := cp.Parts[:0]
+	for _, part := range cp.Parts {
+		if etags[part.Number] == normalizeETag(part.ETag) {
+			parts = append(parts, part)
+		}
+	}
+	cp.Parts = parts
+	log(1, "从断点继续上传 %s，已上传 %d 个分片", cp.Key, len(cp.Parts))
+	return true
+}
+
+func normalizeETag(etag string) string {
+	return strings.ToUpper(strings.Trim(etag, `"`))
+}
+
+type checkpointContextKey struct{}
+
+// withUploadCheckpoint 让 ctx 携带上传断点，支持分片上传的存储在 Put 中使用并更新断点，其他存储忽略
+func withUploadCheckpoint(ctx context.Context, cp *uploadCheckpoint) context.Context {
+	if cp == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, checkpointContextKey{}, cp)
+}
+
+func checkpointFromContext(ctx context.Context) *uploadCheckpoint {
+	cp, _ := ctx.Value(checkpointContextKey{}).(*uploadCheckpoint)
+	return cp
+}
@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// 备份文件上传完成后，校验和以 sha256sum 兼容的格式写入同名的 .sha256 对象，并记录在备份清单中；
+// 上传前已知校验和（快照文件）或后端可以直接更新元数据（本地文件）时同时写入对象元数据 sha256。
+// 对象元数据 run-id 在上传时写入，是备份所属的运行，据此直接读取对应的备份清单
+const (
+	checksumSuffix = ".sha256"
+	metaSHA256     = "sha256"
+	metaRunID      = "run-id"
+)
+
+func checksumKey(key string) string {
+	return key + checksumSuffix
+}
+
+func isChecksumKey(key string) bool {
+	return strings.HasSuffix(key, checksumSuffix)
+}
+
+// formatChecksum 返回 sha256sum 格式的校验和内容
+func formatChecksum(sum, fileName string) []byte {
+	return []byte(fmt.Sprintf("%s  %s\n", sum, path.Base(fileName)))
+}
+
+// checksumMetadata 返回记录校验和及所属运行的对象元数据
+func checksumMetadata(sum, runID string) map[string]string {
+	return map[string]string{metaSHA256: sum, metaRunID: runID}
+}
+
+// writeChecksum 将备份文件的校验和写入 .sha256 对象
+func writeChecksum(ctx context.Context, store Storage, key, sum string) error {
+	data := formatChecksum(sum, key)
+	return store.Put(ctx, checksumKey(key), bytes.NewReader(data), int64(len(data)), nil)
+}
+
+// recordChecksum 在流式上传完成后将校验和写入 .sha256 对象，后端支持时同时写入对象元数据
+func recordChecksum(ctx context.Context, store Storage, key, sum string) error {
+	if err := writeChecksum(ctx, store, key, sum); err != nil {
+		return err
+	}
+	if u, ok := store.(metadataUpdater); ok {
+		if err := u.updateMetadata(ctx, key, map[string]string{metaSHA256: sum}); err != nil {
+			return fmt.Errorf("写入对象元数据失败: %v", err)
+		}
+	}
+	return nil
+}
+
+// readChecksum 读取备份文件的 .sha256 对象，不存在时返回 ErrObjectNotFound
+func readChecksum(ctx context.Context, store Storage, key string) (string, error) {
+	body, err := store.Get(ctx, checksumKey(key))
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, 1024))
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", fmt.Errorf("校验和文件 %s 格式错误", checksumKey(key))
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// expectedChecksum 查找备份文件的预期校验和：依次查找对象元数据、.sha256 对象和备份清单。
+// 旧版本的备份没有校验和，此时返回空字符串
+func expectedChecksum(ctx context.Context, store Storage, key string) (string, error) {
+	info, err := store.Stat(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	if sum, err := infoChecksum(ctx, store, info); sum != "" || err != nil {
+		return sum, err
+	}
+	entry, err := findManifestEntry(ctx, store, key)
+	if err != nil || entry == nil {
+		return "", err
+	}
+	return entry.SHA256, nil
+}
+
+// entryChecksum 与 expectedChecksum 相同，但使用调用方已经查找到的清单记录，entry 可以为 nil
+func entryChecksum(ctx context.Context, store Storage, key string, entry *ManifestEntry) (string, error) {
+	info, err := store.Stat(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	if sum, err := infoChecksum(ctx, store, info); sum != "" || err != nil {
+		return sum, err
+	}
+	if entry != nil {
+		return entry.SHA256, nil
+	}
+	return "", nil
+}
+
+// infoChecksum 返回对象元数据或 .sha256 对象中的校验和，都没有时返回空字符串
+func infoChecksum(ctx context.Context, store Storage, info *ObjectInfo) (string, error) {
+	if sum := info.Metadata[metaSHA256]; sum != "" {
+		return strings.ToLower(sum), nil
+	}
+	sum, err := readChecksum(ctx, store, info.Key)
+	if err == ErrObjectNotFound {
+		return "", nil
+	}
+	return sum, err
+}
+
+// findManifestEntry 查找 key 对应的清单记录，找不到时返回 nil。
+// 对象元数据中有 run-id 时直接读取该运行的清单，旧版本的备份只查找备份日期当天和前一天开始的运行的清单
+func findManifestEntry(ctx context.Context, store Storage, key string) (*ManifestEntry, error) {
+	// key 形如 <集群>/<命名空间>/<pod>/<日期>/<文件名>
+	segments := strings.Split(key, "/")
+	if len(segments) < 5 {
+		return nil, nil
+	}
+	info, err := store.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifestKeys []string
+	if runID := info.Metadata[metaRunID]; runID != "" {
+		manifestKeys = []string{manifestKey(segments[0], segments[1], runID)}
+	} else if date, err := time.Parse("20060102", segments[len(segments)-2]); err == nil {
+		prefix := manifestPrefix(segments[0], segments[1])
+		for _, day := range []time.Time{date, date.AddDate(0, 0, -1)} {
+			manifests, err := store.List(ctx, prefix+day.Format("20060102"))
+			if err != nil {
+				return nil, err
+			}
+			// 从最新的清单开始查找
+			for i := len(manifests) - 1; i >= 0; i-- {
+				if strings.HasSuffix(manifests[i].Key, ".json") {
+					manifestKeys = append(manifestKeys, manifests[i].Key)
+				}
+			}
+		}
+	}
+
+	for _, mk := range manifestKeys {
+		m, err := loadManifest(ctx, store, mk)
+		if err != nil {
+			log(1, "读取清单 %s 失败: %v", mk, err)
+			continue
+		}
+		for _, entry := range m.Entries {
+			if entry.Key == key {
+				entry := entry
+				return &entry, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// checksumMismatchError 表示备份内容与记录的校验和不一致
+type checksumMismatchError struct {
+	key      string
+	expected string
+	actual   string
+}
+
+func (e *checksumMismatchError) Error() string {
+	return fmt.Sprintf("备份文件 %s 校验失败: 预期 SHA-256 %s，实际 %s", e.key, e.expected, e.actual)
+}
+
+// verifyObject 读取存储中的备份文件并重新计算 SHA-256，与 expected 不一致时返回 checksumMismatchError
+func verifyObject(ctx context.Context, store Storage, key, expected string) (string, error) {
+	info, err := store.Stat(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("获取备份文件 %s 信息失败: %v", key, err)
+	}
+	body, err := store.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	bar := progressbar.DefaultBytes(
+		info.Size,
+		path.Base(key)+" 正在校验",
+	)
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(hasher, bar), body); err != nil {
+		return "", fmt.Errorf("读取备份文件 %s 失败: %v", key, err)
+	}
+
+	actual := hex.EncodeToString(hasher.Sum(nil))
+	if expected != "" && actual != expected {
+		return actual, &checksumMismatchError{key: key, expected: expected, actual: actual}
+	}
+	return actual, nil
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	uploadsOlderThan time.Duration
+	uploadsDryRun    bool
+)
+
+func init() {
+	cleanupUploadsCmd.Flags().DurationVar(&uploadsOlderThan, "older-than", checkpointMaxAge, "只取消开始时间早于这个时间的分片上传，避免取消正在进行或可以续传的上传")
+	cleanupUploadsCmd.Flags().BoolVar(&uploadsDryRun, "dry-run", false, "只列出未完成的分片上传，不取消")
+	addStorageFlags(cleanupUploadsCmd)
+	rootCmd.AddCommand(cleanupUploadsCmd)
+}
+
+var cleanupUploadsCmd = &cobra.Command{
+	Use:   "cleanup-uploads",
+	Short: "abort orphaned multipart uploads",
+	Long: `列出存储中未完成的分片上传，取消开始时间早于 --older-than 的上传并释放已上传分片占用的空间。
+备份中断后未续传的分片上传不会自动删除，但会一直占用存储空间。`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		store, err := openStorage(ctx)
+		if err != nil {
+			fmt.Printf("打开存储失败: %v\n", err)
+			os.Exit(1)
+		}
+		lister, ok := store.(multipartLister)
+		if !ok {
+			fmt.Printf("存储 %s 不支持分片上传\n", effectiveStorageURL())
+			return
+		}
+
+		uploads, err := lister.listUploads(ctx, "")
+		if err != nil {
+			fmt.Printf("列出未完成的分片上传失败: %v\n", err)
+			os.Exit(1)
+		}
+		sort.Slice(uploads, func(i, j int) bool {
+			return uploads[i].Initiated.Before(uploads[j].Initiated)
+		})
+
+		now := time.Now()
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ACTION\tINITIATED\tAGE\tKEY\tUPLOAD ID")
+		var orphaned []pendingUpload
+		for _, upload := range uploads {
+			action := "keep"
+			if now.Sub(upload.Initiated) > uploadsOlderThan {
+				action = "abort"
+				orphaned = append(orphaned, upload)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", action, upload.Initiated.Local().Format("2006-01-02 15:04:05"),
+				formatAge(now.Sub(upload.Initiated)), upload.Key, upload.UploadID)
+		}
+		w.Flush()
+
+		if uploadsDryRun {
+			fmt.Printf("dry-run 模式，未取消任何上传，将取消 %d 个分片上传\n", len(orphaned))
+			return
+		}
+		failed := 0
+		for _, upload := range orphaned {
+			if err := lister.abortUpload(ctx, upload.Key, upload.UploadID); err != nil {
+				log(0, "取消分片上传 %s 失败: %v", upload.Key, err)
+				failed++
+				continue
+			}
+			log(1, "已取消分片上传 %s (%s)", upload.Key, upload.UploadID)
+		}
+		fmt.Printf("已取消 %d 个未完成的分片上传\n", len(orphaned)-failed)
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
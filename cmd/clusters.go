@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	clustersFile       string
+	clusterConcurrency int
+	clustersReport     string
+)
+
+// ClusterInventory 是 --clusters-file 中的集群清单
+type ClusterInventory struct {
+	Clusters []ClusterTarget `json:"clusters"`
+}
+
+// ClusterTarget 是清单中的一个集群，未设置的字段使用命令行参数
+type ClusterTarget struct {
+	Name       string   `json:"name"`
+	Kubeconfig string   `json:"kubeconfig,omitempty"`
+	Context    string   `json:"context,omitempty"`
+	Namespace  string   `json:"namespace"`
+	Pods       []string `json:"pods,omitempty"`
+	Label      string   `json:"label,omitempty"`
+	Containers string   `json:"containers,omitempty"`
+	DataDir    string   `json:"dataDir,omitempty"`
+	Storage    string   `json:"storage,omitempty"`
+	Bucket     string   `json:"bucket,omitempty"`
+}
+
+// target 返回集群的备份范围，清单中没有设置的字段使用 base（命令行参数）中的值。
+// 清单中只设置了 bucket 时，命令行上的 --storage 仍然优先
+func (t ClusterTarget) target(base backupTarget) backupTarget {
+	target := base
+	target.cluster, target.namespace = t.Name, t.Namespace
+	set := func(field *string, value string) {
+		if value != "" {
+			*field = value
+		}
+	}
+	set(&target.kubeconfig, t.Kubeconfig)
+	set(&target.kubeContext, t.Context)
+	set(&target.label, t.Label)
+	set(&target.containers, t.Containers)
+	set(&target.dataDir, t.DataDir)
+	if len(t.Pods) > 0 {
+		target.pods = t.Pods
+	}
+	if t.Storage != "" {
+		target.storage = t.Storage
+	} else if t.Bucket != "" && storageURL == "" {
+		target.storage = "oss://" + t.Bucket
+	}
+	return target
+}
+
+func loadClusterInventory(path string) (*ClusterInventory, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取集群清单 %s 失败: %v", path, err)
+	}
+	var inventory ClusterInventory
+	if err := yaml.UnmarshalStrict(data, &inventory); err != nil {
+		return nil, fmt.Errorf("解析集群清单 %s 失败: %v", path, err)
+	}
+	if len(inventory.Clusters) == 0 {
+		return nil, fmt.Errorf("集群清单 %s 中没有集群", path)
+	}
+	names := map[string]bool{}
+	for i, t := range inventory.Clusters {
+		if t.Name == "" || t.Namespace == "" {
+			return nil, fmt.Errorf("集群清单 %s 中第 %d 个集群缺少 name 或 namespace", path, i+1)
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("集群清单 %s 中集群 %s 重复", path, t.Name)
+		}
+		names[t.Name] = true
+	}
+	return &inventory, nil
+}
+
+// ClusterResult 是多集群备份中一个集群的结果
+type ClusterResult struct {
+	Cluster   string          `json:"cluster"`
+	Namespace string          `json:"namespace"`
+	Status    string          `json:"status"`
+	Error     string          `json:"error,omitempty"`
+	StartTime time.Time       `json:"startTime"`
+	EndTime   time.Time       `json:"endTime"`
+	Manifest  *BackupManifest `json:"manifest,omitempty"`
+}
+
+// summarize 根据清单中各 pod 的结果确定集群的状态
+func (r *ClusterResult) summarize(runErr error) {
+	succeeded, failed, _ := r.counts()
+	r.Status, r.Error = runStatus(succeeded, failed, runErr)
+}
+
+// runSummary 将集群的结果转换为通知中的运行汇总
+func (r *ClusterResult) runSummary() *RunSummary {
+	s := newRunSummary(r.Manifest, nil)
+	s.Cluster, s.Namespace = r.Cluster, r.Namespace
+	s.Status, s.Error = r.Status, r.Error
+	s.StartTime, s.EndTime = r.StartTime, r.EndTime
+	return s
+}
+
+// backupClusters 按集群清单依次备份每个集群，最多 clusterConcurrency 个集群同时备份。
+// 所有集群在当前进程中备份，共用上传限速、存储和通知渠道，一个集群失败不影响其他集群，
+// 结束后汇总为一份报告和一条通知，返回进程退出码
+func backupClusters(ctx context.Context) int {
+	inventory, err := loadClusterInventory(clustersFile)
+	if err != nil {
+		log(0, "%v", err)
+		return 1
+	}
+
+	if clusterConcurrency < 1 {
+		clusterConcurrency = 1
+	}
+	startTime := time.Now()
+	base := flagTarget()
+	env := &backupEnv{}
+	results := make([]*ClusterResult, len(inventory.Clusters))
+	slots := make(chan struct{}, clusterConcurrency)
+	var wg sync.WaitGroup
+	for i, t := range inventory.Clusters {
+		wg.Add(1)
+		go func(i int, t ClusterTarget) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			result := &ClusterResult{Cluster: t.Name, Namespace: t.Namespace, StartTime: time.Now()}
+			results[i] = result
+			if ctx.Err() != nil {
+				result.summarize(ctx.Err())
+				return
+			}
+			log(1, "开始备份集群 %s", t.Name)
+			// 每个集群单独统计指标，分别推送到 Pushgateway
+			metrics := newBackupMetrics()
+			manifest, runErr := backupCluster(ctx, t.target(base), env, metrics, false)
+			result.EndTime = time.Now()
+			result.Manifest = manifest
+			result.summarize(runErr)
+			pushBackupMetrics(metrics, t.Name, t.Namespace)
+			log(1, "集群 %s 备份结束: %s", t.Name, result.Status)
+		}(i, t)
+	}
+	wg.Wait()
+
+	printClusterResults(results)
+	if clustersReport != "" {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err == nil {
+			err = os.WriteFile(clustersReport, data, 0644)
+		}
+		if err != nil {
+			log(0, "保存多集群备份报告失败: %v", err)
+		} else {
+			log(1, "多集群备份报告已保存到 %s", clustersReport)
+		}
+	}
+	notifyCtx, cancel := cleanupContext()
+	defer cancel()
+	if err := sendClustersNotification(notifyCtx, results, time.Since(startTime)); err != nil {
+		log(0, "发送通知失败: %v", err)
+	}
+
+	for _, r := range results {
+		if r.Status != backupStatusSuccess {
+			return 1
+		}
+	}
+	return 0
+}
+
+func printClusterResults(results []*ClusterResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CLUSTER\tNAMESPACE\tSTATUS\tSUCCEEDED\tFAILED\tSIZE\tDURATION\tERROR")
+	for _, r := range results {
+		succeeded, failed, size := r.counts()
+		duration := "-"
+		if !r.EndTime.IsZero() {
+			duration = r.EndTime.Sub(r.StartTime).Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\n", r.Cluster, r.Namespace, r.Status, succeeded, failed, formatSize(size), duration, r.Error)
+	}
+	w.Flush()
+}
+
+// counts 返回集群中备份成功和失败的 pod 容器数，以及备份文件的总大小
+func (r *ClusterResult) counts() (int, int, int64) {
+	succeeded, failed := 0, 0
+	var size int64
+	if r.Manifest == nil {
+		return 0, 0, 0
+	}
+	for _, e := range r.Manifest.Entries {
+		if e.Status == backupStatusSuccess {
+			succeeded++
+			size += e.Size
+		} else {
+			failed++
+		}
+	}
+	return succeeded, failed, size
+}
@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	"github.com/pierrec/lz4/v4"
+)
+
+var (
+	compression        string
+	compressionLevel   int
+	compressionThreads int
+	compressInPod      bool
+)
+
+// 支持的压缩算法。默认 pod 中只执行不压缩的 tar，压缩在本地多线程完成，不占用 pod 的 CPU，
+// 但 exec 流经 apiserver 传输的是未压缩的数据。--compress-in-pod 时改由 pod 中的 tar 调用压缩程序
+const (
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+	compressionLZ4  = "lz4"
+	compressionNone = "none"
+)
+
+// compressionExtensions 是各压缩算法的备份文件扩展名
+var compressionExtensions = map[string]string{
+	compressionGzip: ".tar.gz",
+	compressionZstd: ".tar.zst",
+	compressionLZ4:  ".tar.lz4",
+	compressionNone: ".tar",
+}
+
+var lz4Levels = []lz4.CompressionLevel{lz4.Fast, lz4.Level1, lz4.Level2, lz4.Level3, lz4.Level4,
+	lz4.Level5, lz4.Level6, lz4.Level7, lz4.Level8, lz4.Level9}
+
+// compressor 描述备份使用的压缩算法、级别和线程数，level 为 0 时使用算法的默认级别
+type compressor struct {
+	codec   string
+	level   int
+	threads int
+}
+
+func newCompressor(codec string, level, threads int) (*compressor, error) {
+	if _, ok := compressionExtensions[codec]; !ok {
+		return nil, fmt.Errorf("不支持的压缩算法 %s，可选 gzip、zstd、lz4、none", codec)
+	}
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+	switch codec {
+	case compressionGzip:
+		if level < 0 || level > 9 {
+			return nil, fmt.Errorf("gzip 压缩级别范围为 1-9")
+		}
+	case compressionZstd:
+		if level < 0 || level > 22 {
+			return nil, fmt.Errorf("zstd 压缩级别范围为 1-22")
+		}
+	case compressionLZ4:
+		if level < 0 || level >= len(lz4Levels) {
+			return nil, fmt.Errorf("lz4 压缩级别范围为 1-9")
+		}
+	}
+	return &compressor{codec: codec, level: level, threads: threads}, nil
+}
+
+func (c *compressor) extension() string {
+	return compressionExtensions[c.codec]
+}
+
+// podProgram 返回 pod 中 tar --use-compress-program 使用的压缩命令，none 返回空
+func (c *compressor) podProgram() string {
+	var program string
+	switch c.codec {
+	case compressionGzip, compressionLZ4:
+		program = c.codec
+	case compressionZstd:
+		program = c.codec
+		if c.level > 19 {
+			program += " --ultra"
+		}
+	default:
+		return ""
+	}
+	if c.level > 0 {
+		program += fmt.Sprintf(" -%d", c.level)
+	}
+	return program
+}
+
+// compressStream 返回 r 压缩后的数据流，读取方出错时应调用 CloseWithError 结束压缩
+func (c *compressor) compressStream(r io.Reader) *io.PipeReader {
+	reader, writer := io.Pipe()
+	go func() {
+		w, err := c.newWriter(writer)
+		if err != nil {
+			writer.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(w, r); err != nil {
+			w.Close()
+			writer.CloseWithError(err)
+			return
+		}
+		writer.CloseWithError(w.Close())
+	}()
+	return reader
+}
+
+func (c *compressor) newWriter(w io.Writer) (io.WriteCloser, error) {
+	switch c.codec {
+	case compressionGzip:
+		level := c.level
+		if level == 0 {
+			level = pgzip.DefaultCompression
+		}
+		gw, err := pgzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, err
+		}
+		if err := gw.SetConcurrency(1<<20, c.threads); err != nil {
+			return nil, err
+		}
+		return gw, nil
+	case compressionZstd:
+		options := []zstd.EOption{zstd.WithEncoderConcurrency(c.threads)}
+		if c.level > 0 {
+			options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.level)))
+		}
+		return zstd.NewWriter(w, options...)
+	case compressionLZ4:
+		lw := lz4.NewWriter(w)
+		if err := lw.Apply(lz4.CompressionLevelOption(lz4Levels[c.level]), lz4.ConcurrencyOption(c.threads)); err != nil {
+			return nil, err
+		}
+		return lw, nil
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// compressionFromKey 根据备份文件的扩展名判断压缩算法，旧版本的备份都是 .tar.gz
+func compressionFromKey(key string) string {
+	key = strings.TrimSuffix(key, encryptedSuffix)
+	for codec, ext := range compressionExtensions {
+		if codec != compressionNone && strings.HasSuffix(key, ext) {
+			return codec
+		}
+	}
+	if strings.HasSuffix(key, compressionExtensions[compressionNone]) {
+		return compressionNone
+	}
+	return compressionGzip
+}
+
+// decompressStream 返回 r 解压后的数据流
+func decompressStream(codec string, r io.Reader) (io.ReadCloser, error) {
+	var d io.ReadCloser
+	switch codec {
+	case compressionGzip:
+		gz, err := pgzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		d = gz
+	case compressionZstd:
+		zd, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		d = zd.IOReadCloser()
+	case compressionLZ4:
+		d = io.NopCloser(lz4.NewReader(r))
+	case compressionNone:
+		d = io.NopCloser(r)
+	default:
+		return nil, fmt.Errorf("不支持的压缩算法 %s", codec)
+	}
+	return &eofReader{ReadCloser: d}, nil
+}
+
+// eofReader 读到 EOF 后不再读取解压器：exec 的标准输入会读完整个流，之后再次读取时
+// pgzip 会返回 EOF 错误或阻塞在 WriteTo 中。同时隐藏了解压器的 WriteTo，io.Copy 只通过 Read 读取
+type eofReader struct {
+	io.ReadCloser
+	eof bool
+}
+
+func (r *eofReader) Read(p []byte) (int, error) {
+	if r.eof {
+		return 0, io.EOF
+	}
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
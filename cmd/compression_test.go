@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestCompressionRoundTrip(t *testing.T) {
+	// 可压缩的数据与随机数据各一半，大小超过 pgzip 的块大小
+	data := bytes.Repeat([]byte("iotdb tsfile "), 200000)
+	random := make([]byte, 3<<20)
+	rand.New(rand.NewSource(1)).Read(random)
+	data = append(data, random...)
+
+	for _, codec := range []string{compressionGzip, compressionZstd, compressionLZ4, compressionNone} {
+		t.Run(codec, func(t *testing.T) {
+			comp, err := newCompressor(codec, 0, 4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compressed, err := io.ReadAll(comp.compressStream(bytes.NewReader(data)))
+			if err != nil {
+				t.Fatalf("压缩失败: %v", err)
+			}
+			if codec == compressionNone && !bytes.Equal(compressed, data) {
+				t.Fatal("none 不应改变数据")
+			}
+
+			d, err := decompressStream(codec, bytes.NewReader(compressed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+
+			// 与 restore 相同：exec 的标准输入读完整个流后，再读一次剩余的数据计算校验和
+			done := make(chan error, 1)
+			var out bytes.Buffer
+			go func() {
+				if _, err := io.Copy(&out, d); err != nil {
+					done <- err
+					return
+				}
+				n, err := io.Copy(io.Discard, d)
+				if err == nil && n != 0 {
+					err = io.ErrUnexpectedEOF
+				}
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("解压失败: %v", err)
+				}
+			case <-time.After(30 * time.Second):
+				t.Fatal("读到 EOF 后再次读取被阻塞")
+			}
+			if !bytes.Equal(out.Bytes(), data) {
+				t.Fatalf("解压后的数据不一致: %d 字节，预期 %d 字节", out.Len(), len(data))
+			}
+		})
+	}
+}
+
+func TestCompressorPodProgram(t *testing.T) {
+	tests := []struct {
+		codec   string
+		level   int
+		program string
+	}{
+		{compressionGzip, 0, "gzip"},
+		{compressionGzip, 6, "gzip -6"},
+		{compressionZstd, 3, "zstd -3"},
+		{compressionZstd, 22, "zstd --ultra -22"},
+		{compressionLZ4, 9, "lz4 -9"},
+		{compressionNone, 0, ""},
+	}
+	for _, tt := range tests {
+		comp, err := newCompressor(tt.codec, tt.level, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if program := comp.podProgram(); program != tt.program {
+			t.Fatalf("%s 级别 %d: %q，预期 %q", tt.codec, tt.level, program, tt.program)
+		}
+	}
+}
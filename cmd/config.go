@@ -0,0 +1,508 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	configFile    string
+	profile       string
+	showEffective bool
+	showCommand   string
+	// loadedConfig 是执行命令前加载的配置，凭证链从中读取 credentials
+	loadedConfig *appConfig
+)
+
+// 环境变量前缀，IOTDBTOOLS_NAMESPACE 对应 --namespace，IOTDBTOOLS_KEEP_LOCAL 对应 --keep-local
+const envPrefix = "IOTDBTOOLS"
+
+// defaultConfigFile 是用户主目录下的默认配置文件，--config-file 为空且文件存在时读取
+const defaultConfigFile = ".iotdbtools.yaml"
+
+// appConfig 是加载后的配置文件。配置文件中的键与命令行参数同名，defaults 对所有 profile 生效，
+// 选中的 profile 覆盖 defaults，命令不支持的键被忽略：
+//
+//	profile: prod
+//	defaults:
+//	  storage: oss://iotdb-backup
+//	  compression: zstd
+//	profiles:
+//	  prod:
+//	    kubeconfig: /etc/iotdbtools/prod.kubeconfig
+//	    cluster-name: prod
+//	    namespace: iotdb
+//	    credentials:
+//	      ak: ...
+//	      sk: ...
+//	      endpoint: oss-cn-hangzhou.aliyuncs.com
+//
+// credentials 不是命令行参数，是凭证链中的一个来源，profile 中的 credentials 覆盖 defaults 中的同名键
+type appConfig struct {
+	file     string // 为空表示没有配置文件
+	profile  string
+	defaults map[string]interface{}
+	profiles map[string]map[string]interface{}
+	// settings 合并了 defaults、profile 和环境变量
+	settings    *viper.Viper
+	credentials map[string]string
+	notifiers   []*NotifierConfig
+	// errors 是配置文件的结构错误，由 validate 报告
+	errors []string
+}
+
+// loadConfig 加载配置文件和环境变量，没有配置文件时只使用环境变量
+func loadConfig() (*appConfig, error) {
+	c := &appConfig{profiles: map[string]map[string]interface{}{}}
+	c.settings = viper.New()
+	c.settings.SetEnvPrefix(envPrefix)
+	c.settings.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	c.settings.AutomaticEnv()
+
+	c.file = configFile
+	if c.file == "" {
+		c.file = os.Getenv(envPrefix + "_CONFIG_FILE")
+	}
+	if c.file == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			if _, err := os.Stat(filepath.Join(home, defaultConfigFile)); err == nil {
+				c.file = filepath.Join(home, defaultConfigFile)
+			}
+		}
+	}
+	c.profile = profile
+	if c.profile == "" {
+		c.profile = os.Getenv(envPrefix + "_PROFILE")
+	}
+	if c.file == "" {
+		if c.profile != "" {
+			return nil, fmt.Errorf("指定了 profile %s，但没有找到配置文件，请使用 --config-file 指定", c.profile)
+		}
+		return c, nil
+	}
+
+	v := viper.New()
+	v.SetConfigFile(c.file)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("读取配置文件 %s 失败: %v", c.file, err)
+	}
+	for key := range v.AllSettings() {
+		if key != "profile" && key != "defaults" && key != "profiles" {
+			c.errors = append(c.errors, fmt.Sprintf("未知的配置项 %s，只支持 profile、defaults、profiles", key))
+		}
+	}
+	c.defaults = v.GetStringMap("defaults")
+	for name, raw := range v.GetStringMap("profiles") {
+		values, ok := raw.(map[string]interface{})
+		if !ok {
+			c.errors = append(c.errors, fmt.Sprintf("profile %s 必须是参数名到值的映射", name))
+			continue
+		}
+		c.profiles[name] = values
+	}
+	if c.profile == "" {
+		c.profile = v.GetString("profile")
+	}
+	// viper 的键不区分大小写，profile 名称统一为小写
+	c.profile = strings.ToLower(c.profile)
+	if _, ok := c.profiles[c.profile]; c.profile != "" && !ok {
+		return nil, fmt.Errorf("配置文件 %s 中没有 profile %s", c.file, c.profile)
+	}
+
+	c.credentials = map[string]string{}
+	for _, values := range []map[string]interface{}{c.defaults, c.profiles[c.profile]} {
+		if creds, ok := values["credentials"].(map[string]interface{}); ok {
+			for key, value := range creds {
+				c.credentials[key] = configValue(value)
+			}
+		}
+	}
+
+	// profile 中的 notifiers 替换 defaults 中的 notifiers
+	for _, values := range []map[string]interface{}{c.defaults, c.profiles[c.profile]} {
+		if raw, ok := values["notifiers"]; ok {
+			notifiers, err := parseNotifiers(raw)
+			if err != nil {
+				return nil, fmt.Errorf("配置文件 %s: %v", c.file, err)
+			}
+			c.notifiers = notifiers
+		}
+	}
+
+	if err := c.settings.MergeConfigMap(c.defaults); err != nil {
+		return nil, err
+	}
+	if err := c.settings.MergeConfigMap(c.profiles[c.profile]); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// lookup 返回参数在环境变量或配置文件中的值和来源
+func (c *appConfig) lookup(name string) (string, string, bool) {
+	if !c.settings.IsSet(name) {
+		return "", "", false
+	}
+	source := "defaults"
+	if env := envName(name); os.Getenv(env) != "" {
+		source = "环境变量 " + env
+	} else if _, ok := c.profiles[c.profile][name]; ok {
+		source = "profile " + c.profile
+	}
+	return configValue(c.settings.Get(name)), source, true
+}
+
+func envName(name string) string {
+	return envPrefix + "_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// configValue 将配置文件中的值转换为命令行参数的格式，列表用逗号连接
+func configValue(raw interface{}) string {
+	switch v := raw.(type) {
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, configValue(item))
+		}
+		return strings.Join(items, ",")
+	case float64:
+		// JSON 配置文件中的数字都是 float64，避免大数输出为科学计数法
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// configurable 判断参数能否在配置文件中设置，--config-file、--profile 和已废弃、隐藏的参数除外
+func configurable(f *pflag.Flag) bool {
+	switch f.Name {
+	case "config-file", "profile", "help", "version":
+		return false
+	}
+	return f.Deprecated == "" && !f.Hidden
+}
+
+// applyConfig 将环境变量和配置文件中的值应用到命令行没有设置的参数，
+// 优先级从高到低：命令行参数、环境变量、profile、defaults、参数默认值
+func applyConfig(cmd *cobra.Command) error {
+	c, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	loadedConfig = c
+	var errs []string
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if f.Changed || !configurable(f) {
+			return
+		}
+		value, source, ok := c.lookup(f.Name)
+		if !ok {
+			return
+		}
+		if err := f.Value.Set(value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s（%s）: %v", f.Name, source, err))
+		}
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("配置无效: %s", strings.Join(errs, "; "))
+	}
+	if c.file != "" {
+		log(2, "使用配置文件 %s，profile: %s", c.file, c.profile)
+	}
+	return nil
+}
+
+// flagSet 判断参数是否在命令行、环境变量或配置文件中明确设置
+func flagSet(cmd *cobra.Command, name string) bool {
+	f := cmd.Flags().Lookup(name)
+	if f == nil {
+		return false
+	}
+	if f.Changed {
+		return true
+	}
+	if loadedConfig == nil || !configurable(f) {
+		return false
+	}
+	_, _, ok := loadedConfig.lookup(name)
+	return ok
+}
+
+// configFlags 返回所有命令的可配置参数，同名参数在不同命令中可能有不同的含义，按名称分组
+func configFlags() map[string][]*pflag.Flag {
+	flags := map[string][]*pflag.Flag{}
+	add := func(f *pflag.Flag) {
+		if configurable(f) {
+			flags[f.Name] = append(flags[f.Name], f)
+		}
+	}
+	rootCmd.PersistentFlags().VisitAll(add)
+	var walk func(cmd *cobra.Command)
+	walk = func(cmd *cobra.Command) {
+		for _, sub := range cmd.Commands() {
+			sub.LocalNonPersistentFlags().VisitAll(add)
+			walk(sub)
+		}
+	}
+	walk(rootCmd)
+	return flags
+}
+
+// checkConfigValue 检查配置文件中的值能否被参数解析，部分参数还检查取值范围
+func checkConfigValue(f *pflag.Flag, value string) error {
+	var err error
+	switch f.Value.Type() {
+	case "bool":
+		_, err = strconv.ParseBool(value)
+	case "int", "int64":
+		_, err = strconv.ParseInt(value, 10, 64)
+	case "float32":
+		_, err = strconv.ParseFloat(value, 32)
+	case "duration":
+		_, err = time.ParseDuration(value)
+	}
+	if err != nil {
+		return fmt.Errorf("不是有效的 %s: %s", f.Value.Type(), value)
+	}
+
+	switch f.Name {
+	case "compression":
+		_, err = newCompressor(value, 0, 0)
+	case "bandwidth-limit":
+		if value != "" {
+			_, err = parseSize(value)
+		}
+	case "storage":
+		if u, perr := url.Parse(value); perr != nil {
+			err = perr
+		} else if value != "" && u.Scheme != "oss" && u.Scheme != "s3" && u.Scheme != "file" {
+			err = fmt.Errorf("不支持的存储类型: %s", value)
+		}
+	}
+	return err
+}
+
+// checkConfigCredentials 检查配置文件中的 credentials，只能包含凭证文件中的键
+func checkConfigCredentials(section string, raw interface{}) []string {
+	creds, ok := raw.(map[string]interface{})
+	if !ok {
+		return []string{fmt.Sprintf("%s: credentials 必须是键值对", section)}
+	}
+	var errs []string
+	for key, value := range creds {
+		switch strings.ToUpper(key) {
+		case "AK", "SK", "TOKEN", "ENDPOINT":
+		case "EXPIRATION":
+			if _, err := time.Parse(time.RFC3339, configValue(value)); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: credentials.%s 不是 RFC 3339 格式的时间", section, key))
+			}
+		default:
+			errs = append(errs, fmt.Sprintf("%s: credentials 中未知的键 %s，只支持 ak、sk、token、endpoint、expiration", section, key))
+		}
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+// validate 检查配置文件的结构、键和值，返回所有错误
+func (c *appConfig) validate() []string {
+	errs := append([]string{}, c.errors...)
+	flags := configFlags()
+	check := func(section string, values map[string]interface{}) {
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if key == "credentials" {
+				errs = append(errs, checkConfigCredentials(section, values[key])...)
+				continue
+			}
+			if key == "notifiers" {
+				if _, err := parseNotifiers(values[key]); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: %v", section, err))
+				}
+				continue
+			}
+			candidates, ok := flags[key]
+			if !ok {
+				errs = append(errs, fmt.Sprintf("%s: 未知的参数 %s", section, key))
+				continue
+			}
+			if _, nested := values[key].(map[string]interface{}); nested {
+				errs = append(errs, fmt.Sprintf("%s: %s 的值不能是映射", section, key))
+				continue
+			}
+			value := configValue(values[key])
+			for _, f := range candidates {
+				if err := checkConfigValue(f, value); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: %s %v", section, key, err))
+					break
+				}
+			}
+		}
+	}
+	check("defaults", c.defaults)
+	names := make([]string, 0, len(c.profiles))
+	for name := range c.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		check("profile "+name, c.profiles[name])
+	}
+	return errs
+}
+
+// maskNotifiers 隐藏通知渠道中的密钥、密码、HTTP 头的值和 webhook 地址中的 token。
+// 企业微信、钉钉的 token 在查询参数中，Slack、飞书等其他渠道的 token 在路径的最后一段
+func maskNotifiers(notifiers []*NotifierConfig) []NotifierConfig {
+	masked := make([]NotifierConfig, 0, len(notifiers))
+	for _, n := range notifiers {
+		m := *n
+		if m.Secret != "" {
+			m.Secret = "******"
+		}
+		if m.Password != "" {
+			m.Password = "******"
+		}
+		if len(m.Headers) > 0 {
+			m.Headers = make(map[string]string, len(n.Headers))
+			for key := range n.Headers {
+				m.Headers[key] = "******"
+			}
+		}
+		if u, err := url.Parse(m.URL); err == nil {
+			if u.RawQuery != "" {
+				u.RawQuery = "******"
+			}
+			if dir, last := path.Split(strings.TrimSuffix(u.Path, "/")); m.Type != "wecom" && m.Type != "dingtalk" && last != "" {
+				u.Path, u.RawPath = dir+"******", dir+"******"
+			}
+			m.URL = u.String()
+		}
+		masked = append(masked, m)
+	}
+	return masked
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Validate and show the configuration file",
+	Long: `配置文件中的键与命令行参数同名，defaults 对所有 profile 生效，选中的 profile 覆盖 defaults。
+参数的优先级从高到低：命令行参数、环境变量 IOTDBTOOLS_<参数名>、profile、defaults、参数默认值。`,
+	// config 的子命令自己加载配置文件，不在执行前应用配置
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate the configuration file",
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := loadConfig()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if c.file == "" {
+			fmt.Printf("没有找到配置文件，请使用 --config-file 指定或创建 ~/%s\n", defaultConfigFile)
+			os.Exit(1)
+		}
+		if errs := c.validate(); len(errs) > 0 {
+			fmt.Printf("配置文件 %s 有 %d 个错误:\n", c.file, len(errs))
+			for _, e := range errs {
+				fmt.Printf("  %s\n", e)
+			}
+			os.Exit(1)
+		}
+		fmt.Printf("配置文件 %s 有效，profile: %d 个\n", c.file, len(c.profiles))
+	},
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the configuration of the selected profile",
+	Long: `输出选中的 profile 与 defaults 合并后的配置。
+--effective 输出 --command 指定的命令实际使用的每个参数的值及其来源（环境变量、profile、defaults 或参数默认值）。`,
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := loadConfig()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if !showEffective {
+			settings := map[string]interface{}{}
+			for key, value := range c.defaults {
+				settings[key] = value
+			}
+			for key, value := range c.profiles[c.profile] {
+				settings[key] = value
+			}
+			// 不输出凭证的值
+			if len(c.credentials) > 0 {
+				masked := map[string]string{}
+				for key, value := range c.credentials {
+					switch strings.ToUpper(key) {
+					case "SK", "TOKEN":
+						value = "******"
+					}
+					masked[key] = value
+				}
+				settings["credentials"] = masked
+			}
+			if len(c.notifiers) > 0 {
+				settings["notifiers"] = maskNotifiers(c.notifiers)
+			}
+			data, err := yaml.Marshal(settings)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Printf("# 配置文件: %s\n# profile: %s\n%s", c.file, c.profile, data)
+			return
+		}
+
+		target, _, err := rootCmd.Find([]string{showCommand})
+		if err != nil || target == rootCmd {
+			fmt.Printf("未知的命令 %s\n", showCommand)
+			os.Exit(1)
+		}
+		fmt.Printf("# 配置文件: %s\n# profile: %s\n", c.file, c.profile)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "PARAMETER\tVALUE\tSOURCE")
+		show := func(f *pflag.Flag) {
+			if !configurable(f) {
+				return
+			}
+			value, source, ok := c.lookup(f.Name)
+			if !ok {
+				value, source = f.DefValue, "默认值"
+			}
+			fmt.Fprintf(w, "--%s\t%s\t%s\n", f.Name, value, source)
+		}
+		target.LocalNonPersistentFlags().VisitAll(show)
+		rootCmd.PersistentFlags().VisitAll(show)
+		w.Flush()
+	},
+}
+
+func init() {
+	configShowCmd.Flags().BoolVar(&showEffective, "effective", false, "输出命令实际使用的每个参数的值及其来源")
+	configShowCmd.Flags().StringVar(&showCommand, "command", "backup", "与 --effective 一起使用，要查看的命令")
+	configCmd.AddCommand(configValidateCmd, configShowCmd)
+	rootCmd.AddCommand(configCmd)
+}
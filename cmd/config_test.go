@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestMaskNotifiers(t *testing.T) {
+	configs := []*NotifierConfig{
+		{Type: "wecom", URL: "https://qyapi.weixin.qq.com/cgi-bin/webhook/send?key=wecom-token"},
+		{Type: "slack", URL: "https://hooks.slack.com/services/T000/B000/slack-token"},
+		{Type: "feishu", URL: "https://open.feishu.cn/open-apis/bot/v2/hook/feishu-token", Secret: "feishu-secret"},
+		{Type: "webhook", URL: "https://example.com/hook", Headers: map[string]string{"Authorization": "Bearer header-token"}},
+		{Type: "email", SMTP: "smtp.example.com:465", Password: "smtp-password", To: []string{"ops@example.com"}},
+	}
+	masked := maskNotifiers(configs)
+	data, err := json.Marshal(masked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"wecom-token", "slack-token", "feishu-token", "feishu-secret", "header-token", "smtp-password"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("%s 没有隐藏: %s", secret, data)
+		}
+	}
+	if masked[0].URL != "https://qyapi.weixin.qq.com/cgi-bin/webhook/send?******" ||
+		masked[1].URL != "https://hooks.slack.com/services/T000/B000/******" ||
+		masked[3].Headers["Authorization"] != "******" {
+		t.Fatalf("隐藏后的配置: %+v", masked)
+	}
+	// 不修改原来的配置
+	if configs[3].Headers["Authorization"] != "Bearer header-token" {
+		t.Fatal("原来的配置被修改")
+	}
+}
+
+// testConfigCommand 返回带有几个参数的命令，配置从 dir 中的 config.yaml 读取，测试结束后恢复全局状态
+func testConfigCommand(t *testing.T, content string) *cobra.Command {
+	t.Helper()
+	savedConfigFile, savedProfile, savedLoaded := configFile, profile, loadedConfig
+	t.Cleanup(func() { configFile, profile, loadedConfig = savedConfigFile, savedProfile, savedLoaded })
+	dir := t.TempDir()
+	// 避免读取用户主目录下的默认配置文件
+	t.Setenv("HOME", dir)
+	configFile, profile, loadedConfig = "", "", nil
+	if content != "" {
+		configFile = filepath.Join(dir, "config.yaml")
+		if err := os.WriteFile(configFile, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := &cobra.Command{Use: "test"}
+	for _, name := range []string{"storage", "compression", "cluster-name", "namespace", "datadir"} {
+		cmd.Flags().String(name, "flag-default", "")
+	}
+	cmd.Flags().Bool("keep-local", false, "")
+	return cmd
+}
+
+const testConfig = `
+profile: prod
+defaults:
+  storage: oss://defaults
+  compression: gzip
+  cluster-name: defaults
+  namespace: defaults
+  credentials:
+    ak: defaults-ak
+    sk: defaults-sk
+profiles:
+  prod:
+    compression: zstd
+    cluster-name: prod
+    namespace: prod
+    keep-local: true
+    credentials:
+      sk: prod-sk
+  uat:
+    compression: lz4
+`
+
+func TestApplyConfigPrecedence(t *testing.T) {
+	cmd := testConfigCommand(t, testConfig)
+	t.Setenv(envName("cluster-name"), "env")
+	t.Setenv(envName("namespace"), "env")
+	if err := cmd.Flags().Set("namespace", "cli"); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfig(cmd); err != nil {
+		t.Fatal(err)
+	}
+
+	// 优先级从高到低：命令行参数、环境变量、profile、defaults、参数默认值
+	want := map[string]string{
+		"namespace":    "cli",
+		"cluster-name": "env",
+		"compression":  "zstd",
+		"keep-local":   "true",
+		"storage":      "oss://defaults",
+		"datadir":      "flag-default",
+	}
+	for name, value := range want {
+		if got := cmd.Flags().Lookup(name).Value.String(); got != value {
+			t.Errorf("%s = %s，预期 %s", name, got, value)
+		}
+	}
+	if flagSet(cmd, "datadir") || !flagSet(cmd, "storage") || !flagSet(cmd, "namespace") {
+		t.Error("flagSet 与参数的来源不一致")
+	}
+	if _, source, _ := loadedConfig.lookup("compression"); source != "profile prod" {
+		t.Errorf("compression 的来源: %s", source)
+	}
+	if _, source, _ := loadedConfig.lookup("cluster-name"); source != "环境变量 IOTDBTOOLS_CLUSTER_NAME" {
+		t.Errorf("cluster-name 的来源: %s", source)
+	}
+
+	// profile 中的 credentials 覆盖 defaults 中的同名键
+	if creds := loadedConfig.credentials; creds["ak"] != "defaults-ak" || creds["sk"] != "prod-sk" {
+		t.Errorf("credentials: %v", creds)
+	}
+}
+
+func TestConfigProfileSelection(t *testing.T) {
+	// 环境变量选择的 profile 优先于配置文件中的 profile
+	cmd := testConfigCommand(t, testConfig)
+	t.Setenv(envPrefix+"_PROFILE", "uat")
+	if err := applyConfig(cmd); err != nil {
+		t.Fatal(err)
+	}
+	if got := cmd.Flags().Lookup("compression").Value.String(); got != "lz4" {
+		t.Fatalf("compression = %s，预期 uat 中的 lz4", got)
+	}
+
+	// --profile 优先于环境变量
+	cmd = testConfigCommand(t, testConfig)
+	profile = "prod"
+	if err := applyConfig(cmd); err != nil {
+		t.Fatal(err)
+	}
+	if got := cmd.Flags().Lookup("compression").Value.String(); got != "zstd" {
+		t.Fatalf("compression = %s，预期 prod 中的 zstd", got)
+	}
+
+	cmd = testConfigCommand(t, testConfig)
+	profile = "missing"
+	if err := applyConfig(cmd); err == nil {
+		t.Fatal("profile 不存在时应返回错误")
+	}
+
+	// 没有配置文件时不能指定 profile，只使用环境变量
+	cmd = testConfigCommand(t, "")
+	profile = "prod"
+	if err := applyConfig(cmd); err == nil {
+		t.Fatal("没有配置文件时指定 profile 应返回错误")
+	}
+	cmd = testConfigCommand(t, "")
+	t.Setenv(envPrefix+"_PROFILE", "")
+	t.Setenv(envName("storage"), "s3://env")
+	if err := applyConfig(cmd); err != nil {
+		t.Fatal(err)
+	}
+	if got := cmd.Flags().Lookup("storage").Value.String(); got != "s3://env" {
+		t.Fatalf("storage = %s，预期环境变量中的值", got)
+	}
+}
+
+func TestApplyConfigInvalidValue(t *testing.T) {
+	cmd := testConfigCommand(t, "defaults:\n  keep-local: maybe\n")
+	if err := applyConfig(cmd); err == nil || !strings.Contains(err.Error(), "keep-local（defaults）") {
+		t.Fatalf("预期参数值错误，实际: %v", err)
+	}
+}
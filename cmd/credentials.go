@@ -0,0 +1,378 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	credentialsSecret  string
+	credentialsCommand string
+	credentialsFile    string
+	ramRole            string
+)
+
+// Credentials 是访问对象存储的凭证。Source 是凭证的来源，只用于日志，AK/SK 不输出到日志
+type Credentials struct {
+	AccessKeyID     string
+	AccessKeySecret string
+	// SecurityToken 是 STS 临时凭证的 token，长期凭证为空
+	SecurityToken string
+	// Endpoint 是 OSS endpoint，存储地址中没有指定时使用
+	Endpoint string
+	// Expiration 是临时凭证的过期时间，长期凭证为零值
+	Expiration time.Time
+	Source     string
+}
+
+// credentialRequest 描述要查找凭证的存储，以及读取 --credentials-secret 使用的集群客户端和默认命名空间。
+// 多集群备份时每个集群从自己的集群中读取 Secret
+type credentialRequest struct {
+	scheme  string // oss 或 s3
+	storage string // 存储地址
+	// clientset 为 nil 时使用 getKubeClients 的客户端，只在需要读取 Secret 时创建
+	clientset kubernetes.Interface
+	namespace string
+}
+
+// credentialSource 是凭证链中的一个来源，没有配置或没有找到凭证时返回 nil, nil
+type credentialSource func(ctx context.Context, req credentialRequest) (*Credentials, error)
+
+// credentialChain 是按顺序尝试的凭证来源，第一个返回凭证的来源生效
+var credentialChain = []credentialSource{
+	envCredentials,
+	secretCredentials,
+	configCredentials,
+	commandCredentials,
+	fileCredentials,
+	ramRoleCredentials,
+}
+
+// resolveCredentials 按 credentialChain 的顺序查找 req 中存储的凭证，都没有找到时返回 nil, nil。
+// 返回的 credentialProvider 在临时凭证快过期时从同一个来源重新获取
+func resolveCredentials(ctx context.Context, req credentialRequest) (*credentialProvider, error) {
+	for _, source := range credentialChain {
+		creds, err := source(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		if creds == nil {
+			continue
+		}
+		if creds.AccessKeyID == "" || creds.AccessKeySecret == "" {
+			return nil, fmt.Errorf("%s 中的凭证不完整，需要 AK 和 SK", creds.Source)
+		}
+		log(1, "使用%s中的存储凭证", creds.Source)
+		return &credentialProvider{current: creds, refresh: func(ctx context.Context) (*Credentials, error) {
+			return source(ctx, req)
+		}}, nil
+	}
+	return nil, nil
+}
+
+// credentialsFromMap 从键值对中读取凭证，键不区分大小写：AK、SK、TOKEN（STS token）、ENDPOINT、EXPIRATION（RFC 3339）
+func credentialsFromMap(values map[string]string, source string) (*Credentials, error) {
+	upper := make(map[string]string, len(values))
+	for key, value := range values {
+		upper[strings.ToUpper(key)] = strings.TrimSpace(value)
+	}
+	creds := &Credentials{
+		AccessKeyID:     upper["AK"],
+		AccessKeySecret: upper["SK"],
+		SecurityToken:   upper["TOKEN"],
+		Endpoint:        upper["ENDPOINT"],
+		Source:          source,
+	}
+	if expiration := upper["EXPIRATION"]; expiration != "" {
+		t, err := time.Parse(time.RFC3339, expiration)
+		if err != nil {
+			return nil, fmt.Errorf("%s 中的 EXPIRATION 不是 RFC 3339 格式的时间: %v", source, err)
+		}
+		creds.Expiration = t
+	}
+	return creds, nil
+}
+
+// parseCredentials 解析 KEY=VALUE 格式的凭证，忽略空行和 # 开头的注释
+func parseCredentials(r io.Reader) (map[string]string, error) {
+	creds := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) == 2 {
+			creds[strings.TrimSpace(parts[0])] = parts[1]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// envCredentials 读取环境变量中的凭证。OSS 使用 ALIBABA_CLOUD_ACCESS_KEY_ID/ALIBABA_CLOUD_ACCESS_KEY_SECRET/ALIBABA_CLOUD_SECURITY_TOKEN，
+// 也支持 OSS_ACCESS_KEY_ID/OSS_ACCESS_KEY_SECRET/OSS_SESSION_TOKEN；S3 使用 AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY/AWS_SESSION_TOKEN
+func envCredentials(ctx context.Context, req credentialRequest) (*Credentials, error) {
+	var candidates [][3]string
+	switch req.scheme {
+	case "oss":
+		candidates = [][3]string{
+			{"ALIBABA_CLOUD_ACCESS_KEY_ID", "ALIBABA_CLOUD_ACCESS_KEY_SECRET", "ALIBABA_CLOUD_SECURITY_TOKEN"},
+			{"OSS_ACCESS_KEY_ID", "OSS_ACCESS_KEY_SECRET", "OSS_SESSION_TOKEN"},
+		}
+	case "s3":
+		candidates = [][3]string{{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN"}}
+	}
+	for _, names := range candidates {
+		if os.Getenv(names[0]) == "" {
+			continue
+		}
+		return &Credentials{
+			AccessKeyID:     os.Getenv(names[0]),
+			AccessKeySecret: os.Getenv(names[1]),
+			SecurityToken:   os.Getenv(names[2]),
+			Source:          "环境变量 " + names[0],
+		}, nil
+	}
+	return nil, nil
+}
+
+// secretCredentials 从 req 的集群中读取 --credentials-secret 指定的 Kubernetes Secret，格式为 [命名空间/]名称，
+// 默认在 req 的命名空间中。Secret 中的键与 .credentials 文件相同
+func secretCredentials(ctx context.Context, req credentialRequest) (*Credentials, error) {
+	if credentialsSecret == "" {
+		return nil, nil
+	}
+	ns, name := req.namespace, credentialsSecret
+	if i := strings.Index(credentialsSecret, "/"); i >= 0 {
+		ns, name = credentialsSecret[:i], credentialsSecret[i+1:]
+	}
+	if ns == "" {
+		ns = "default"
+	}
+	source := fmt.Sprintf("Kubernetes Secret %s/%s", ns, name)
+
+	clientset := req.clientset
+	if clientset == nil {
+		var err error
+		if clientset, _, err = getKubeClients(); err != nil {
+			return nil, fmt.Errorf("读取 %s 失败: %v", source, err)
+		}
+	}
+	secret, err := clientset.CoreV1().Secrets(ns).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("读取 %s 失败: %v", source, err)
+	}
+	values := make(map[string]string, len(secret.Data))
+	for key, value := range secret.Data {
+		values[key] = string(value)
+	}
+	return credentialsFromMap(values, source)
+}
+
+// configCredentials 读取配置文件中 defaults 或 profile 下的 credentials
+func configCredentials(ctx context.Context, req credentialRequest) (*Credentials, error) {
+	if loadedConfig == nil || len(loadedConfig.credentials) == 0 {
+		return nil, nil
+	}
+	source := "配置文件 " + loadedConfig.file
+	if loadedConfig.profile != "" {
+		source += "（profile " + loadedConfig.profile + "）"
+	}
+	return credentialsFromMap(loadedConfig.credentials, source)
+}
+
+// commandCredentials 运行 --credentials-command 指定的命令获取凭证，与 git 的 credential helper 类似：
+// 命令通过环境变量 IOTDBTOOLS_STORAGE_SCHEME、IOTDBTOOLS_STORAGE 得知要访问的存储，
+// 以 KEY=VALUE 格式在标准输出中返回凭证，标准错误输出到终端
+func commandCredentials(ctx context.Context, req credentialRequest) (*Credentials, error) {
+	if credentialsCommand == "" {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	c := exec.CommandContext(ctx, "sh", "-c", credentialsCommand)
+	c.Env = append(os.Environ(), envPrefix+"_STORAGE_SCHEME="+req.scheme, envPrefix+"_STORAGE="+req.storage)
+	c.Stderr = os.Stderr
+	output, err := c.Output()
+	if err != nil {
+		return nil, fmt.Errorf("运行凭证命令失败: %v", err)
+	}
+	values, err := parseCredentials(bytes.NewReader(output))
+	if err != nil {
+		return nil, fmt.Errorf("解析凭证命令的输出失败: %v", err)
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	return credentialsFromMap(values, "凭证命令")
+}
+
+// fileCredentials 读取 --credentials-file 指定的 KEY=VALUE 格式的凭证文件，文件不存在时跳过
+func fileCredentials(ctx context.Context, req credentialRequest) (*Credentials, error) {
+	if credentialsFile == "" {
+		return nil, nil
+	}
+	file, err := os.Open(credentialsFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	values, err := parseCredentials(file)
+	if err != nil {
+		return nil, fmt.Errorf("读取凭证文件 %s 失败: %v", credentialsFile, err)
+	}
+	return credentialsFromMap(values, "凭证文件 "+credentialsFile)
+}
+
+// ecsMetadataURL 是阿里云 ECS 实例元数据服务中 RAM 角色临时凭证的地址
+var ecsMetadataURL = "http://100.100.100.200/latest/meta-data/ram/security-credentials/"
+
+// ramRoleCredentials 从 ECS 实例元数据服务获取 --ram-role 指定的 RAM 角色的 STS 临时凭证，
+// --ram-role auto 时使用实例绑定的角色。只用于 OSS
+func ramRoleCredentials(ctx context.Context, req credentialRequest) (*Credentials, error) {
+	if ramRole == "" || req.scheme != "oss" {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	role := ramRole
+	if role == "auto" {
+		body, err := getMetadata(ctx, ecsMetadataURL)
+		if err != nil {
+			return nil, fmt.Errorf("获取 ECS 实例绑定的 RAM 角色失败: %v", err)
+		}
+		role = strings.TrimSpace(strings.SplitN(string(body), "\n", 2)[0])
+		if role == "" {
+			return nil, fmt.Errorf("ECS 实例没有绑定 RAM 角色")
+		}
+	}
+	source := "RAM 角色 " + role
+
+	body, err := getMetadata(ctx, ecsMetadataURL+role)
+	if err != nil {
+		return nil, fmt.Errorf("获取%s的临时凭证失败: %v", source, err)
+	}
+	var response struct {
+		Code            string
+		AccessKeyId     string
+		AccessKeySecret string
+		SecurityToken   string
+		Expiration      time.Time
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("解析%s的临时凭证失败: %v", source, err)
+	}
+	if response.Code != "Success" {
+		return nil, fmt.Errorf("获取%s的临时凭证失败: %s", source, response.Code)
+	}
+	return &Credentials{
+		AccessKeyID:     response.AccessKeyId,
+		AccessKeySecret: response.AccessKeySecret,
+		SecurityToken:   response.SecurityToken,
+		Expiration:      response.Expiration,
+		Source:          source,
+	}, nil
+}
+
+func getMetadata(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// credentialRefreshWindow 是临时凭证过期前重新获取的提前量，备份大文件时凭证可能在上传过程中过期
+const credentialRefreshWindow = 5 * time.Minute
+
+// credentialProvider 为 OSS 和 S3 客户端提供凭证，临时凭证快过期时重新获取，获取失败时继续使用旧凭证
+type credentialProvider struct {
+	mu      sync.Mutex
+	current *Credentials
+	refresh func(ctx context.Context) (*Credentials, error)
+}
+
+func (p *credentialProvider) get() *Credentials {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.expiring() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		creds, err := p.refresh(ctx)
+		if err != nil || creds == nil {
+			log(0, "刷新%s的临时凭证失败，继续使用旧凭证: %v", p.current.Source, err)
+		} else {
+			log(2, "已刷新%s的临时凭证，过期时间 %s", creds.Source, creds.Expiration.Format(time.RFC3339))
+			p.current = creds
+		}
+	}
+	return p.current
+}
+
+func (p *credentialProvider) expiring() bool {
+	return !p.current.Expiration.IsZero() && time.Until(p.current.Expiration) < credentialRefreshWindow
+}
+
+// GetCredentials 实现 oss.CredentialsProvider
+func (p *credentialProvider) GetCredentials() oss.Credentials {
+	return p.get()
+}
+
+func (c *Credentials) GetAccessKeyID() string     { return c.AccessKeyID }
+func (c *Credentials) GetAccessKeySecret() string { return c.AccessKeySecret }
+func (c *Credentials) GetSecurityToken() string   { return c.SecurityToken }
+
+// minioProvider 将 credentialProvider 适配为 minio 的 credentials.Provider
+type minioProvider struct {
+	p *credentialProvider
+}
+
+func (m minioProvider) Retrieve() (credentials.Value, error) {
+	c := m.p.get()
+	return credentials.Value{
+		AccessKeyID:     c.AccessKeyID,
+		SecretAccessKey: c.AccessKeySecret,
+		SessionToken:    c.SecurityToken,
+		SignerType:      credentials.SignatureV4,
+	}, nil
+}
+
+func (m minioProvider) IsExpired() bool {
+	m.p.mu.Lock()
+	defer m.p.mu.Unlock()
+	return m.p.expiring()
+}
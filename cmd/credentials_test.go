@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSecretCredentialsUsesRequestCluster(t *testing.T) {
+	saved := credentialsSecret
+	t.Cleanup(func() { credentialsSecret = saved })
+
+	secret := func(namespace, ak string) *v1.Secret {
+		return &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "backup-credentials", Namespace: namespace},
+			Data:       map[string][]byte{"AK": []byte(ak), "SK": []byte("sk")},
+		}
+	}
+	prod := fake.NewSimpleClientset(secret("iotdb", "prod-ak"), secret("ops", "prod-ops-ak"))
+	uat := fake.NewSimpleClientset(secret("iotdb-uat", "uat-ak"))
+
+	tests := []struct {
+		secret    string
+		clientset *fake.Clientset
+		namespace string
+		ak        string
+	}{
+		{"backup-credentials", prod, "iotdb", "prod-ak"},
+		{"backup-credentials", uat, "iotdb-uat", "uat-ak"},
+		{"ops/backup-credentials", prod, "iotdb", "prod-ops-ak"},
+	}
+	for _, tt := range tests {
+		credentialsSecret = tt.secret
+		creds, err := secretCredentials(context.Background(), credentialRequest{scheme: "oss", clientset: tt.clientset, namespace: tt.namespace})
+		if err != nil {
+			t.Fatalf("%s 在 %s: %v", tt.secret, tt.namespace, err)
+		}
+		if creds.AccessKeyID != tt.ak {
+			t.Fatalf("%s 在 %s 读取到 AK %s，预期 %s", tt.secret, tt.namespace, creds.AccessKeyID, tt.ak)
+		}
+	}
+
+	// 其他集群中没有该 Secret
+	credentialsSecret = "backup-credentials"
+	if _, err := secretCredentials(context.Background(), credentialRequest{scheme: "oss", clientset: uat, namespace: "iotdb"}); err == nil {
+		t.Fatal("Secret 不存在时应返回错误")
+	}
+}
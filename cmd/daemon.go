@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/cobra"
+)
+
+var (
+	daemonInterval time.Duration
+	metricsAddr    string
+)
+
+func init() {
+	addBackupFlags(daemonCmd)
+	daemonCmd.Flags().DurationVar(&daemonInterval, "interval", 24*time.Hour, "两次备份开始时间的间隔，备份耗时超过间隔时上一次结束后立即开始下一次")
+	daemonCmd.Flags().StringVar(&metricsAddr, "metrics-addr", ":9090", "监控指标的监听地址，指标在 /metrics 提供，为空时不提供")
+
+	rootCmd.AddCommand(daemonCmd)
+}
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run backups periodically and serve Prometheus metrics",
+	Long: `常驻运行，启动后立即备份一次，之后每隔 --interval 备份一次，参数与 backup 相同。
+监控指标在 --metrics-addr 的 /metrics 提供，收到 SIGINT/SIGTERM 时取消正在进行的备份并退出。`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		keepInterruptedUploads = flagSet(cmd, "checkpoint-dir")
+		if daemonInterval <= 0 {
+			log(0, "--interval 必须大于 0")
+			os.Exit(1)
+		}
+
+		metrics := newBackupMetrics()
+		var server *http.Server
+		if metricsAddr != "" {
+			registry := metrics.registry(prometheus.Labels{"cluster": metricsCluster(clusterName), "namespace": namespace})
+			registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+			listener, err := net.Listen("tcp", metricsAddr)
+			if err != nil {
+				log(0, "监听 %s 失败: %v", metricsAddr, err)
+				os.Exit(1)
+			}
+			server = &http.Server{Handler: mux}
+			go func() {
+				if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					log(0, "监控指标服务出错: %v", err)
+				}
+			}()
+			log(1, "监控指标地址: http://%s/metrics", listener.Addr())
+		}
+
+		// 错误已在 runBackup 中输出并通知，下一次照常备份
+		daemonLoop(ctx, daemonInterval, func(ctx context.Context) {
+			runBackup(ctx, metrics)
+		})
+
+		if server != nil {
+			shutdownCtx, cancel := cleanupContext()
+			defer cancel()
+			server.Shutdown(shutdownCtx)
+		}
+	},
+}
+
+// daemonLoop 立即执行一次 backup，之后每隔 interval 执行一次。interval 按开始时间计算，
+// backup 耗时超过 interval 时结束后立即开始下一次。ctx 取消后返回
+func daemonLoop(ctx context.Context, interval time.Duration, backup func(ctx context.Context)) {
+	for ctx.Err() == nil {
+		start := time.Now()
+		backup(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		next := start.Add(interval)
+		log(1, "下一次备份时间: %s", next.Format("2006-01-02 15:04:05"))
+		select {
+		case <-time.After(time.Until(next)):
+		case <-ctx.Done():
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// runDaemonLoop 运行 daemonLoop，第 n 次备份时取消，返回每次备份的开始时间
+func runDaemonLoop(t *testing.T, interval, duration time.Duration, n int) []time.Time {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var starts []time.Time
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		daemonLoop(ctx, interval, func(ctx context.Context) {
+			starts = append(starts, time.Now())
+			time.Sleep(duration)
+			if len(starts) == n {
+				cancel()
+			}
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("daemonLoop 没有在取消后返回")
+	}
+	return starts
+}
+
+func TestDaemonLoopInterval(t *testing.T) {
+	interval := 100 * time.Millisecond
+	start := time.Now()
+	starts := runDaemonLoop(t, interval, 20*time.Millisecond, 3)
+	if len(starts) != 3 {
+		t.Fatalf("备份了 %d 次，预期 3 次", len(starts))
+	}
+	// 启动后立即备份，之后按开始时间每隔 interval 备份一次，不累加备份耗时
+	if d := starts[0].Sub(start); d > 50*time.Millisecond {
+		t.Fatalf("启动 %v 后才开始第一次备份", d)
+	}
+	for i := 1; i < len(starts); i++ {
+		if d := starts[i].Sub(starts[i-1]); d < interval || d > interval+50*time.Millisecond {
+			t.Fatalf("第 %d 次备份与上一次间隔 %v，预期 %v", i+1, d, interval)
+		}
+	}
+}
+
+func TestDaemonLoopSlowBackup(t *testing.T) {
+	// 备份耗时超过间隔时，上一次结束后立即开始下一次
+	duration := 80 * time.Millisecond
+	starts := runDaemonLoop(t, 20*time.Millisecond, duration, 2)
+	if len(starts) != 2 {
+		t.Fatalf("备份了 %d 次，预期 2 次", len(starts))
+	}
+	if d := starts[1].Sub(starts[0]); d < duration || d > duration+50*time.Millisecond {
+		t.Fatalf("两次备份间隔 %v，预期约 %v", d, duration)
+	}
+}
+
+func TestDaemonLoopCancelWhileWaiting(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	backups := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		daemonLoop(ctx, time.Hour, func(ctx context.Context) { backups++ })
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("等待下一次备份时取消没有返回")
+	}
+	if backups != 1 {
+		t.Fatalf("备份了 %d 次，预期 1 次", backups)
+	}
+}
+
+func TestDaemonFlags(t *testing.T) {
+	// daemon 的参数与 backup 相同，另外有 --interval 和 --metrics-addr。多集群备份和 Pushgateway 只用于 backup，
+	// daemon 通过 --metrics-addr 提供指标
+	backupOnly := map[string]bool{"clusters-file": true, "cluster-concurrency": true, "clusters-report": true,
+		"pushgateway-url": true, "pushgateway-job": true}
+	backupCmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if !backupOnly[f.Name] && daemonCmd.Flags().Lookup(f.Name) == nil {
+			t.Errorf("daemon 没有 backup 的参数 --%s", f.Name)
+		}
+	})
+	for _, name := range []string{"interval", "metrics-addr"} {
+		if daemonCmd.Flags().Lookup(name) == nil {
+			t.Errorf("daemon 没有 --%s 参数", name)
+		}
+	}
+}
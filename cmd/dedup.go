@@ -0,0 +1,405 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+var dedup bool
+
+// 去重备份不打包 tar，每个 TsFile 以其内容的 SHA-256 作为 key 只保存一次（blob），
+// 每次备份只上传一个引用这些 blob 的快照文件 <文件名>.snapshot.json。
+// 加密的 blob 在 SHA-256 后追加密钥后缀，不同密钥加密的同一内容保存为不同的 blob
+const (
+	backupTypeSnapshot = "snapshot"
+	snapshotSuffix     = ".snapshot.json"
+)
+
+// blob 对象元数据中记录的压缩算法、加密方式和公钥指纹，同一个 blob 可能由不同设置的备份上传
+const (
+	blobMetaCompression = "compression"
+	blobMetaEncryption  = "encryption"
+	blobMetaFingerprint = "fingerprint"
+)
+
+// Snapshot 是一次去重备份的内容，记录数据目录中每个文件及其 blob
+type Snapshot struct {
+	Version   int       `json:"version"`
+	Pod       string    `json:"pod"`
+	Container string    `json:"container"`
+	DataDir   string    `json:"dataDir"`
+	Time      time.Time `json:"time"`
+	// BlobSuffix 是快照引用的 blob 的密钥后缀，未加密时为空
+	BlobSuffix string       `json:"blobSuffix,omitempty"`
+	Files      []BackupFile `json:"files"`
+}
+
+func isSnapshotKey(key string) bool {
+	return strings.HasSuffix(key, snapshotSuffix)
+}
+
+// blobPrefix 返回命名空间下 blob 所在的目录，同一命名空间的所有 pod 共享 blob
+func blobPrefix(cluster, namespace string) string {
+	if cluster == "" {
+		cluster = "default"
+	}
+	return path.Join(cluster, namespace, "blobs") + "/"
+}
+
+func blobKey(cluster, namespace, sum, suffix string) string {
+	return blobPrefix(cluster, namespace) + sum[:2] + "/" + sum + suffix
+}
+
+// blobTempKey 返回上传中的 blob 所在的临时 key，校验通过后再复制到 blobKey。
+// 中断的上传留下的临时 blob 没有快照引用，由 prune 清理
+func blobTempKey(cluster, namespace, runID, sum, suffix string) string {
+	return blobPrefix(cluster, namespace) + "tmp/" + runID + "-" + sum + suffix
+}
+
+// blobSuffix 返回加密方式对应的 blob 密钥后缀：公钥加密为指纹的前 16 位，口令加密没有指纹，为加密方式
+func blobSuffix(enc *encryption) string {
+	switch {
+	case enc == nil:
+		return ""
+	case enc.info.Fingerprint != "":
+		return "-" + strings.TrimPrefix(enc.info.Fingerprint, "SHA256:")[:16]
+	default:
+		return "-" + enc.info.Method
+	}
+}
+
+// hashPodFiles 在 pod 中计算文件的 SHA-256。大小和修改时间与上一次备份相同的文件直接沿用上次的结果，
+// 封口的 TsFile 不需要每次重新读取
+func hashPodFiles(ctx context.Context, executor PodExecutor, namespace, podName, containerName string, files []BackupFile, previous []BackupFile) error {
+	known := make(map[string]BackupFile, len(previous))
+	for _, f := range previous {
+		if f.SHA256 != "" {
+			known[f.Path] = f
+		}
+	}
+
+	var list strings.Builder
+	pending := map[string]int{}
+	for i, f := range files {
+		if p, ok := known[f.Path]; ok && p.Size == f.Size && p.ModTime.Equal(f.ModTime) {
+			files[i].SHA256 = p.SHA256
+			continue
+		}
+		pending[f.Path] = i
+		list.WriteString(f.Path + "\n")
+	}
+	log(1, "pod %s 共 %d 个文件，需要计算校验和的文件 %d 个", podName, len(files), len(pending))
+	if len(pending) == 0 {
+		return nil
+	}
+
+	var output bytes.Buffer
+	cmd := []string{"sh", "-c", `while IFS= read -r f; do sha256sum -- "$f" || exit 1; done`}
+	if err := streamPodCommand(ctx, executor, namespace, podName, containerName, cmd, strings.NewReader(list.String()), &output); err != nil {
+		return fmt.Errorf("计算 pod %s 中文件的校验和失败: %v", podName, err)
+	}
+	// 输出格式为 <sha256>  <路径>
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		sum, filePath, ok := strings.Cut(line, "  ")
+		if !ok {
+			continue
+		}
+		if i, ok := pending[filePath]; ok {
+			files[i].SHA256 = sum
+			delete(pending, filePath)
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("pod %s 中有 %d 个文件没有得到校验和", podName, len(pending))
+	}
+	return nil
+}
+
+// backupSnapshot 上传存储中还不存在的 blob，再上传快照文件，返回快照文件的信息和新上传的字节数
+func backupSnapshot(ctx context.Context, executor PodExecutor, run *backupRun, key, podName, containerName string, files []BackupFile) (archiveInfo, int64, error) {
+	store, target := run.store, run.target
+	var missing []BackupFile
+	var missingSize int64
+	suffix := blobSuffix(run.enc)
+	seen := map[string]bool{}
+	for _, f := range files {
+		if seen[f.SHA256] {
+			continue
+		}
+		seen[f.SHA256] = true
+		_, err := store.Stat(ctx, blobKey(target.cluster, target.namespace, f.SHA256, suffix))
+		if err == ErrObjectNotFound {
+			missing = append(missing, f)
+			missingSize += f.Size
+		} else if err != nil {
+			return archiveInfo{}, 0, fmt.Errorf("检查 blob 失败: %v", err)
+		}
+	}
+	log(1, "pod %s 共 %d 个不同的文件内容，需要上传 %d 个（%s）", podName, len(seen), len(missing), formatSize(missingSize))
+
+	bar := progressbar.DefaultBytes(
+		missingSize,
+		podName+" 正在上传 blob",
+	)
+	for _, f := range missing {
+		if err := retry(ctx, "上传 blob", func() error {
+			return uploadBlob(ctx, executor, run, podName, containerName, f, bar)
+		}); err != nil {
+			return archiveInfo{}, 0, err
+		}
+	}
+
+	snapshot := Snapshot{
+		Version:    manifestVersion,
+		Pod:        podName,
+		Container:  containerName,
+		DataDir:    target.dataDir,
+		Time:       time.Now(),
+		BlobSuffix: suffix,
+		Files:      files,
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return archiveInfo{}, 0, err
+	}
+	sum := sha256.Sum256(data)
+	archive := archiveInfo{size: int64(len(data)), sha256: hex.EncodeToString(sum[:])}
+	if err := store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), checksumMetadata(archive.sha256, run.manifest.RunID)); err != nil {
+		return archiveInfo{}, 0, fmt.Errorf("上传快照文件失败: %v", err)
+	}
+	if err := writeChecksum(ctx, store, key, archive.sha256); err != nil {
+		return archive, 0, fmt.Errorf("保存校验和失败: %v", err)
+	}
+	return archive, missingSize, nil
+}
+
+// uploadBlob 通过 exec 读取 pod 中的文件，按本次备份的设置压缩、加密后上传到临时 key，
+// 读取的内容与 pod 中计算的校验和一致后再复制为 blob。文件在备份过程中被修改时只删除临时 key 并返回错误，
+// 已存在的 blob 不会被覆盖或删除
+func uploadBlob(ctx context.Context, executor PodExecutor, run *backupRun, podName, containerName string, f BackupFile, bar io.Writer) error {
+	target, suffix := run.target, blobSuffix(run.enc)
+	key := blobKey(target.cluster, target.namespace, f.SHA256, suffix)
+	tempKey := blobTempKey(target.cluster, target.namespace, run.manifest.RunID, f.SHA256, suffix)
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(streamPodCommand(ctx, executor, run.target.namespace, podName, containerName, []string{"cat", "--", f.Path}, nil, writer))
+	}()
+
+	hasher := sha256.New()
+	pipes := []*io.PipeReader{reader, run.comp.compressStream(io.TeeReader(reader, io.MultiWriter(hasher, bar)))}
+	meta := map[string]string{blobMetaCompression: run.comp.codec}
+	if run.enc != nil {
+		pipes = append(pipes, run.enc.encryptStream(pipes[len(pipes)-1]))
+		meta[blobMetaEncryption] = run.enc.info.Method
+		if run.enc.info.Fingerprint != "" {
+			meta[blobMetaFingerprint] = run.enc.info.Fingerprint
+		}
+	}
+	err := run.store.Put(ctx, tempKey, pipes[len(pipes)-1], -1, meta)
+	for _, pipe := range pipes {
+		pipe.CloseWithError(err)
+	}
+	if err != nil {
+		return fmt.Errorf("上传文件 %s 失败: %w", f.Path, err)
+	}
+	defer run.store.Delete(ctx, tempKey)
+
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != f.SHA256 {
+		return fmt.Errorf("文件 %s 在备份过程中发生了变化", f.Path)
+	}
+	// 同一命名空间的其他 pod 可能已经上传了相同的 blob
+	if _, err := run.store.Stat(ctx, key); err == nil {
+		log(2, "blob %s 已存在", key)
+		return nil
+	} else if err != ErrObjectNotFound {
+		return fmt.Errorf("检查 blob 失败: %v", err)
+	}
+	if err := copyObject(ctx, run.store, tempKey, key); err != nil {
+		return fmt.Errorf("保存 blob %s 失败: %w", key, err)
+	}
+	log(2, "已上传 %s 到 %s", f.Path, key)
+	return nil
+}
+
+// loadSnapshot 从存储读取快照文件，checksum 不为空时校验快照文件的 SHA-256
+func loadSnapshot(ctx context.Context, store Storage, key, checksum string) (*Snapshot, error) {
+	body, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("读取快照文件 %s 失败: %v", key, err)
+	}
+	sum := sha256.Sum256(data)
+	if actual := hex.EncodeToString(sum[:]); checksum != "" && actual != checksum {
+		return nil, &checksumMismatchError{key: key, expected: checksum, actual: actual}
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("解析快照文件 %s 失败: %v", key, err)
+	}
+	return &snapshot, nil
+}
+
+// extractSnapshotToPod 在本地读取快照引用的 blob，解密、解压并校验后组装为 tar 流，
+// 通过 exec 的标准输入交给 pod 中的 tar 解压，与解压普通备份得到的目录结构相同
+func extractSnapshotToPod(ctx context.Context, executor PodExecutor, storage Storage, enc *encryption, key, checksum, podName, containerName string) error {
+	snapshot, err := loadSnapshot(ctx, storage, key, checksum)
+	if err != nil {
+		return err
+	}
+	segments := strings.Split(key, "/")
+
+	var total int64
+	for _, f := range snapshot.Files {
+		total += f.Size
+	}
+	bar := progressbar.DefaultBytes(
+		total,
+		path.Base(key)+" 正在恢复",
+	)
+
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := func() error {
+			tw := tar.NewWriter(writer)
+			for _, f := range snapshot.Files {
+				if err := writeBlobToTar(ctx, tw, storage, enc, blobKey(segments[0], segments[1], f.SHA256, snapshot.BlobSuffix), f, bar); err != nil {
+					return err
+				}
+			}
+			return tw.Close()
+		}()
+		writer.CloseWithError(err)
+		done <- err
+	}()
+
+	extractCmd := fmt.Sprintf("mkdir -p '%s' && tar -xf - -C '%s'", restoreDir, restoreDir)
+	err = streamPodCommand(ctx, executor, namespace, podName, containerName, []string{"sh", "-c", extractCmd}, reader, nil)
+	if err == nil {
+		// tar 读到归档结束标记后可能不再读取，读完剩余数据以保证每个 blob 都经过校验
+		_, err = io.Copy(io.Discard, reader)
+	}
+	reader.CloseWithError(err)
+	// 组装 tar 流时的校验失败会导致 pod 中的 tar 报错，优先返回校验错误
+	var mismatch *checksumMismatchError
+	if buildErr := <-done; buildErr != nil && (err == nil || errors.As(buildErr, &mismatch)) {
+		return buildErr
+	}
+	if err != nil {
+		return fmt.Errorf("解压快照 %s 到 pod 失败: %w", key, err)
+	}
+	log(2, "快照 %s 的 %d 个文件已解压到 pod %s 的 %s", key, len(snapshot.Files), podName, restoreDir)
+	return nil
+}
+
+func writeBlobToTar(ctx context.Context, tw *tar.Writer, storage Storage, enc *encryption, key string, f BackupFile, bar io.Writer) error {
+	info, err := storage.Stat(ctx, key)
+	if err != nil {
+		return fmt.Errorf("读取 blob %s 失败: %w", key, err)
+	}
+	body, err := storage.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("读取 blob %s 失败: %w", key, err)
+	}
+	defer body.Close()
+
+	var reader io.Reader = body
+	if info.Metadata[blobMetaEncryption] != "" {
+		if enc == nil {
+			return fmt.Errorf("blob %s 已加密，需要指定 --encryption-key-file 或 --encryption-passphrase-env", key)
+		}
+		if reader, err = enc.decryptStream(reader); err != nil {
+			return err
+		}
+	}
+	codec := info.Metadata[blobMetaCompression]
+	if codec == "" {
+		codec = compressionNone
+	}
+	decompressed, err := decompressStream(codec, reader)
+	if err != nil {
+		return err
+	}
+	defer decompressed.Close()
+
+	header := &tar.Header{
+		Name:    strings.TrimPrefix(f.Path, "/"),
+		Mode:    0644,
+		Size:    f.Size,
+		ModTime: f.ModTime,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	hasher := sha256.New()
+	if _, err := io.CopyN(tw, io.TeeReader(decompressed, io.MultiWriter(hasher, bar)), f.Size); err != nil {
+		return fmt.Errorf("读取 blob %s 失败: %w", key, err)
+	}
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != f.SHA256 {
+		return &checksumMismatchError{key: key, expected: f.SHA256, actual: actual}
+	}
+	return nil
+}
+
+// gcBlobs 删除命名空间下没有被任何快照引用的 blob。deleted 中的快照视为已删除，
+// 修改时间在 grace 之内的 blob 不删除，避免删除正在进行的备份刚上传、还未写入快照的 blob
+func gcBlobs(ctx context.Context, storage Storage, cluster, namespace string, deleted map[string]bool, grace time.Duration, dryRun bool) (int, int64, error) {
+	if cluster == "" {
+		cluster = "default"
+	}
+	objects, err := storage.List(ctx, path.Join(cluster, namespace)+"/")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	referenced := map[string]bool{}
+	for _, object := range objects {
+		if !isSnapshotKey(object.Key) || deleted[object.Key] {
+			continue
+		}
+		snapshot, err := loadSnapshot(ctx, storage, object.Key, "")
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, f := range snapshot.Files {
+			referenced[f.SHA256+snapshot.BlobSuffix] = true
+		}
+	}
+
+	count, freed := 0, int64(0)
+	for _, object := range objects {
+		if !strings.HasPrefix(object.Key, blobPrefix(cluster, namespace)) {
+			continue
+		}
+		if referenced[path.Base(object.Key)] || time.Since(object.LastModified) < grace {
+			continue
+		}
+		if !dryRun {
+			if err := storage.Delete(ctx, object.Key); err != nil {
+				return count, freed, fmt.Errorf("删除 blob %s 失败: %v", object.Key, err)
+			}
+			log(2, "已删除 blob %s", object.Key)
+		}
+		count++
+		freed += object.Size
+	}
+	return count, freed, nil
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+)
+
+func readObject(t *testing.T, store Storage, key string) string {
+	t.Helper()
+	body, err := store.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("读取 %s 失败: %v", key, err)
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func testSum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestUploadBlobKeepsExistingBlob(t *testing.T) {
+	store, pod := setupBackupTest(t)
+	dedup = true
+	executor := newFakeExecutor()
+	p := newFakePod(executor)
+	p.write(testDataDir+"/a.tsfile", "a", time.Now().UTC())
+	entry := runTestBackup(t, executor, store, pod, nil)
+
+	key := blobKey(clusterName, namespace, testSum("a"), "")
+	original := readObject(t, store, key)
+
+	comp, err := newCompressor(compressionNone, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := &backupRun{target: flagTarget(), store: store, comp: comp, manifest: newBackupManifest(time.Now(), clusterName, namespace), metrics: newBackupMetrics()}
+	f := BackupFile{Path: testDataDir + "/a.tsfile", Size: 1, SHA256: testSum("a")}
+
+	// 读取时文件被修改：返回错误，已有的 blob 不被覆盖或删除
+	p.reading = "changed"
+	if err := uploadBlob(context.Background(), executor, run, pod.Name, testContainer, f, io.Discard); err == nil {
+		t.Fatal("文件被修改时应返回错误")
+	}
+	if got := readObject(t, store, key); got != original {
+		t.Fatalf("已有的 blob 被覆盖: %q", got)
+	}
+
+	// 其他 pod 同时上传了相同内容：保留已有的 blob
+	p.reading = ""
+	if err := uploadBlob(context.Background(), executor, run, pod.Name, testContainer, f, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if got := readObject(t, store, key); got != original {
+		t.Fatalf("已有的 blob 被覆盖: %q", got)
+	}
+
+	// 临时 key 都已删除
+	objects, err := store.List(context.Background(), blobPrefix(clusterName, namespace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, object := range objects {
+		if strings.Contains(object.Key, "/tmp/") {
+			t.Fatalf("临时 blob 没有删除: %s", object.Key)
+		}
+	}
+
+	if err := restoreTestBackup(t, executor, store, pod, entry.Key); err != nil {
+		t.Fatalf("恢复失败: %v", err)
+	}
+}
+
+func TestDedupEncryptedBlobsUseKeySuffix(t *testing.T) {
+	store, pod := setupBackupTest(t)
+	dedup = true
+	executor := newFakeExecutor()
+	p := newFakePod(executor)
+	p.write(testDataDir+"/a.tsfile", "a", time.Now().UTC())
+	plain := runTestBackup(t, executor, store, pod, nil)
+	plainKey := blobKey(clusterName, namespace, testSum("a"), "")
+	original := readObject(t, store, plainKey)
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "key.txt")
+	if err := os.WriteFile(keyFile, []byte(identity.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	enc, err := loadEncryptionKeyFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comp, err := newCompressor(compressionGzip, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outName = "enc"
+	target := flagTarget()
+	run := &backupRun{target: target, store: store, comp: comp, enc: enc, manifest: newBackupManifest(time.Now(), target.cluster, target.namespace), metrics: newBackupMetrics()}
+	if err := backupPod(context.Background(), executor, run, pod); err != nil {
+		t.Fatal(err)
+	}
+	encrypted := run.manifest.Entries[0]
+
+	// 加密的 blob 以密钥后缀另存一份，未加密的旧 blob 保持不变，旧快照仍可不用密钥恢复
+	encryptedKey := blobKey(clusterName, namespace, testSum("a"), blobSuffix(enc))
+	if encryptedKey == plainKey {
+		t.Fatal("加密的 blob 与未加密的 blob 使用相同的 key")
+	}
+	if _, err := store.Stat(context.Background(), encryptedKey); err != nil {
+		t.Fatalf("加密的 blob 不存在: %v", err)
+	}
+	if got := readObject(t, store, plainKey); got != original {
+		t.Fatalf("未加密的 blob 被覆盖: %q", got)
+	}
+	if err := restoreTestBackup(t, executor, store, pod, plain.Key); err != nil {
+		t.Fatalf("恢复未加密的快照失败: %v", err)
+	}
+	steps, removed, err := restorePlan(context.Background(), store, encrypted.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restorePod(context.Background(), executor, store, enc, pod, steps, removed); err != nil {
+		t.Fatalf("恢复加密的快照失败: %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+)
+
+// writeKeyFile 将 lines 写入临时的密钥文件
+func writeKeyFile(t *testing.T, lines ...string) string {
+	t.Helper()
+	fileName := filepath.Join(t.TempDir(), "key.txt")
+	if err := os.WriteFile(fileName, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func newTestIdentity(t *testing.T) *age.X25519Identity {
+	t.Helper()
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity
+}
+
+// encryptBytes 加密 data，返回密文
+func encryptBytes(t *testing.T, enc *encryption, data []byte) []byte {
+	t.Helper()
+	encrypted, err := io.ReadAll(enc.encryptStream(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatalf("加密失败: %v", err)
+	}
+	return encrypted
+}
+
+// decryptBytes 解密 data，返回明文
+func decryptBytes(enc *encryption, data []byte) ([]byte, error) {
+	r, err := enc.decryptStream(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	savedKeyFile, savedPassphraseEnv := encryptionKeyFile, encryptionPassphraseEnv
+	t.Cleanup(func() { encryptionKeyFile, encryptionPassphraseEnv = savedKeyFile, savedPassphraseEnv })
+	// 大小超过 age 的 64KiB 数据块
+	data := bytes.Repeat([]byte("iotdb tsfile "), 20000)
+
+	identity := newTestIdentity(t)
+	t.Setenv("TEST_BACKUP_PASSPHRASE", "correct horse battery staple")
+	tests := []struct {
+		name       string
+		keyFile    string
+		passphrase string
+		method     string
+	}{
+		{"x25519", writeKeyFile(t, "# created: 2024-09-20", identity.String()), "", encryptionX25519},
+		{"scrypt", "", "TEST_BACKUP_PASSPHRASE", encryptionScrypt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encryptionKeyFile, encryptionPassphraseEnv = tt.keyFile, tt.passphrase
+			enc, err := loadEncryption()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if enc.info.Method != tt.method {
+				t.Fatalf("加密方式: %s，预期 %s", enc.info.Method, tt.method)
+			}
+			encrypted := encryptBytes(t, enc, data)
+			if bytes.Contains(encrypted, []byte("iotdb tsfile")) {
+				t.Fatal("密文中包含明文")
+			}
+			decrypted, err := decryptBytes(enc, encrypted)
+			if err != nil {
+				t.Fatalf("解密失败: %v", err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatalf("解密后的数据不一致: %d 字节，预期 %d 字节", len(decrypted), len(data))
+			}
+
+			// 截断的密文在读到末尾时报错
+			if _, err := decryptBytes(enc, encrypted[:len(encrypted)-1]); err == nil {
+				t.Fatal("截断的密文应解密失败")
+			}
+		})
+	}
+}
+
+func TestEncryptionKeys(t *testing.T) {
+	identity, other := newTestIdentity(t), newTestIdentity(t)
+	data := []byte("tsfile")
+
+	// 只有公钥的密钥文件只能加密，指纹与私钥文件相同
+	public, err := loadEncryptionKeyFile(writeKeyFile(t, identity.Recipient().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := loadEncryptionKeyFile(writeKeyFile(t, identity.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public.info.Fingerprint != private.info.Fingerprint || !strings.HasPrefix(public.info.Fingerprint, "SHA256:") {
+		t.Fatalf("指纹不一致: %s %s", public.info.Fingerprint, private.info.Fingerprint)
+	}
+	encrypted := encryptBytes(t, public, data)
+	if _, err := decryptBytes(public, encrypted); err == nil {
+		t.Fatal("只有公钥时应无法解密")
+	}
+	if decrypted, err := decryptBytes(private, encrypted); err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("用私钥解密失败: %v", err)
+	}
+
+	// 其他密钥无法解密
+	wrong, err := loadEncryptionKeyFile(writeKeyFile(t, other.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decryptBytes(wrong, encrypted); err == nil {
+		t.Fatal("使用其他密钥应解密失败")
+	}
+
+	// 多个公钥时指纹与顺序无关，任一私钥都能解密
+	both, err := loadEncryptionKeyFile(writeKeyFile(t, identity.Recipient().String(), other.Recipient().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reversed, err := loadEncryptionKeyFile(writeKeyFile(t, other.Recipient().String(), identity.Recipient().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if both.info.Fingerprint != reversed.info.Fingerprint {
+		t.Fatal("指纹与公钥的顺序有关")
+	}
+	if _, err := decryptBytes(wrong, encryptBytes(t, both, data)); err != nil {
+		t.Fatalf("用其中一个私钥解密失败: %v", err)
+	}
+}
+
+func TestLoadEncryptionErrors(t *testing.T) {
+	savedKeyFile, savedPassphraseEnv := encryptionKeyFile, encryptionPassphraseEnv
+	t.Cleanup(func() { encryptionKeyFile, encryptionPassphraseEnv = savedKeyFile, savedPassphraseEnv })
+	t.Setenv("TEST_BACKUP_PASSPHRASE", "")
+
+	tests := []struct {
+		name       string
+		keyFile    string
+		passphrase string
+	}{
+		{"both", writeKeyFile(t, newTestIdentity(t).String()), "TEST_BACKUP_PASSPHRASE"},
+		{"empty passphrase", "", "TEST_BACKUP_PASSPHRASE"},
+		{"missing file", filepath.Join(t.TempDir(), "missing.txt"), ""},
+		{"no keys", writeKeyFile(t, "# no keys"), ""},
+		{"invalid key", writeKeyFile(t, "AGE-SECRET-KEY-INVALID"), ""},
+		{"unknown content", writeKeyFile(t, "ssh-ed25519 AAAA"), ""},
+	}
+	for _, tt := range tests {
+		encryptionKeyFile, encryptionPassphraseEnv = tt.keyFile, tt.passphrase
+		if _, err := loadEncryption(); err == nil {
+			t.Errorf("%s: 预期加载失败", tt.name)
+		}
+	}
+
+	encryptionKeyFile, encryptionPassphraseEnv = "", ""
+	if enc, err := loadEncryption(); enc != nil || err != nil {
+		t.Fatalf("没有指定密钥时应返回 nil: %v %v", enc, err)
+	}
+}
+
+func TestBackupAndRestoreEncrypted(t *testing.T) {
+	store, pod := setupBackupTest(t)
+	executor := newFakeExecutor()
+	p := newFakePod(executor)
+	p.write(testDataDir+"/a.tsfile", "tsfile a", time.Now().UTC())
+
+	enc, err := loadEncryptionKeyFile(writeKeyFile(t, newTestIdentity(t).String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	comp, err := newCompressor(compressionZstd, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := flagTarget()
+	run := &backupRun{target: target, store: store, comp: comp, enc: enc, manifest: newBackupManifest(time.Now(), target.cluster, target.namespace), metrics: newBackupMetrics()}
+	if err := backupPod(context.Background(), executor, run, pod); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveManifest(context.Background(), store, run.manifest); err != nil {
+		t.Fatal(err)
+	}
+	entry := run.manifest.Entries[0]
+	if !isEncryptedKey(entry.Key) || entry.Encryption == nil || entry.Encryption.Fingerprint != enc.info.Fingerprint {
+		t.Fatalf("清单中没有记录加密信息: %+v", entry)
+	}
+	// 存储中只有密文，校验和针对密文
+	if strings.Contains(readObject(t, store, entry.Key), "tsfile a") {
+		t.Fatal("存储中的备份没有加密")
+	}
+	if _, err := verifyObject(context.Background(), store, entry.Key, entry.SHA256); err != nil {
+		t.Fatal(err)
+	}
+
+	steps, removed, err := restorePlan(context.Background(), store, entry.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restorePod(context.Background(), executor, store, nil, pod, steps, removed); err == nil {
+		t.Fatal("没有密钥时恢复加密的备份应失败")
+	}
+	if err := restorePod(context.Background(), executor, store, enc, pod, steps, removed); err != nil {
+		t.Fatalf("恢复失败: %v", err)
+	}
+	if len(p.loaded) != 1 {
+		t.Fatalf("加载的 tsfile: %v", p.loaded)
+	}
+}
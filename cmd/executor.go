@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// PodExecutor 在 pod 的容器中执行命令，stdin、stdout、stderr 为 nil 时不建立对应的流。
+// 命令退出码非 0 时返回 exec.ExitError，ctx 取消时中断执行
+type PodExecutor interface {
+	Exec(ctx context.Context, namespace, podName, containerName string, cmd []string, stdin io.Reader, stdout, stderr io.Writer) error
+}
+
+// spdyExecutor 通过 API Server 的 pods/exec 子资源执行命令
+type spdyExecutor struct {
+	config    *rest.Config
+	clientset kubernetes.Interface
+}
+
+func newPodExecutor(config *rest.Config, clientset kubernetes.Interface) PodExecutor {
+	return &spdyExecutor{config: config, clientset: clientset}
+}
+
+func (e *spdyExecutor) Exec(ctx context.Context, namespace, podName, containerName string, cmd []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	req := e.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(podName).
+		Namespace(namespace).
+		SubResource("exec").
+		VersionedParams(&v1.PodExecOptions{
+			Container: containerName,
+			Command:   cmd,
+			Stdin:     stdin != nil,
+			Stdout:    stdout != nil,
+			Stderr:    stderr != nil,
+			TTY:       false,
+		}, scheme.ParameterCodec)
+
+	exec, err := remotecommand.NewSPDYExecutor(e.config, "POST", req.URL())
+	if err != nil {
+		return fmt.Errorf("error creating executor: %v", err)
+	}
+	return exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+		Tty:    false,
+	})
+}
+
+// retryPodCommand 执行输出较小的命令，返回标准输出和标准错误，临时性错误按 op 重试
+func retryPodCommand(ctx context.Context, executor PodExecutor, op, namespace, podName, containerName string, cmd []string) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+	err := retry(ctx, op, func() error {
+		stdout.Reset()
+		stderr.Reset()
+		return executor.Exec(ctx, namespace, podName, containerName, cmd, nil, &stdout, &stderr)
+	})
+	return stdout.String(), stderr.String(), err
+}
+
+func executePodCommand(ctx context.Context, executor PodExecutor, namespace, podName, containerName string, cmd []string) (string, error) {
+	stdout, stderr, err := executePodCommandWithStderr(ctx, executor, namespace, podName, containerName, cmd)
+	if err != nil {
+		return "", fmt.Errorf("error executing command: %w, stderr: %s", err, stderr)
+	}
+	return stdout, nil
+}
+
+func executePodCommandWithStderr(ctx context.Context, executor PodExecutor, namespace, podName, containerName string, cmd []string) (string, string, error) {
+	return retryPodCommand(ctx, executor, "执行命令", namespace, podName, containerName, cmd)
+}
+
+// streamPodCommand 在 pod 中执行命令，stdin、stdout 均以流的方式传输，适用于大文件的读写
+func streamPodCommand(ctx context.Context, executor PodExecutor, namespace, podName, containerName string, cmd []string, stdin io.Reader, stdout io.Writer) error {
+	var stderr bytes.Buffer
+	if err := executor.Exec(ctx, namespace, podName, containerName, cmd, stdin, stdout, &stderr); err != nil {
+		return fmt.Errorf("error executing command: %w, stderr: %s", err, stderr.String())
+	}
+	return nil
+}
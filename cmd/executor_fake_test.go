@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"k8s.io/client-go/util/exec"
+)
+
+// fakeExecutor 是按脚本模拟 pod 中命令的 PodExecutor，与 k8s.io/client-go/kubernetes/fake 一起使用，
+// 不需要集群就可以测试备份和恢复流程。命令依次与 on 注册的脚本匹配，先注册的优先
+type fakeExecutor struct {
+	mu       sync.Mutex
+	commands []*fakeCommand
+	// calls 按执行顺序记录每次调用
+	calls []fakeCall
+}
+
+// fakeCommand 是 fakeExecutor 中的一条脚本：命令以 prefix 开头时，设置了 run 则执行 run，
+// 否则输出 stdout、stderr 后返回 err
+type fakeCommand struct {
+	pod       string // 为空时匹配所有 pod
+	container string // 为空时匹配所有容器
+	prefix    []string
+	stdout    string
+	stderr    string
+	err       error
+	run       func(cmd []string, stdin io.Reader, stdout, stderr io.Writer) error
+	// times 是脚本最多匹配的次数，0 表示不限制，用于模拟先失败后成功
+	times int
+
+	used int
+}
+
+// fakeCall 是 fakeExecutor 的一次调用
+type fakeCall struct {
+	namespace string
+	pod       string
+	container string
+	command   []string
+	stdin     []byte
+}
+
+func newFakeExecutor() *fakeExecutor {
+	return &fakeExecutor{}
+}
+
+// on 注册以 prefix 开头的命令的脚本，返回的 fakeCommand 可以继续设置输出和错误
+func (f *fakeExecutor) on(prefix ...string) *fakeCommand {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := &fakeCommand{prefix: prefix}
+	f.commands = append(f.commands, c)
+	return c
+}
+
+// output 设置命令的标准输出
+func (c *fakeCommand) output(stdout string) *fakeCommand {
+	c.stdout = stdout
+	return c
+}
+
+// do 设置执行命令的函数，用于模拟读写数据流的命令
+func (c *fakeCommand) do(run func(cmd []string, stdin io.Reader, stdout, stderr io.Writer) error) *fakeCommand {
+	c.run = run
+	return c
+}
+
+// fail 让命令以 code 退出，stderr 写入标准错误
+func (c *fakeCommand) fail(code int, stderr string) *fakeCommand {
+	c.stderr = stderr
+	c.err = exec.CodeExitError{Err: fmt.Errorf("command terminated with exit code %d", code), Code: code}
+	return c
+}
+
+// Exec 读取全部标准输入后执行匹配的脚本，没有匹配的脚本时返回错误
+func (f *fakeExecutor) Exec(ctx context.Context, namespace, podName, containerName string, cmd []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var input []byte
+	if stdin != nil {
+		var err error
+		if input, err = io.ReadAll(stdin); err != nil {
+			return err
+		}
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, fakeCall{namespace: namespace, pod: podName, container: containerName, command: cmd, stdin: input})
+	c := f.match(podName, containerName, cmd)
+	f.mu.Unlock()
+	if c == nil {
+		return fmt.Errorf("fake: pod %s 容器 %s 中没有匹配的命令: %s", podName, containerName, strings.Join(cmd, " "))
+	}
+
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	if c.run != nil {
+		return c.run(cmd, bytes.NewReader(input), stdout, stderr)
+	}
+	if _, err := io.WriteString(stdout, c.stdout); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(stderr, c.stderr); err != nil {
+		return err
+	}
+	return c.err
+}
+
+func (f *fakeExecutor) match(podName, containerName string, cmd []string) *fakeCommand {
+	for _, c := range f.commands {
+		if c.times > 0 && c.used >= c.times {
+			continue
+		}
+		if (c.pod != "" && c.pod != podName) || (c.container != "" && c.container != containerName) {
+			continue
+		}
+		if len(cmd) < len(c.prefix) {
+			continue
+		}
+		matched := true
+		for i, arg := range c.prefix {
+			if cmd[i] != arg {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			c.used++
+			return c
+		}
+	}
+	return nil
+}
+
+// commandLines 返回所有调用执行的命令，便于断言调用顺序
+func (f *fakeExecutor) commandLines() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	commands := make([]string, 0, len(f.calls))
+	for _, call := range f.calls {
+		commands = append(commands, strings.Join(call.command, " "))
+	}
+	return commands
+}
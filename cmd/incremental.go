@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var incremental bool
+
+// 备份类型，只有增量模式下的备份记录文件列表，可以作为后续增量备份的父备份
+const (
+	backupTypeFull        = "full"
+	backupTypeIncremental = "incremental"
+)
+
+// BackupFile 是备份时数据目录中的一个 TsFile 相关文件
+type BackupFile struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	SHA256  string    `json:"sha256,omitempty"`
+}
+
+// listPodFiles 列出 pod 数据目录中的 TsFile 及其 .resource、.mods 文件的大小和修改时间。
+// TsFile 封口后不再修改，只需要比较大小和修改时间就能找出新增和变化的文件
+func listPodFiles(ctx context.Context, executor PodExecutor, namespace, podName, containerName, dataDir string) ([]BackupFile, error) {
+	cmd := []string{"find", dataDir, "-type", "f",
+		"(", "-name", "*.tsfile", "-o", "-name", "*.tsfile.resource", "-o", "-name", "*.tsfile.mods", ")",
+		"-printf", `%s %T@ %p\n`}
+	output, err := executePodCommand(ctx, executor, namespace, podName, containerName, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("列出 pod %s 中的 TsFile 失败: %v", podName, err)
+	}
+
+	var files []BackupFile
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line == "" {
+			continue
+		}
+		// <大小> <秒>.<纳秒> <路径>，路径中可能有空格
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("无法解析文件信息: %s", line)
+		}
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("无法解析文件信息: %s", line)
+		}
+		modTime, err := parseFindTime(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("无法解析文件信息: %s", line)
+		}
+		files = append(files, BackupFile{Path: fields[2], Size: size, ModTime: modTime})
+	}
+	return files, nil
+}
+
+// parseFindTime 解析 find -printf %T@ 输出的 1725608488.1234567890，按整数解析避免浮点误差
+func parseFindTime(s string) (time.Time, error) {
+	secPart, nsecPart, _ := strings.Cut(s, ".")
+	sec, err := strconv.ParseInt(secPart, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var nsec int64
+	if nsecPart != "" {
+		nsecPart = (nsecPart + "000000000")[:9]
+		if nsec, err = strconv.ParseInt(nsecPart, 10, 64); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.Unix(sec, nsec).UTC(), nil
+}
+
+// findParent 在历史备份中查找同一 pod、容器、数据目录最近一次指定类型的备份
+func findParent(history map[string]runEntry, podName, containerName, dataDir string, types ...string) *runEntry {
+	var parent *runEntry
+	for _, entry := range history {
+		if !containsString(types, entry.Type) || entry.Pod != podName || entry.Container != containerName || entry.DataDir != dataDir {
+			continue
+		}
+		if parent == nil || entry.StartTime.After(parent.StartTime) {
+			entry := entry
+			parent = &entry
+		}
+	}
+	return parent
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// changedFiles 返回相对父备份新增或大小、修改时间发生变化的文件路径
+func changedFiles(files, parentFiles []BackupFile) []string {
+	previous := make(map[string]BackupFile, len(parentFiles))
+	for _, f := range parentFiles {
+		previous[f.Path] = f
+	}
+	var changed []string
+	for _, f := range files {
+		if p, ok := previous[f.Path]; !ok || p.Size != f.Size || !p.ModTime.Equal(f.ModTime) {
+			changed = append(changed, f.Path)
+		}
+	}
+	return changed
+}
+
+// maxChainLength 限制备份链的长度，防止清单损坏导致的循环
+const maxChainLength = 1000
+
+// backupChain 返回从全量备份到 key 的备份链，key 不是增量备份时只包含它自己
+func backupChain(entries map[string]runEntry, key string) ([]runEntry, error) {
+	entry, ok := entries[key]
+	if !ok {
+		return nil, fmt.Errorf("清单中没有备份 %s 的记录", key)
+	}
+	chain := []runEntry{entry}
+	for entry.Parent != "" {
+		if len(chain) > maxChainLength {
+			return nil, fmt.Errorf("备份 %s 的备份链过长", key)
+		}
+		parent, ok := entries[entry.Parent]
+		if !ok {
+			return nil, fmt.Errorf("找不到备份 %s 的父备份 %s，可能已被删除", entry.Key, entry.Parent)
+		}
+		chain = append([]runEntry{parent}, chain...)
+		entry = parent
+	}
+	return chain, nil
+}
+
+// removedFiles 返回备份链中出现过、但在最后一个备份时已经不存在的文件，
+// 如合并（compaction）后删除的 TsFile，恢复时需要删除这些文件
+func removedFiles(chain []runEntry) []string {
+	final := map[string]bool{}
+	for _, f := range chain[len(chain)-1].Files {
+		final[f.Path] = true
+	}
+	seen := map[string]bool{}
+	var removed []string
+	for _, entry := range chain[:len(chain)-1] {
+		for _, f := range entry.Files {
+			if !final[f.Path] && !seen[f.Path] {
+				seen[f.Path] = true
+				removed = append(removed, f.Path)
+			}
+		}
+	}
+	return removed
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	kubeContext string
+	kubeAs      string
+	kubeAsGroup []string
+	kubeQPS     float32
+	kubeBurst   int
+)
+
+// addKubeFlags 添加访问 Kubernetes 的参数，由 addStorageFlags 添加到所有读写存储的命令
+func addKubeFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&configPath, "kubeconfig", "", "kubeconfig 文件路径，为空时依次使用 KUBECONFIG 环境变量、~/.kube/config、集群内的 ServiceAccount")
+	cmd.Flags().StringVar(&configPath, "config", "", "kubeconfig 文件路径")
+	cmd.Flags().MarkDeprecated("config", "请使用 --kubeconfig")
+	cmd.Flags().StringVar(&kubeContext, "context", "", "使用 kubeconfig 中的指定 context，默认使用 current-context")
+	cmd.Flags().StringVar(&kubeAs, "as", "", "以指定的用户或 ServiceAccount（system:serviceaccount:<命名空间>:<名称>）身份访问 Kubernetes")
+	cmd.Flags().StringSliceVar(&kubeAsGroup, "as-group", nil, "以指定的用户组身份访问 Kubernetes，与 --as 一起使用")
+	cmd.Flags().Float32Var(&kubeQPS, "kube-qps", 20, "访问 API Server 的每秒请求数上限，exec 也计入在内")
+	cmd.Flags().IntVar(&kubeBurst, "kube-burst", 40, "访问 API Server 的突发请求数上限")
+}
+
+// kubeRestConfig 按 kubeconfig、KUBECONFIG、~/.kube/config 的顺序加载 kubeconfig，
+// 都不存在时使用集群内的 ServiceAccount，以 CronJob 运行在被备份的集群中时不需要 kubeconfig
+func kubeRestConfig(kubeconfig, context string) (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: context}
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		if clientcmd.IsEmptyConfig(err) {
+			return nil, fmt.Errorf("没有找到 kubeconfig，也不在集群内运行，请使用 --kubeconfig 指定")
+		}
+		return nil, err
+	}
+
+	if kubeAs != "" || len(kubeAsGroup) > 0 {
+		config.Impersonate = rest.ImpersonationConfig{UserName: kubeAs, Groups: kubeAsGroup}
+	}
+	if kubeQPS > 0 {
+		config.QPS = kubeQPS
+	}
+	if kubeBurst > 0 {
+		config.Burst = kubeBurst
+	}
+	log(2, "Kubernetes API Server: %s", config.Host)
+	return config, nil
+}
+
+// kubeClients 是按 --kubeconfig、--context 创建的客户端，备份、恢复和读取凭证 Secret 共用
+var kubeClients struct {
+	sync.Mutex
+	clientset kubernetes.Interface
+	executor  PodExecutor
+}
+
+// getKubeClients 返回按 --kubeconfig、--context 创建的 Kubernetes 客户端，第一次调用时创建
+func getKubeClients() (kubernetes.Interface, PodExecutor, error) {
+	kubeClients.Lock()
+	defer kubeClients.Unlock()
+	if kubeClients.clientset == nil {
+		clientset, executor, err := newKubeClients(configPath, kubeContext)
+		if err != nil {
+			return nil, nil, err
+		}
+		kubeClients.clientset, kubeClients.executor = clientset, executor
+	}
+	return kubeClients.clientset, kubeClients.executor, nil
+}
+
+// newKubeClients 创建访问 API Server 的 clientset 和在 pod 中执行命令的 PodExecutor，共用同一个 rest.Config
+func newKubeClients(kubeconfig, context string) (kubernetes.Interface, PodExecutor, error) {
+	config, err := kubeRestConfig(kubeconfig, context)
+	if err != nil {
+		return nil, nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return clientset, newPodExecutor(config, clientset), nil
+}
@@ -0,0 +1,323 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	listOutput string
+	listLegacy bool
+)
+
+func init() {
+	listCmd.Flags().StringVar(&listOutput, "output", "table", "输出格式: table、json、yaml")
+	listCmd.Flags().BoolVar(&listLegacy, "legacy", false, "同时列出旧版本上传到 bucket 根目录下的备份（需要遍历整个 bucket）")
+	listCmd.Flags().StringSliceVar(&pods, "pods", []string{}, "只列出指定 pod 的备份，多个 pod 用逗号分隔")
+	addScopeFlags(listCmd)
+	addStorageFlags(listCmd)
+	rootCmd.AddCommand(listCmd)
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list backups in storage",
+	Long:  `列出存储中指定集群、命名空间下各 pod 的备份文件，包括大小、备份时间、距今时长和校验和状态。`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		if listOutput != "table" && listOutput != "json" && listOutput != "yaml" {
+			fmt.Printf("错误：不支持的输出格式 %s\n", listOutput)
+			os.Exit(1)
+		}
+
+		store, err := openStorage(ctx)
+		if err != nil {
+			fmt.Printf("打开存储失败: %v\n", err)
+			os.Exit(1)
+		}
+
+		backups, err := listBackups(ctx, store, clusterName, namespace, pods, listLegacy)
+		if err != nil {
+			fmt.Printf("列出备份失败: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := printBackups(backups, listOutput); err != nil {
+			fmt.Printf("输出备份列表失败: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// 备份文件的校验和状态
+const (
+	checksumRecorded = "recorded"
+	checksumManifest = "manifest"
+	checksumMissing  = "missing"
+)
+
+// BackupRecord 是存储中的一个备份文件
+type BackupRecord struct {
+	Key       string    `json:"key"`
+	Cluster   string    `json:"cluster,omitempty"`
+	Namespace string    `json:"namespace,omitempty"`
+	Pod       string    `json:"pod"`
+	Container string    `json:"container,omitempty"`
+	RunID     string    `json:"runId,omitempty"`
+	Type      string    `json:"type,omitempty"`
+	Parent    string    `json:"parent,omitempty"`
+	Size      int64     `json:"size"`
+	Time      time.Time `json:"time"`
+	Age       string    `json:"age"`
+	Checksum  string    `json:"checksum"`
+	SHA256    string    `json:"sha256,omitempty"`
+	// Status 是清单中记录的备份状态，没有清单的旧备份为空
+	Status string `json:"status,omitempty"`
+}
+
+// succeeded 判断备份是否成功。旧版本只在备份成功后上传且没有清单，视为成功
+func (b BackupRecord) succeeded() bool {
+	return b.Status == "" || b.Status == backupStatusSuccess
+}
+
+// backupNamePattern 匹配 getBackupFileName 生成的文件名: [<outname>_]<pod>_<YYYYMMDDhhmmss>.tar[.gz|.zst|.lz4][.age]
+// 和去重备份的 [<outname>_]<pod>_<YYYYMMDDhhmmss>.snapshot.json，pod 名称中不会出现下划线，因此时间戳前的最后一段即为 pod 名称
+var backupNamePattern = regexp.MustCompile(`^(?:.*_)?([^_]+)_(\d{14})\.(?:tar(?:\.[a-z0-9]+)*|snapshot\.json)$`)
+
+// parseBackupFileName 从备份文件名中解析 pod 名称和备份时间
+func parseBackupFileName(fileName string) (string, time.Time, bool) {
+	match := backupNamePattern.FindStringSubmatch(fileName)
+	if match == nil {
+		return "", time.Time{}, false
+	}
+	t, err := time.ParseInLocation("20060102150405", match[2], time.Local)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return match[1], t, true
+}
+
+// listBackups 列出集群和命名空间下的备份文件，按 pod 名称、备份时间倒序排列。
+// 备份清单中有记录的文件使用清单中的信息，旧版本没有清单的备份从文件名中解析 pod 和时间
+func listBackups(ctx context.Context, store Storage, cluster, namespace string, podFilter []string, legacy bool) ([]BackupRecord, error) {
+	if cluster == "" {
+		cluster = "default"
+	}
+	prefix := path.Join(cluster, namespace) + "/"
+	if len(podFilter) == 1 {
+		prefix += podFilter[0] + "/"
+	}
+
+	objects, err := store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if legacy {
+		all, err := store.List(ctx, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range all {
+			if !strings.Contains(object.Key, "/") {
+				objects = append(objects, object)
+			}
+		}
+	}
+
+	entries, err := allManifestEntries(ctx, store, cluster, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	checksums := map[string]bool{}
+	for _, object := range objects {
+		if isChecksumKey(object.Key) {
+			checksums[strings.TrimSuffix(object.Key, checksumSuffix)] = true
+		}
+	}
+
+	wantPod := map[string]bool{}
+	for _, pod := range podFilter {
+		wantPod[pod] = true
+	}
+
+	now := time.Now()
+	var backups []BackupRecord
+	for _, object := range objects {
+		if isChecksumKey(object.Key) || strings.HasPrefix(object.Key, manifestPrefix(cluster, namespace)) ||
+			strings.HasPrefix(object.Key, blobPrefix(cluster, namespace)) {
+			continue
+		}
+
+		record := BackupRecord{
+			Key:  object.Key,
+			Size: object.Size,
+			Time: object.LastModified,
+		}
+		pod, t, named := parseBackupFileName(path.Base(object.Key))
+		if named {
+			record.Pod, record.Time = pod, t
+		}
+		// <集群>/<命名空间>/<pod>/<日期>/<文件名>
+		if segments := strings.Split(object.Key, "/"); len(segments) == 5 {
+			record.Cluster, record.Namespace, record.Pod = segments[0], segments[1], segments[2]
+		} else if !named {
+			log(2, "跳过无法识别的对象 %s", object.Key)
+			continue
+		}
+		if len(wantPod) > 0 && !wantPod[record.Pod] {
+			continue
+		}
+
+		record.Checksum = checksumMissing
+		if entry, ok := entries[object.Key]; ok {
+			record.Container, record.RunID, record.SHA256 = entry.Container, entry.runID, entry.SHA256
+			record.Type, record.Parent, record.Status = entry.Type, entry.Parent, entry.Status
+			if !entry.StartTime.IsZero() {
+				record.Time = entry.StartTime
+			}
+			if entry.SHA256 != "" && entry.Status == backupStatusSuccess {
+				record.Checksum = checksumManifest
+			}
+		}
+		if checksums[object.Key] {
+			record.Checksum = checksumRecorded
+		}
+		record.Age = formatAge(now.Sub(record.Time))
+		backups = append(backups, record)
+	}
+
+	sort.SliceStable(backups, func(i, j int) bool {
+		if backups[i].Pod != backups[j].Pod {
+			return backups[i].Pod < backups[j].Pod
+		}
+		return backups[i].Time.After(backups[j].Time)
+	})
+	return backups, nil
+}
+
+// runEntry 是带有运行 ID 的清单记录
+type runEntry struct {
+	ManifestEntry
+	runID string
+}
+
+// manifestEntries 读取命名空间下所有成功的清单记录，按备份文件 key 索引
+func manifestEntries(ctx context.Context, store Storage, cluster, namespace string) (map[string]runEntry, error) {
+	all, err := allManifestEntries(ctx, store, cluster, namespace)
+	if err != nil {
+		return nil, err
+	}
+	entries := map[string]runEntry{}
+	for key, entry := range all {
+		if entry.Status == backupStatusSuccess {
+			entries[key] = entry
+		}
+	}
+	return entries, nil
+}
+
+// allManifestEntries 读取命名空间下所有带有备份文件的清单记录，包括失败的记录，按备份文件 key 索引。
+// 同一个 key 既有成功又有失败的记录时使用成功的记录
+func allManifestEntries(ctx context.Context, store Storage, cluster, namespace string) (map[string]runEntry, error) {
+	objects, err := store.List(ctx, manifestPrefix(cluster, namespace))
+	if err != nil {
+		return nil, err
+	}
+	entries := map[string]runEntry{}
+	for _, object := range objects {
+		if !strings.HasSuffix(object.Key, ".json") {
+			continue
+		}
+		m, err := loadManifest(ctx, store, object.Key)
+		if err != nil {
+			log(1, "读取清单 %s 失败: %v", object.Key, err)
+			continue
+		}
+		for _, entry := range m.Entries {
+			if entry.Key == "" {
+				continue
+			}
+			if previous, ok := entries[entry.Key]; ok && previous.Status == backupStatusSuccess {
+				continue
+			}
+			entries[entry.Key] = runEntry{ManifestEntry: entry, runID: m.RunID}
+		}
+	}
+	return entries, nil
+}
+
+// formatAge 将时长格式化为 3d4h、5h12m、42m 这样的简短形式
+func formatAge(d time.Duration) string {
+	switch {
+	case d < 0:
+		return "0m"
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd%dh", int(d.Hours())/24, int(d.Hours())%24)
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh%dm", int(d.Hours()), int(d.Minutes())%60)
+	default:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+}
+
+// formatSize 将字节数格式化为便于阅读的形式
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func printBackups(backups []BackupRecord, format string) error {
+	if backups == nil {
+		backups = []BackupRecord{}
+	}
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(backups, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	case "yaml":
+		data, err := yaml.Marshal(backups)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(data))
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "POD\tTIME\tAGE\tSIZE\tTYPE\tSTATUS\tCHECKSUM\tKEY")
+		for _, b := range backups {
+			backupType, status := b.Type, b.Status
+			if backupType == "" {
+				backupType = "-"
+			}
+			if status == "" {
+				status = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				b.Pod, b.Time.Local().Format("2006-01-02 15:04:05"), b.Age, formatSize(b.Size), backupType, status, b.Checksum, b.Key)
+		}
+		return w.Flush()
+	}
+	return nil
+}
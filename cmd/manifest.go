@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const manifestVersion = 1
+
+// 备份结果状态
+const (
+	backupStatusSuccess = "success"
+	backupStatusFailed  = "failed"
+	backupStatusPartial = "partial" // 一次运行中部分 pod 失败
+)
+
+// BackupManifest 描述一次备份运行产生的所有备份文件，以 JSON 保存在
+// <集群>/<命名空间>/manifests/<runID>.json，restore、审计等通过它获取备份的元数据
+type BackupManifest struct {
+	Version     int             `json:"version"`
+	RunID       string          `json:"runId"`
+	ToolVersion string          `json:"toolVersion"`
+	Cluster     string          `json:"cluster"`
+	Namespace   string          `json:"namespace"`
+	Storage     string          `json:"storage,omitempty"`
+	StartTime   time.Time       `json:"startTime"`
+	EndTime     time.Time       `json:"endTime"`
+	Entries     []ManifestEntry `json:"entries"`
+	// Retries 是本次运行中各操作因临时性错误重试的次数
+	Retries map[string]int `json:"retries,omitempty"`
+
+	mu sync.Mutex
+}
+
+// ManifestEntry 是一个 pod 容器的备份记录
+type ManifestEntry struct {
+	Pod          string          `json:"pod"`
+	Container    string          `json:"container"`
+	DataDir      string          `json:"dataDir"`
+	Image        string          `json:"image,omitempty"`
+	IoTDBVersion string          `json:"iotdbVersion,omitempty"`
+	Key          string          `json:"key,omitempty"`
+	URL          string          `json:"url,omitempty"` // 备份文件在存储中的下载地址
+	LocalFile    string          `json:"localFile,omitempty"`
+	Size         int64           `json:"size"`
+	SHA256       string          `json:"sha256,omitempty"`
+	Compression  string          `json:"compression,omitempty"`
+	Type         string          `json:"type,omitempty"`
+	Parent       string          `json:"parent,omitempty"`
+	ChangedFiles int             `json:"changedFiles,omitempty"`
+	Uploaded     int64           `json:"uploaded,omitempty"`
+	Files        []BackupFile    `json:"files,omitempty"`
+	Encryption   *EncryptionInfo `json:"encryption,omitempty"`
+	Flush        string          `json:"flush"`
+	Attempts     int             `json:"attempts,omitempty"` // 备份数据的尝试次数，大于 1 表示传输中断后重试过
+	StartTime    time.Time       `json:"startTime"`
+	EndTime      time.Time       `json:"endTime"`
+	Status       string          `json:"status"`
+	Error        string          `json:"error,omitempty"`
+}
+
+func newBackupManifest(startTime time.Time, cluster, namespace string) *BackupManifest {
+	return &BackupManifest{
+		Version:     manifestVersion,
+		RunID:       newRunID(startTime),
+		ToolVersion: version,
+		Cluster:     cluster,
+		Namespace:   namespace,
+		StartTime:   startTime,
+	}
+}
+
+// newRunID 生成形如 20240906154128-1a2b3c 的运行 ID，按字典序即按时间排序
+func newRunID(t time.Time) string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return t.Format("20060102150405") + "-" + hex.EncodeToString(suffix)
+}
+
+func (m *BackupManifest) addEntry(entry ManifestEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Entries = append(m.Entries, entry)
+}
+
+// manifestPrefix 返回命名空间下运行清单所在的目录，与各 pod 的备份目录相邻
+func manifestPrefix(cluster, namespace string) string {
+	if cluster == "" {
+		cluster = "default"
+	}
+	return path.Join(cluster, namespace, "manifests") + "/"
+}
+
+// manifestKey 返回运行清单在存储中的 key
+func manifestKey(cluster, namespace, runID string) string {
+	return manifestPrefix(cluster, namespace) + runID + ".json"
+}
+
+func (m *BackupManifest) key() string {
+	return manifestKey(m.Cluster, m.Namespace, m.RunID)
+}
+
+func (m *BackupManifest) marshal() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// saveManifest 将清单写入存储，store 为 nil 时跳过
+func saveManifest(ctx context.Context, store Storage, m *BackupManifest) error {
+	if store == nil {
+		return nil
+	}
+	data, err := m.marshal()
+	if err != nil {
+		return err
+	}
+	return store.Put(ctx, m.key(), bytes.NewReader(data), int64(len(data)), nil)
+}
+
+// saveLocalManifest 将清单写入当前目录，与 keep-local 保存的备份文件放在一起
+func saveLocalManifest(m *BackupManifest) (string, error) {
+	data, err := m.marshal()
+	if err != nil {
+		return "", err
+	}
+	fileName := "manifest-" + m.RunID + ".json"
+	return fileName, os.WriteFile(fileName, data, 0644)
+}
+
+// loadManifest 从存储读取清单
+func loadManifest(ctx context.Context, store Storage, key string) (*BackupManifest, error) {
+	body, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var m BackupManifest
+	if err := json.NewDecoder(body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("解析清单 %s 失败: %v", key, err)
+	}
+	return &m, nil
+}
+
+var versionPattern = regexp.MustCompile(`^v?(\d+(\.\d+)+)`)
+
+// containerImage 返回 pod 中容器使用的镜像，以及从镜像 tag 中解析出的 IoTDB 版本，
+// 如 apache/iotdb:1.3.2-datanode 解析为 1.3.2
+func containerImage(pod v1.Pod, containerName string) (string, string) {
+	for _, c := range pod.Spec.Containers {
+		if c.Name != containerName {
+			continue
+		}
+		image := strings.SplitN(c.Image, "@", 2)[0]
+		tag := ""
+		if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+			tag = image[i+1:]
+		}
+		if match := versionPattern.FindStringSubmatch(tag); match != nil {
+			return c.Image, match[1]
+		}
+		return c.Image, ""
+	}
+	return "", ""
+}
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+var (
+	pushgatewayURL string
+	pushgatewayJob string
+)
+
+// backupMetrics 是备份的监控指标，daemon 模式下通过 /metrics 提供，单次运行结束时推送到 Pushgateway。
+// 多集群备份时每个集群有单独的一组指标。指标中不包含集群和命名空间，/metrics 以常量标签、Pushgateway 以分组标签附加
+type backupMetrics struct {
+	lastSuccessTime  *prometheus.GaugeVec
+	stepDuration     *prometheus.HistogramVec
+	transferredBytes *prometheus.CounterVec
+	failures         *prometheus.CounterVec
+	retries          *prometheus.CounterVec
+	runs             *prometheus.CounterVec
+	lastRunTime      prometheus.Gauge
+}
+
+func newBackupMetrics() *backupMetrics {
+	return &backupMetrics{
+		lastSuccessTime: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "iotdbtools_backup_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful backup of each pod.",
+		}, []string{"pod"}),
+		stepDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "iotdbtools_backup_step_duration_seconds",
+			Help: "Duration of each backup step.",
+			// 1 秒到约 4.5 小时
+			Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+		}, []string{"step", "status"}),
+		transferredBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "iotdbtools_backup_transferred_bytes_total",
+			Help: "Bytes of backup data written to storage or local files, including retried transfers.",
+		}, []string{"pod"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "iotdbtools_backup_failures_total",
+			Help: "Backup failures by reason.",
+		}, []string{"reason"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "iotdbtools_retries_total",
+			Help: "Retries after transient errors by operation.",
+		}, []string{"operation"}),
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "iotdbtools_backup_runs_total",
+			Help: "Backup runs by status (success, partial, failed).",
+		}, []string{"status"}),
+		lastRunTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "iotdbtools_backup_last_run_timestamp_seconds",
+			Help: "Unix time of the end of the last backup run.",
+		}),
+	}
+}
+
+func (m *backupMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.lastSuccessTime, m.stepDuration, m.transferredBytes, m.failures, m.retries, m.runs, m.lastRunTime}
+}
+
+// 步骤和失败原因在指标中使用的标签值
+var stepLabels = map[string]string{
+	"刷新数据": "flush",
+	"去重备份": "dedup",
+	"备份数据": "backup",
+}
+
+// 失败原因，pod 在某个步骤失败时为步骤的标签值
+const (
+	failureKubeClient  = "kube_client"
+	failureListPods    = "list_pods"
+	failurePodNotFound = "pod_not_found"
+	failureConfig      = "config"
+	failureStorage     = "storage"
+	failureHistory     = "history"
+	failureListFiles   = "list_files"
+)
+
+func stepLabel(step string) string {
+	if label, ok := stepLabels[step]; ok {
+		return label
+	}
+	return step
+}
+
+// registry 创建包含备份指标的 Registry，labels 附加到每个指标上
+func (m *backupMetrics) registry(labels prometheus.Labels) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	prometheus.WrapRegistererWith(labels, registry).MustRegister(m.collectors()...)
+	return registry
+}
+
+// metricsCluster 返回指标中的集群名称，与备份目录一致，未指定时为 default
+func metricsCluster(cluster string) string {
+	if cluster == "" {
+		return "default"
+	}
+	return cluster
+}
+
+func (m *backupMetrics) recordFailure(reason string) {
+	m.failures.WithLabelValues(reason).Inc()
+}
+
+func (m *backupMetrics) recordRun(summary *RunSummary) {
+	m.runs.WithLabelValues(summary.Status).Inc()
+	m.lastRunTime.SetToCurrentTime()
+}
+
+// push 将一个集群本次运行的指标推送到 Pushgateway，以 job、cluster、namespace 分组，替换同一分组中上一次推送的指标。
+// 每个 pod 最近一次成功的时间单独以 pod 分组追加，本次失败的 pod 不推送，保留上一次成功的时间
+func (m *backupMetrics) push(ctx context.Context, cluster, namespace string) error {
+	registry := prometheus.NewRegistry()
+	for _, c := range m.collectors() {
+		if c != m.lastSuccessTime {
+			registry.MustRegister(c)
+		}
+	}
+	if err := m.pusher(cluster, namespace).Gatherer(registry).PushContext(ctx); err != nil {
+		return err
+	}
+
+	successes := prometheus.NewRegistry()
+	successes.MustRegister(m.lastSuccessTime)
+	families, err := successes.Gather()
+	if err != nil {
+		return err
+	}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			var pod string
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "pod" {
+					pod = label.GetValue()
+				}
+			}
+			gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: family.GetName(), Help: family.GetHelp()})
+			gauge.Set(metric.GetGauge().GetValue())
+			if err := m.pusher(cluster, namespace).Collector(gauge).Grouping("pod", pod).AddContext(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *backupMetrics) pusher(cluster, namespace string) *push.Pusher {
+	return push.New(pushgatewayURL, pushgatewayJob).
+		Grouping("cluster", metricsCluster(cluster)).
+		Grouping("namespace", namespace)
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPushMetricsKeepsLastSuccessOfFailedPods(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		// 分组标签在路径中的顺序不固定，按标签名排序后记录
+		segments := strings.Split(strings.TrimPrefix(r.URL.Path, "/metrics/job/iotdbtools/"), "/")
+		var labels []string
+		for i := 0; i+1 < len(segments); i += 2 {
+			labels = append(labels, segments[i]+"/"+segments[i+1])
+		}
+		sort.Strings(labels)
+		mu.Lock()
+		requests = append(requests, r.Method+" "+strings.Join(labels, "/")+" "+string(body))
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	savedURL, savedJob := pushgatewayURL, pushgatewayJob
+	defer func() { pushgatewayURL, pushgatewayJob = savedURL, savedJob }()
+	pushgatewayURL, pushgatewayJob = server.URL, "iotdbtools"
+
+	// iotdb-datanode-1 本次失败，没有成功时间
+	metrics := newBackupMetrics()
+	metrics.lastSuccessTime.WithLabelValues("iotdb-datanode-0").Set(1700000000)
+	metrics.recordFailure("backup")
+	if err := metrics.push(context.Background(), "prod", "iotdb"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("预期 2 次推送，实际: %q", requests)
+	}
+	// 替换集群分组的推送中没有成功时间，不会删除失败的 pod 上一次推送的成功时间
+	if !strings.HasPrefix(requests[0], "PUT cluster/prod/namespace/iotdb ") ||
+		strings.Contains(requests[0], "last_success") {
+		t.Fatalf("集群分组的推送: %q", requests[0])
+	}
+	if !strings.HasPrefix(requests[1], "POST cluster/prod/namespace/iotdb/pod/iotdb-datanode-0 ") ||
+		!strings.Contains(requests[1], "iotdbtools_backup_last_success_timestamp_seconds") {
+		t.Fatalf("pod 分组的推送: %q", requests[1])
+	}
+}
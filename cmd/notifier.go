@@ -0,0 +1,322 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// 通知的类型，渠道通过 events 选择接收哪些类型
+const (
+	notifySuccess    = "success"     // 一次备份运行全部成功的汇总
+	notifyFailure    = "failure"     // 一次备份运行有 pod 失败或无法开始备份的汇总
+	notifyPodFailure = "pod-failure" // 单个 pod 备份失败，只发送给在 events 中选择了它的渠道
+	notifySummary    = "summary"     // 多集群备份的汇总
+)
+
+var notifyKinds = []string{notifySuccess, notifyFailure, notifyPodFailure, notifySummary}
+
+// Notification 是一条通知，各渠道按自己的模板展示，默认模板展示标题和按顺序排列的字段
+type Notification struct {
+	Kind   string              `json:"kind"`
+	Title  string              `json:"title"`
+	Fields []NotificationField `json:"fields"`
+	Time   time.Time           `json:"time"`
+	// Runs 是通知涉及的备份运行，单集群备份只有一个，多集群备份每个集群一个
+	Runs []*RunSummary `json:"runs,omitempty"`
+}
+
+// NotificationField 是通知中的一个字段
+type NotificationField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func newNotification(kind, title string, fields ...NotificationField) *Notification {
+	return &Notification{Kind: kind, Title: title, Fields: fields, Time: time.Now()}
+}
+
+func field(name string, value interface{}) NotificationField {
+	return NotificationField{Name: name, Value: fmt.Sprint(value)}
+}
+
+// Notifier 将通知发送到一个渠道
+type Notifier interface {
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// NotifierConfig 是配置文件中 notifiers 下的一个通知渠道：
+//
+//	notifiers:
+//	  - type: wecom
+//	    url: https://qyapi.weixin.qq.com/cgi-bin/webhook/send?key=...
+//	    events: [failure, pod-failure, summary]
+//	    templates:
+//	      failure: "{{.Title}}：{{range .Runs}}{{.Failed}} 个 pod 失败{{end}}"
+//	  - type: email
+//	    smtp: smtp.example.com:465
+//	    username: backup@example.com
+//	    password: ...
+//	    to: [ops@example.com]
+type NotifierConfig struct {
+	// Name 用于日志，默认为 Type
+	Name string `json:"name,omitempty"`
+	// Type 是渠道类型：wecom、dingtalk、feishu、slack、webhook、email
+	Type string `json:"type"`
+	// URL 是机器人或 webhook 的地址
+	URL string `json:"url,omitempty"`
+	// Secret 是钉钉、飞书机器人的签名密钥
+	Secret string `json:"secret,omitempty"`
+	// Headers 是 webhook 请求额外的 HTTP 头
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// SMTP 是邮件服务器地址 host:port，端口为 465 时使用 TLS，其他端口在服务器支持时使用 STARTTLS
+	SMTP     string   `json:"smtp,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+
+	// Events 是渠道接收的通知类型：success、failure、pod-failure、summary，为空时接收除 pod-failure 外的全部
+	Events []string `json:"events,omitempty"`
+
+	// Templates 按通知类型覆盖渠道的默认模板（Go text/template），键为通知类型或 default
+	Templates map[string]string `json:"templates,omitempty"`
+	// TemplateFiles 与 Templates 相同，模板从文件读取
+	TemplateFiles map[string]string `json:"template-files,omitempty"`
+}
+
+func (c *NotifierConfig) name() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Type
+}
+
+func (c *NotifierConfig) accepts(kind string) bool {
+	if len(c.Events) == 0 {
+		return kind != notifyPodFailure
+	}
+	for _, event := range c.Events {
+		if event == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// newNotifier 根据渠道配置创建 Notifier，检查必填的配置项并解析模板
+func newNotifier(c *NotifierConfig) (Notifier, error) {
+	for _, event := range c.Events {
+		if !isNotifyKind(event) {
+			return nil, fmt.Errorf("通知渠道 %s 中未知的 event %s，可选 %s", c.name(), event, strings.Join(notifyKinds, "、"))
+		}
+	}
+	if c.Type == "email" {
+		if c.SMTP == "" || len(c.To) == 0 {
+			return nil, fmt.Errorf("通知渠道 %s 需要 smtp 和 to", c.name())
+		}
+		tmpl, err := c.templates(plainTemplate)
+		if err != nil {
+			return nil, err
+		}
+		return &emailNotifier{config: c, tmpl: tmpl}, nil
+	}
+
+	if c.URL == "" {
+		return nil, fmt.Errorf("通知渠道 %s 需要 url", c.name())
+	}
+	if _, err := url.Parse(c.URL); err != nil {
+		return nil, fmt.Errorf("通知渠道 %s 的 url 无效: %v", c.name(), err)
+	}
+	defaults := map[string]string{
+		"wecom":    markdownTemplate,
+		"dingtalk": dingtalkTemplate,
+		"feishu":   plainTemplate,
+		"lark":     plainTemplate,
+		"slack":    slackTemplate,
+		"webhook":  "",
+	}
+	text, ok := defaults[c.Type]
+	if !ok {
+		return nil, fmt.Errorf("通知渠道 %s 的类型 %s 不支持，可选 wecom、dingtalk、feishu、slack、webhook、email", c.name(), c.Type)
+	}
+	tmpl, err := c.templates(text)
+	if err != nil {
+		return nil, err
+	}
+	switch c.Type {
+	case "wecom":
+		return &wecomNotifier{url: c.URL, tmpl: tmpl}, nil
+	case "dingtalk":
+		return &dingtalkNotifier{url: c.URL, secret: c.Secret, tmpl: tmpl}, nil
+	case "feishu", "lark":
+		return &feishuNotifier{url: c.URL, secret: c.Secret, tmpl: tmpl}, nil
+	case "slack":
+		return &slackNotifier{url: c.URL, tmpl: tmpl}, nil
+	default:
+		return &webhookNotifier{url: c.URL, headers: c.Headers, tmpl: tmpl}, nil
+	}
+}
+
+func isNotifyKind(kind string) bool {
+	for _, k := range notifyKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNotifiers 解析配置文件中的 notifiers 列表
+func parseNotifiers(raw interface{}) ([]*NotifierConfig, error) {
+	data, err := yaml.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var configs []*NotifierConfig
+	if err := yaml.UnmarshalStrict(data, &configs); err != nil {
+		return nil, fmt.Errorf("解析 notifiers 失败: %v", err)
+	}
+	for _, c := range configs {
+		if _, err := newNotifier(c); err != nil {
+			return nil, err
+		}
+	}
+	return configs, nil
+}
+
+// sendNotification 将通知发送到所有接收 n.Kind 的渠道，一个渠道失败不影响其他渠道，
+// 没有配置渠道或 --notify=false 时不发送
+func sendNotification(ctx context.Context, n *Notification) error {
+	if !notify || loadedConfig == nil {
+		return nil
+	}
+	var errs []error
+	for _, c := range loadedConfig.notifiers {
+		if !c.accepts(n.Kind) {
+			continue
+		}
+		notifier, err := newNotifier(c)
+		if err == nil {
+			err = notifier.Notify(ctx, n)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.name(), err))
+			continue
+		}
+		log(2, "已发送通知到 %s: %s", c.name(), n.Title)
+	}
+	return errors.Join(errs...)
+}
+
+// 各渠道的默认模板，展示标题和按顺序排列的字段
+const (
+	// markdownTemplate 以引用块逐行展示字段，用于企业微信
+	markdownTemplate = `{{.Title}}{{range .Fields}}
+> **{{.Name}}**：{{.Value}}{{end}}`
+	dingtalkTemplate = `### {{.Title}}
+{{range .Fields}}
+- **{{.Name}}**：{{.Value}}{{end}}`
+	slackTemplate = `*{{.Title}}*{{range .Fields}}
+*{{.Name}}*: {{.Value}}{{end}}`
+	// plainTemplate 用于邮件和不支持 markdown 的渠道
+	plainTemplate = `{{.Title}}{{range .Fields}}
+{{.Name}}：{{.Value}}{{end}}`
+)
+
+// templateFuncs 是通知模板中可用的函数
+var templateFuncs = template.FuncMap{
+	"size":     formatSize,
+	"duration": formatSeconds,
+	"join":     strings.Join,
+	// json 将值编码为 JSON，用于在 webhook 模板中拼接请求体
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// notifyTemplate 按通知类型选择渠道的模板，没有配置模板的类型使用 fallback
+type notifyTemplate struct {
+	kinds    map[string]*template.Template
+	fallback *template.Template
+}
+
+// templates 解析渠道配置的模板，键 default 覆盖渠道的默认模板 defaultText，
+// defaultText 为空时（webhook）没有配置模板的通知不经过模板
+func (c *NotifierConfig) templates(defaultText string) (*notifyTemplate, error) {
+	texts := map[string]string{}
+	for kind, file := range c.TemplateFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("通知渠道 %s 读取模板文件失败: %v", c.name(), err)
+		}
+		texts[kind] = string(data)
+	}
+	for kind, text := range c.Templates {
+		if _, ok := texts[kind]; ok {
+			return nil, fmt.Errorf("通知渠道 %s 的 %s 模板同时在 templates 和 template-files 中配置", c.name(), kind)
+		}
+		texts[kind] = text
+	}
+
+	t := &notifyTemplate{kinds: map[string]*template.Template{}}
+	if defaultText != "" {
+		t.fallback = template.Must(template.New(c.Type).Funcs(templateFuncs).Parse(defaultText))
+	}
+	for kind, text := range texts {
+		if kind != "default" && !isNotifyKind(kind) {
+			return nil, fmt.Errorf("通知渠道 %s 中未知的模板类型 %s，可选 default、%s", c.name(), kind, strings.Join(notifyKinds, "、"))
+		}
+		tmpl, err := template.New(kind).Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("通知渠道 %s 的 %s 模板无效: %v", c.name(), kind, err)
+		}
+		// 用示例通知渲染一次，提前发现模板中引用了不存在的字段
+		if err := tmpl.Execute(io.Discard, sampleNotification(kind)); err != nil {
+			return nil, fmt.Errorf("通知渠道 %s 的 %s 模板无效: %v", c.name(), kind, err)
+		}
+		if kind == "default" {
+			t.fallback = tmpl
+		} else {
+			t.kinds[kind] = tmpl
+		}
+	}
+	return t, nil
+}
+
+// render 用通知类型对应的模板渲染通知，没有可用的模板时返回 false
+func (t *notifyTemplate) render(n *Notification) (string, bool, error) {
+	tmpl := t.kinds[n.Kind]
+	if tmpl == nil {
+		tmpl = t.fallback
+	}
+	if tmpl == nil {
+		return "", false, nil
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, n); err != nil {
+		return "", false, fmt.Errorf("渲染通知模板失败: %v", err)
+	}
+	return strings.TrimSpace(b.String()), true, nil
+}
+
+func sampleNotification(kind string) *Notification {
+	return &Notification{
+		Kind:   kind,
+		Title:  "示例通知",
+		Fields: []NotificationField{field("集群", "example")},
+		Time:   time.Now(),
+		Runs:   []*RunSummary{{Pods: []PodResult{{}}}},
+	}
+}
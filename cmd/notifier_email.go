@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// emailNotifier 通过 SMTP 发送纯文本邮件
+type emailNotifier struct {
+	config *NotifierConfig
+	tmpl   *notifyTemplate
+}
+
+func (e *emailNotifier) Notify(ctx context.Context, n *Notification) error {
+	text, _, err := e.tmpl.render(n)
+	if err != nil {
+		return err
+	}
+	return retry(ctx, "发送通知", func() error {
+		return e.send(ctx, n, text)
+	})
+}
+
+func (e *emailNotifier) send(ctx context.Context, n *Notification, text string) error {
+	c := e.config
+	host, port, err := net.SplitHostPort(c.SMTP)
+	if err != nil {
+		return fmt.Errorf("smtp 地址 %s 无效，格式为 host:port: %v", c.SMTP, err)
+	}
+	from := c.From
+	if from == "" {
+		from = c.Username
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	var conn net.Conn
+	if port == "465" {
+		conn, err = (&tls.Dialer{Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", c.SMTP)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", c.SMTP)
+	}
+	if err != nil {
+		return fmt.Errorf("连接 SMTP 服务器失败: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("连接 SMTP 服务器失败: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && port != "465" {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return fmt.Errorf("STARTTLS 失败: %w", err)
+		}
+	}
+	if c.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.Username, c.Password, host)); err != nil {
+			return fmt.Errorf("SMTP 认证失败: %w", err)
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, to := range c.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(emailMessage(from, c.To, n, text)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// emailMessage 生成邮件，主题为通知标题，正文为模板渲染的 text
+func emailMessage(from string, to []string, n *Notification, text string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", n.Title))
+	fmt.Fprintf(&b, "Date: %s\r\n", n.Time.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(text, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// webhookServer 记录收到的请求，以 status 和 response 响应
+type webhookServer struct {
+	*httptest.Server
+	requests []*http.Request
+	bodies   []map[string]interface{}
+}
+
+func newWebhookServer(t *testing.T, status int, response string) *webhookServer {
+	s := &webhookServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var body map[string]interface{}
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("请求体不是 JSON 对象: %s", data)
+		}
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, body)
+		w.WriteHeader(status)
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// testNotify 用渠道配置发送一条失败通知，重试只尝试一次
+func testNotify(t *testing.T, c *NotifierConfig) error {
+	t.Helper()
+	savedRetry := retryPolicy
+	t.Cleanup(func() { retryPolicy = savedRetry })
+	retryPolicy.MaxAttempts = 1
+
+	notifier, err := newNotifier(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := newNotification(notifyFailure, "备份失败", field("集群", "prod"), field("失败", 1))
+	return notifier.Notify(context.Background(), n)
+}
+
+// lookup 按路径读取 JSON 对象中的字段
+func lookup(body map[string]interface{}, keys ...string) interface{} {
+	var v interface{} = body
+	for _, key := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+func TestWecomNotifier(t *testing.T) {
+	server := newWebhookServer(t, http.StatusOK, `{"errcode":0,"errmsg":"ok"}`)
+	if err := testNotify(t, &NotifierConfig{Type: "wecom", URL: server.URL + "/cgi-bin/webhook/send?key=abc"}); err != nil {
+		t.Fatal(err)
+	}
+	body := server.bodies[0]
+	if body["msgtype"] != "markdown" {
+		t.Fatalf("msgtype: %v", body["msgtype"])
+	}
+	if content, _ := lookup(body, "markdown", "content").(string); content != "备份失败\n> **集群**：prod\n> **失败**：1" {
+		t.Fatalf("消息内容: %q", content)
+	}
+	if key := server.requests[0].URL.Query().Get("key"); key != "abc" {
+		t.Fatalf("key: %s", key)
+	}
+
+	server = newWebhookServer(t, http.StatusOK, `{"errcode":93000,"errmsg":"invalid webhook url"}`)
+	if err := testNotify(t, &NotifierConfig{Type: "wecom", URL: server.URL}); err == nil || !strings.Contains(err.Error(), "93000") {
+		t.Fatalf("预期 errcode 错误，实际: %v", err)
+	}
+}
+
+func TestDingtalkNotifier(t *testing.T) {
+	server := newWebhookServer(t, http.StatusOK, `{"errcode":0,"errmsg":"ok"}`)
+	if err := testNotify(t, &NotifierConfig{Type: "dingtalk", URL: server.URL + "/robot/send?access_token=abc", Secret: "SEC123"}); err != nil {
+		t.Fatal(err)
+	}
+	body := server.bodies[0]
+	if body["msgtype"] != "markdown" || lookup(body, "markdown", "title") != "备份失败" {
+		t.Fatalf("请求体: %v", body)
+	}
+	if text, _ := lookup(body, "markdown", "text").(string); !strings.Contains(text, "- **集群**：prod") {
+		t.Fatalf("消息内容: %q", text)
+	}
+	// 设置了 secret 时在查询参数中加签，保留原有的 access_token
+	query := server.requests[0].URL.Query()
+	if query.Get("access_token") != "abc" || query.Get("timestamp") == "" || query.Get("sign") == "" {
+		t.Fatalf("查询参数: %v", query)
+	}
+
+	server = newWebhookServer(t, http.StatusOK, `{"errcode":310000,"errmsg":"sign not match"}`)
+	if err := testNotify(t, &NotifierConfig{Type: "dingtalk", URL: server.URL}); err == nil || !strings.Contains(err.Error(), "310000") {
+		t.Fatalf("预期 errcode 错误，实际: %v", err)
+	}
+}
+
+func TestFeishuNotifier(t *testing.T) {
+	server := newWebhookServer(t, http.StatusOK, `{"code":0,"msg":"success"}`)
+	if err := testNotify(t, &NotifierConfig{Type: "feishu", URL: server.URL + "/open-apis/bot/v2/hook/abc", Secret: "SEC123"}); err != nil {
+		t.Fatal(err)
+	}
+	body := server.bodies[0]
+	if body["msg_type"] != "post" || lookup(body, "content", "post", "zh_cn", "title") != "备份失败" {
+		t.Fatalf("请求体: %v", body)
+	}
+	if lines, _ := lookup(body, "content", "post", "zh_cn", "content").([]interface{}); len(lines) != 2 {
+		t.Fatalf("段落: %v", lines)
+	}
+	if body["timestamp"] == nil || body["sign"] == nil {
+		t.Fatalf("设置了 secret 时请求体中应有签名: %v", body)
+	}
+
+	server = newWebhookServer(t, http.StatusOK, `{"code":19021,"msg":"sign match fail"}`)
+	if err := testNotify(t, &NotifierConfig{Type: "feishu", URL: server.URL}); err == nil || !strings.Contains(err.Error(), "19021") {
+		t.Fatalf("预期 code 错误，实际: %v", err)
+	}
+}
+
+func TestSlackNotifier(t *testing.T) {
+	server := newWebhookServer(t, http.StatusOK, "ok")
+	if err := testNotify(t, &NotifierConfig{Type: "slack", URL: server.URL + "/services/T000/B000/abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if text := server.bodies[0]["text"]; text != "*备份失败*\n*集群*: prod\n*失败*: 1" {
+		t.Fatalf("消息内容: %q", text)
+	}
+
+	server = newWebhookServer(t, http.StatusNotFound, "no_team")
+	err := testNotify(t, &NotifierConfig{Type: "slack", URL: server.URL})
+	if statusErr := (*httpStatusError)(nil); err == nil || !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("预期 HTTP 404，实际: %v", err)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	server := newWebhookServer(t, http.StatusOK, "")
+	if err := testNotify(t, &NotifierConfig{Type: "webhook", URL: server.URL, Headers: map[string]string{"Authorization": "Bearer abc"}}); err != nil {
+		t.Fatal(err)
+	}
+	// 没有模板时 POST 完整的 Notification
+	body := server.bodies[0]
+	if body["kind"] != notifyFailure || body["title"] != "备份失败" {
+		t.Fatalf("请求体: %v", body)
+	}
+	if fields, _ := body["fields"].([]interface{}); len(fields) != 2 {
+		t.Fatalf("字段: %v", body["fields"])
+	}
+	if auth := server.requests[0].Header.Get("Authorization"); auth != "Bearer abc" {
+		t.Fatalf("Authorization: %s", auth)
+	}
+
+	// 配置了模板时 POST 渲染结果
+	server = newWebhookServer(t, http.StatusOK, "")
+	c := &NotifierConfig{Type: "webhook", URL: server.URL, Templates: map[string]string{"default": `{"msg": {{json .Title}}}`}}
+	if err := testNotify(t, c); err != nil {
+		t.Fatal(err)
+	}
+	if msg := server.bodies[0]["msg"]; msg != "备份失败" {
+		t.Fatalf("模板渲染结果: %v", server.bodies[0])
+	}
+
+	server = newWebhookServer(t, http.StatusInternalServerError, "")
+	err := testNotify(t, &NotifierConfig{Type: "webhook", URL: server.URL})
+	if statusErr := (*httpStatusError)(nil); err == nil || !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("预期 HTTP 500，实际: %v", err)
+	}
+	if len(server.requests) != 1 {
+		t.Fatalf("重试次数为 1 时发送了 %d 次", len(server.requests))
+	}
+}
@@ -0,0 +1,219 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// wecomNotifier 通过企业微信群机器人发送 markdown 消息
+type wecomNotifier struct {
+	url  string
+	tmpl *notifyTemplate
+}
+
+func (w *wecomNotifier) Notify(ctx context.Context, n *Notification) error {
+	text, _, err := w.tmpl.render(n)
+	if err != nil {
+		return err
+	}
+	payload := map[string]interface{}{
+		"msgtype":  "markdown",
+		"markdown": map[string]string{"content": text},
+	}
+	body, err := postWebhook(ctx, w.url, payload, nil)
+	if err != nil {
+		return err
+	}
+	return checkErrcode(body)
+}
+
+// dingtalkNotifier 通过钉钉群机器人发送 markdown 消息，设置了 secret 时对请求加签
+type dingtalkNotifier struct {
+	url    string
+	secret string
+	tmpl   *notifyTemplate
+}
+
+func (d *dingtalkNotifier) Notify(ctx context.Context, n *Notification) error {
+	text, _, err := d.tmpl.render(n)
+	if err != nil {
+		return err
+	}
+	payload := map[string]interface{}{
+		"msgtype":  "markdown",
+		"markdown": map[string]string{"title": n.Title, "text": text},
+	}
+
+	webhookURL := d.url
+	if d.secret != "" {
+		// 签名为 HmacSHA256(secret, timestamp+"\n"+secret) 的 Base64，timestamp 为毫秒
+		timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		mac := hmac.New(sha256.New, []byte(d.secret))
+		mac.Write([]byte(timestamp + "\n" + d.secret))
+		u, err := url.Parse(d.url)
+		if err != nil {
+			return err
+		}
+		query := u.Query()
+		query.Set("timestamp", timestamp)
+		query.Set("sign", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+		u.RawQuery = query.Encode()
+		webhookURL = u.String()
+	}
+	body, err := postWebhook(ctx, webhookURL, payload, nil)
+	if err != nil {
+		return err
+	}
+	return checkErrcode(body)
+}
+
+// checkErrcode 检查企业微信、钉钉接口返回的 errcode，接口出错时 HTTP 状态码仍为 200
+func checkErrcode(body []byte) error {
+	var response struct {
+		Errcode int    `json:"errcode"`
+		Errmsg  string `json:"errmsg"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("无法解析响应 %s: %v", strings.TrimSpace(string(body)), err)
+	}
+	if response.Errcode != 0 {
+		return fmt.Errorf("errcode %d: %s", response.Errcode, response.Errmsg)
+	}
+	return nil
+}
+
+// feishuNotifier 通过飞书/Lark 群机器人发送富文本消息，模板渲染结果的第一行为标题，
+// 其余每行为一个段落，设置了 secret 时对请求签名
+type feishuNotifier struct {
+	url    string
+	secret string
+	tmpl   *notifyTemplate
+}
+
+func (f *feishuNotifier) Notify(ctx context.Context, n *Notification) error {
+	text, _, err := f.tmpl.render(n)
+	if err != nil {
+		return err
+	}
+	title, rest, _ := strings.Cut(text, "\n")
+	lines := [][]map[string]string{}
+	for _, line := range strings.Split(rest, "\n") {
+		if line != "" {
+			lines = append(lines, []map[string]string{{"tag": "text", "text": line}})
+		}
+	}
+	payload := map[string]interface{}{
+		"msg_type": "post",
+		"content": map[string]interface{}{
+			"post": map[string]interface{}{
+				"zh_cn": map[string]interface{}{"title": title, "content": lines},
+			},
+		},
+	}
+	if f.secret != "" {
+		// 签名以 timestamp+"\n"+secret 为密钥对空字符串做 HmacSHA256，timestamp 为秒
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(timestamp+"\n"+f.secret))
+		payload["timestamp"] = timestamp
+		payload["sign"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	body, err := postWebhook(ctx, f.url, payload, nil)
+	if err != nil {
+		return err
+	}
+	var response struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("无法解析响应 %s: %v", strings.TrimSpace(string(body)), err)
+	}
+	if response.Code != 0 {
+		return fmt.Errorf("code %d: %s", response.Code, response.Msg)
+	}
+	return nil
+}
+
+// slackNotifier 通过 Slack Incoming Webhook 发送消息
+type slackNotifier struct {
+	url  string
+	tmpl *notifyTemplate
+}
+
+func (s *slackNotifier) Notify(ctx context.Context, n *Notification) error {
+	text, _, err := s.tmpl.render(n)
+	if err != nil {
+		return err
+	}
+	_, err = postWebhook(ctx, s.url, map[string]string{"text": text}, nil)
+	return err
+}
+
+// webhookNotifier 将 Notification 以 JSON POST 到任意地址，由接收方自行处理。
+// 配置了模板时 POST 模板的渲染结果，用于对接需要特定请求格式的接口
+type webhookNotifier struct {
+	url     string
+	headers map[string]string
+	tmpl    *notifyTemplate
+}
+
+func (w *webhookNotifier) Notify(ctx context.Context, n *Notification) error {
+	text, ok, err := w.tmpl.render(n)
+	if err != nil {
+		return err
+	}
+	var payload interface{} = n
+	if ok {
+		if !json.Valid([]byte(text)) {
+			return fmt.Errorf("模板的渲染结果不是有效的 JSON: %s", text)
+		}
+		payload = json.RawMessage(text)
+	}
+	_, err = postWebhook(ctx, w.url, payload, w.headers)
+	return err
+}
+
+// postWebhook 将 payload 以 JSON POST 到 webhookURL，返回响应内容，网络错误和 5xx 按重试策略重试
+func postWebhook(ctx context.Context, webhookURL string, payload interface{}, headers map[string]string) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("JSON 编码失败: %v", err)
+	}
+	var body []byte
+	err = retry(ctx, "发送通知", func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("发送通知失败: %w", err)
+		}
+		defer resp.Body.Close()
+
+		body, err = io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil {
+			return fmt.Errorf("读取通知响应失败: %w", err)
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("通知发送失败: %w", &httpStatusError{StatusCode: resp.StatusCode})
+		}
+		return nil
+	})
+	return body, err
+}
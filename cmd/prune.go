@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	retention   RetentionPolicy
+	pruneDryRun bool
+	gcGrace     time.Duration
+)
+
+func init() {
+	pruneCmd.Flags().IntVar(&retention.KeepLast, "keep-last", 0, "每个 pod 保留最近的 N 个备份")
+	pruneCmd.Flags().IntVar(&retention.KeepDaily, "keep-daily", 0, "每个 pod 在最近 N 天内每天保留 1 个备份")
+	pruneCmd.Flags().IntVar(&retention.KeepWeekly, "keep-weekly", 0, "每个 pod 在最近 N 周内每周保留 1 个备份")
+	pruneCmd.Flags().IntVar(&retention.KeepMonthly, "keep-monthly", 0, "每个 pod 在最近 N 个月内每月保留 1 个备份")
+	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "只输出要删除的备份，不实际删除")
+	pruneCmd.Flags().DurationVar(&gcGrace, "gc-grace", 24*time.Hour, "去重备份中未被引用的 blob 超过这个时间才删除，避免删除正在进行的备份刚上传的 blob")
+	pruneCmd.Flags().BoolVar(&listLegacy, "legacy", false, "同时清理旧版本上传到 bucket 根目录下的备份（需要遍历整个 bucket）")
+	pruneCmd.Flags().StringSliceVar(&pods, "pods", []string{}, "只清理指定 pod 的备份，多个 pod 用逗号分隔")
+	addScopeFlags(pruneCmd)
+	addStorageFlags(pruneCmd)
+	rootCmd.AddCommand(pruneCmd)
+}
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "delete backups according to a retention policy",
+	Long: `按保留策略删除存储中过期的备份，同时删除对应的校验和文件，以及所有备份都已删除的运行清单。
+各条规则保留的备份取并集，pod 仅剩的备份不会被删除。删除后清理命名空间下不再被任何快照引用的去重 blob。`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		if retention.empty() {
+			fmt.Println("错误：至少需要指定 --keep-last、--keep-daily、--keep-weekly、--keep-monthly 中的一个")
+			os.Exit(1)
+		}
+
+		store, err := openStorage(ctx)
+		if err != nil {
+			fmt.Printf("打开存储失败: %v\n", err)
+			os.Exit(1)
+		}
+
+		backups, err := listBackups(ctx, store, clusterName, namespace, pods, listLegacy)
+		if err != nil {
+			fmt.Printf("列出备份失败: %v\n", err)
+			os.Exit(1)
+		}
+
+		log(1, "保留策略: %s", retention)
+		decisions := applyRetention(backups, retention, time.Now())
+		printRetention(decisions)
+
+		deleted := map[string]bool{}
+		if pruneDryRun {
+			for _, d := range decisions {
+				if !d.Keep {
+					deleted[d.Backup.Key] = true
+				}
+			}
+		} else if deleted, err = prune(ctx, store, decisions); err != nil {
+			fmt.Printf("清理备份失败: %v\n", err)
+			os.Exit(1)
+		}
+
+		count, freed, err := gcBlobs(ctx, store, clusterName, namespace, deleted, gcGrace, pruneDryRun)
+		if err != nil {
+			fmt.Printf("清理 blob 失败: %v\n", err)
+			os.Exit(1)
+		}
+		if pruneDryRun {
+			fmt.Printf("dry-run 模式，未删除任何备份，将删除 %d 个未被引用的 blob（%s）\n", count, formatSize(freed))
+		} else if count > 0 {
+			fmt.Printf("已删除 %d 个未被引用的 blob，释放 %s\n", count, formatSize(freed))
+		}
+	},
+}
+
+func printRetention(decisions []retentionDecision) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tPOD\tTIME\tSIZE\tREASON\tKEY")
+	for _, d := range decisions {
+		action := "delete"
+		if d.Keep {
+			action = "keep"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", action, d.Backup.Pod,
+			d.Backup.Time.Local().Format("2006-01-02 15:04:05"), formatSize(d.Backup.Size), strings.Join(d.Reasons, ", "), d.Backup.Key)
+	}
+	w.Flush()
+}
+
+// prune 删除决定不保留的备份及其校验和文件，再删除所有备份都已删除的运行清单，返回已删除的备份
+func prune(ctx context.Context, store Storage, decisions []retentionDecision) (map[string]bool, error) {
+	deleted := map[string]bool{}
+	keptRuns := map[string]bool{}
+	var freed int64
+	failed := 0
+	for _, d := range decisions {
+		if d.Keep {
+			keptRuns[d.Backup.RunID] = true
+			continue
+		}
+		if err := store.Delete(ctx, d.Backup.Key); err != nil {
+			log(0, "删除备份 %s 失败: %v", d.Backup.Key, err)
+			failed++
+			keptRuns[d.Backup.RunID] = true
+			continue
+		}
+		if err := store.Delete(ctx, checksumKey(d.Backup.Key)); err != nil {
+			log(1, "删除校验和文件 %s 失败: %v", checksumKey(d.Backup.Key), err)
+		}
+		deleted[d.Backup.Key] = true
+		freed += d.Backup.Size
+		log(1, "已删除备份 %s", d.Backup.Key)
+	}
+
+	for _, d := range decisions {
+		runID := d.Backup.RunID
+		if runID == "" || keptRuns[runID] {
+			continue
+		}
+		keptRuns[runID] = true
+		if err := pruneManifest(ctx, store, manifestKey(d.Backup.Cluster, d.Backup.Namespace, runID), deleted); err != nil {
+			log(0, "删除清单 %s 失败: %v", runID, err)
+		}
+	}
+
+	fmt.Printf("已删除 %d 个备份，释放 %s\n", len(deleted), formatSize(freed))
+	if failed > 0 {
+		return deleted, fmt.Errorf("%d 个备份删除失败", failed)
+	}
+	return deleted, nil
+}
+
+// pruneManifest 在清单中记录的所有备份文件都已删除时删除清单，清单中仍有其他 pod 的备份时保留
+func pruneManifest(ctx context.Context, store Storage, key string, deleted map[string]bool) error {
+	m, err := loadManifest(ctx, store, key)
+	if err != nil {
+		return err
+	}
+	for _, entry := range m.Entries {
+		if entry.Status == backupStatusSuccess && entry.Key != "" && !deleted[entry.Key] {
+			return nil
+		}
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		return err
+	}
+	log(1, "已删除清单 %s", key)
+	return nil
+}
@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingDeleteStorage 删除 key 时返回错误，其他操作交给 Storage
+type failingDeleteStorage struct {
+	Storage
+	key string
+}
+
+func (s failingDeleteStorage) Delete(ctx context.Context, key string) error {
+	if key == s.key {
+		return errors.New("access denied")
+	}
+	return s.Storage.Delete(ctx, key)
+}
+
+// setupPruneTest 在临时目录中保存两次运行的备份、校验和与清单：
+// run1 中有 a 和失败的 b，run2 中有 c 和 d
+func setupPruneTest(t *testing.T) (*fileStorage, []BackupRecord) {
+	t.Helper()
+	ctx := context.Background()
+	store, err := newFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	runs := map[string][]ManifestEntry{
+		"run1": {
+			{Pod: "iotdb-datanode-0", Key: "prod/iotdb/iotdb-datanode-0/a.tar.gz", Status: backupStatusSuccess},
+			{Pod: "iotdb-datanode-1", Key: "prod/iotdb/iotdb-datanode-1/b.tar.gz", Status: backupStatusFailed},
+		},
+		"run2": {
+			{Pod: "iotdb-datanode-0", Key: "prod/iotdb/iotdb-datanode-0/c.tar.gz", Status: backupStatusSuccess},
+			{Pod: "iotdb-datanode-1", Key: "prod/iotdb/iotdb-datanode-1/d.tar.gz", Status: backupStatusSuccess},
+		},
+	}
+	var backups []BackupRecord
+	for runID, entries := range runs {
+		for _, entry := range entries {
+			if err := store.Put(ctx, entry.Key, strings.NewReader(entry.Key), -1, nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := recordChecksum(ctx, store, entry.Key, testSum(entry.Key)); err != nil {
+				t.Fatal(err)
+			}
+			backups = append(backups, BackupRecord{Key: entry.Key, Pod: entry.Pod, Cluster: "prod", Namespace: "iotdb",
+				RunID: runID, Size: int64(len(entry.Key)), Time: now, Status: entry.Status})
+		}
+		m := &BackupManifest{Version: manifestVersion, RunID: runID, Cluster: "prod", Namespace: "iotdb", StartTime: now, Entries: entries}
+		if err := saveManifest(ctx, store, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store, backups
+}
+
+// decide 返回只保留 keep 中备份的删除决定
+func decide(backups []BackupRecord, keep ...string) []retentionDecision {
+	var decisions []retentionDecision
+	for _, b := range backups {
+		d := retentionDecision{Backup: b}
+		for _, key := range keep {
+			if strings.HasSuffix(b.Key, key) {
+				d.Keep = true
+			}
+		}
+		decisions = append(decisions, d)
+	}
+	return decisions
+}
+
+func exists(store Storage, key string) bool {
+	_, err := store.Stat(context.Background(), key)
+	return err == nil
+}
+
+func TestPrune(t *testing.T) {
+	store, backups := setupPruneTest(t)
+	deleted, err := prune(context.Background(), store, decide(backups, "/c.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 3 {
+		t.Fatalf("删除了 %d 个备份，预期 3 个: %v", len(deleted), deleted)
+	}
+
+	// 备份和校验和文件一起删除
+	for _, b := range backups {
+		kept := strings.HasSuffix(b.Key, "/c.tar.gz")
+		if exists(store, b.Key) != kept || exists(store, checksumKey(b.Key)) != kept {
+			t.Errorf("%s: 备份或校验和文件的状态与预期（保留: %v）不一致", b.Key, kept)
+		}
+	}
+	// run1 中只剩失败的记录，清单被删除；run2 中仍有 c，清单保留
+	if exists(store, manifestKey("prod", "iotdb", "run1")) {
+		t.Error("run1 的清单没有删除")
+	}
+	if !exists(store, manifestKey("prod", "iotdb", "run2")) {
+		t.Error("run2 的清单被删除")
+	}
+}
+
+func TestPruneDeleteFailure(t *testing.T) {
+	fs, backups := setupPruneTest(t)
+	store := failingDeleteStorage{Storage: fs, key: "prod/iotdb/iotdb-datanode-0/a.tar.gz"}
+	deleted, err := prune(context.Background(), store, decide(backups))
+	if err == nil || !strings.Contains(err.Error(), "1 个备份删除失败") {
+		t.Fatalf("预期删除失败的错误，实际: %v", err)
+	}
+	if deleted[store.key] || len(deleted) != 3 {
+		t.Fatalf("已删除的备份: %v", deleted)
+	}
+	// 删除失败的备份仍在 run1 中，清单保留，run2 的清单删除
+	if !exists(store, manifestKey("prod", "iotdb", "run1")) || !exists(store, checksumKey(store.key)) {
+		t.Error("删除失败的备份的清单或校验和被删除")
+	}
+	if exists(store, manifestKey("prod", "iotdb", "run2")) {
+		t.Error("run2 的清单没有删除")
+	}
+}
+
+func TestGcBlobs(t *testing.T) {
+	store, pod := setupBackupTest(t)
+	dedup = true
+	executor := newFakeExecutor()
+	p := newFakePod(executor)
+	p.write(testDataDir+"/a.tsfile", "a", time.Now().UTC())
+	first := runTestBackup(t, executor, store, pod, nil)
+	p.remove(testDataDir + "/a.tsfile")
+	p.write(testDataDir+"/b.tsfile", "b", time.Now().UTC())
+	// 同一秒内的备份使用不同的文件名，避免覆盖第一个快照
+	outName = "second"
+	second := runTestBackup(t, executor, store, pod, nil)
+
+	ctx := context.Background()
+	deleted := map[string]bool{first.Key: true}
+	// 在 grace 之内的 blob 不删除
+	if count, _, err := gcBlobs(ctx, store, clusterName, namespace, deleted, time.Hour, false); err != nil || count != 0 {
+		t.Fatalf("grace 之内删除了 %d 个 blob: %v", count, err)
+	}
+	// dry-run 只统计不删除
+	if count, freed, err := gcBlobs(ctx, store, clusterName, namespace, deleted, 0, true); err != nil || count != 1 || freed == 0 {
+		t.Fatalf("dry-run 统计了 %d 个 blob（%d 字节）: %v", count, freed, err)
+	}
+	if !exists(store, blobKey(clusterName, namespace, testSum("a"), "")) {
+		t.Fatal("dry-run 删除了 blob")
+	}
+
+	// 只有已删除的快照引用的 blob 被删除
+	if count, _, err := gcBlobs(ctx, store, clusterName, namespace, deleted, 0, false); err != nil || count != 1 {
+		t.Fatalf("删除了 %d 个 blob，预期 1 个: %v", count, err)
+	}
+	if exists(store, blobKey(clusterName, namespace, testSum("a"), "")) {
+		t.Fatal("未被引用的 blob 没有删除")
+	}
+	if !exists(store, blobKey(clusterName, namespace, testSum("b"), "")) {
+		t.Fatal("仍被引用的 blob 被删除")
+	}
+	if err := restoreTestBackup(t, executor, store, pod, second.Key); err != nil {
+		t.Fatalf("清理 blob 后恢复失败: %v", err)
+	}
+}
@@ -1,34 +1,44 @@
 package cmd
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"github.com/schollz/progressbar/v3"
 	"github.com/spf13/cobra"
+	"io"
 	v1 "k8s.io/api/core/v1"
-	_ "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
-	_ "k8s.io/client-go/tools/clientcmd"
-	_ "path/filepath"
+	"os"
+	"path"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 var (
 	restoreFile string
+	restoreDir  string
+	verifyFirst bool
 )
 
 func init() {
-	restoreCmd.Flags().StringVar(&restoreFile, "file", "", "要恢复的文件名")
-	restoreCmd.Flags().StringSliceVar(&pods, "pods", []string{}, "Comma-separated list of pod names")
-	restoreCmd.Flags().StringVarP(&label, "label", "l", "", "Label selector to filter pods")
-	restoreCmd.Flags().StringVarP(&dataDir, "datadir", "d", "/iotdb/data/datanode", "Data directory inside the pod")
+	restoreCmd.Flags().StringVar(&restoreFile, "file", "", "要恢复的文件，可以是存储中的完整路径，也可以只是备份文件名")
+	restoreCmd.Flags().BoolVar(&verifyFirst, "verify-first", true, "解压前先完整读取一遍备份校验 SHA-256，校验失败时不解压（备份会被下载两次）。设为 false 时边解压边校验，损坏的备份会先被解压到 pod 中，校验失败后再删除")
+	restoreCmd.Flags().StringVar(&encryptionKeyFile, "encryption-key-file", "", "解密 .age 备份使用的 age 私钥文件")
+	restoreCmd.Flags().StringVar(&encryptionPassphraseEnv, "encryption-passphrase-env", "", "保存解密口令的环境变量名，与 --encryption-key-file 二选一")
+	restoreCmd.Flags().StringVar(&restoreDir, "restore-dir", "/iotdb/data/restore", "pod 中解压备份的临时目录，加载完成后删除")
+	restoreCmd.Flags().IntVar(&retryPolicy.MaxAttempts, "retry-attempts", retryPolicy.MaxAttempts, "Kubernetes exec、存储读写遇到临时性错误时的最大尝试次数，1 表示不重试")
+	restoreCmd.Flags().DurationVar(&retryPolicy.InitialBackoff, "retry-backoff", retryPolicy.InitialBackoff, "第一次重试前的等待时间，之后每次翻倍")
+	restoreCmd.Flags().DurationVar(&retryPolicy.MaxBackoff, "retry-max-backoff", retryPolicy.MaxBackoff, "重试等待时间的上限")
+	addPodFlags(restoreCmd)
+	addScopeFlags(restoreCmd)
+	addStorageFlags(restoreCmd)
+	// 恢复直接从存储读取备份，不使用这两个参数，保留以兼容旧的命令行
 	restoreCmd.Flags().StringVarP(&outName, "outname", "o", "", "Output file name for the backup")
-	restoreCmd.Flags().StringVarP(&bucketName, "bucketname", "b", "iotdb-backup", "OSS bucket name")
-	restoreCmd.Flags().IntVarP(&verbose, "verbose", "v", 0, "Verbose level (0: silent, 1: basic, 2: detailed)")
-	restoreCmd.Flags().StringVar(&configPath, "config", "/root/.kube/config", "Path to the kubeconfig file")
-	restoreCmd.Flags().StringVar(&namespace, "namespace", "default", "Kubernetes namespace")
 	restoreCmd.Flags().BoolVar(&keepLocal, "keep-local", true, "保留本地备份文件")
-	restoreCmd.Flags().Int64Var(&chunkSize, "chunksize", 10*1024*1024, "下载和上传的分片大小（字节）")
-	restoreCmd.Flags().StringVar(&containers, "containers", "iotdb-datanode", "要操作的容器，多个容器用逗号分隔")
+	restoreCmd.Flags().MarkDeprecated("outname", "恢复不使用这个参数")
+	restoreCmd.Flags().MarkDeprecated("keep-local", "恢复不保存本地文件")
 	rootCmd.AddCommand(restoreCmd)
 }
 
@@ -37,27 +47,93 @@ var restoreCmd = &cobra.Command{
 	Short: "restore iotdb data from OSS ",
 	Long:  `从 OSS 下载备份文件并恢复到指定的 Kubernetes pods 中。`,
 	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
 		if restoreFile == "" {
 			fmt.Println("错误：必须指定要恢复的文件名（使用 --file 参数）")
-			return
+			os.Exit(1)
 		}
 
-		clientset, err := getClientSet(configPath)
+		clientset, executor, err := getKubeClients()
 		if err != nil {
 			fmt.Printf("创建 Kubernetes 客户端失败: %v\n", err)
-			return
+			os.Exit(1)
 		}
 
-		podList, err := getPodList(clientset, namespace, pods, "")
+		podList, missing, err := getPodList(ctx, clientset, namespace, pods, "")
 		if err != nil {
 			fmt.Printf("获取 pod 列表失败: %v\n", err)
-			return
+			os.Exit(1)
 		}
 
+		store, err := openStorage(ctx)
+		if err != nil {
+			fmt.Printf("打开存储失败: %v\n", err)
+			os.Exit(1)
+		}
+
+		backupKey, err := resolveBackupKey(ctx, store, restoreFile)
+		if err != nil {
+			fmt.Printf("查找备份文件失败: %v\n", err)
+			os.Exit(1)
+		}
+
+		steps, removed, err := restorePlan(ctx, store, backupKey)
+		if err != nil {
+			fmt.Printf("读取备份清单失败: %v\n", err)
+			os.Exit(1)
+		}
+
+		enc, err := loadEncryption()
+		if err != nil {
+			fmt.Printf("加载解密密钥失败: %v\n", err)
+			os.Exit(1)
+		}
+		for _, step := range steps {
+			if step.encrypted && enc == nil {
+				fmt.Println("错误：备份文件已加密，需要指定 --encryption-key-file 或 --encryption-passphrase-env")
+				os.Exit(1)
+			}
+		}
+
+		for _, step := range steps {
+			if step.checksum == "" {
+				log(0, "警告: 备份文件 %s 没有记录校验和，跳过完整性校验", step.key)
+				continue
+			}
+			if !verifyFirst {
+				continue
+			}
+			if err := trackStepDuration("verify checksum", func() error {
+				_, err := verifyObject(ctx, store, step.key, step.checksum)
+				return err
+			}); err != nil {
+				fmt.Printf("备份校验失败，已取消恢复: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		// 无法获取的 pod 同样算作恢复失败
+		failed := len(missing)
 		for _, pod := range podList.Items {
-			trackStepDuration("restore by load tsfile", func() error {
-				return restorePod(clientset, pod, restoreFile, pods)
-			})
+			if ctx.Err() != nil {
+				break
+			}
+			if err := trackStepDuration("restore by load tsfile", func() error {
+				return restorePod(ctx, executor, store, enc, pod, steps, removed)
+			}); err != nil {
+				failed++
+			}
+		}
+		if summary := retrySummary(); summary != nil {
+			fmt.Printf("重试统计: %s\n", formatRetrySummary(summary))
+		}
+		if interrupted(ctx) {
+			fmt.Println("恢复已中断")
+			os.Exit(1)
+		}
+		if failed > 0 {
+			fmt.Printf("%d 个 pod 恢复失败\n", failed)
+			os.Exit(1)
 		}
 	},
 }
@@ -73,39 +149,110 @@ var restoreCmd = &cobra.Command{
 //	}
 //}
 
-func restorePod(clientset *kubernetes.Clientset, pod v1.Pod, fileName string, pods []string) error {
+// restoreStep 是恢复时需要依次解压的一个备份文件
+type restoreStep struct {
+	key       string
+	codec     string
+	checksum  string
+	encrypted bool
+	// 去重备份的快照文件，解压时从 blob 组装 tar 流
+	snapshot bool
+	// dataDir 是清单中记录的备份时的数据目录，没有清单的旧备份为空
+	dataDir string
+}
+
+// restorePlan 返回恢复 key 需要依次解压的备份文件，以及解压后需要删除的文件。
+// 增量备份需要从全量备份开始依次解压备份链中的每个备份，再删除最后一次备份时已经不存在的文件
+func restorePlan(ctx context.Context, store Storage, key string) ([]restoreStep, []string, error) {
+	// 清单中记录了备份的压缩算法和校验和，没有清单的旧备份根据扩展名判断压缩算法
+	entry, err := findManifestEntry(ctx, store, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	chain := []runEntry{{ManifestEntry: ManifestEntry{Key: key}}}
+	if entry != nil {
+		chain[0].ManifestEntry = *entry
+	}
+	var removed []string
+	if entry != nil && entry.Parent != "" {
+		segments := strings.Split(key, "/")
+		entries, err := manifestEntries(ctx, store, segments[0], segments[1])
+		if err != nil {
+			return nil, nil, err
+		}
+		if chain, err = backupChain(entries, key); err != nil {
+			return nil, nil, err
+		}
+		removed = removedFiles(chain)
+		log(1, "备份 %s 是增量备份，需要依次恢复 %d 个备份", key, len(chain))
+	}
+
+	var steps []restoreStep
+	for _, e := range chain {
+		step := restoreStep{
+			key:       e.Key,
+			codec:     e.Compression,
+			encrypted: isEncryptedKey(e.Key) || e.Encryption != nil,
+			snapshot:  isSnapshotKey(e.Key),
+			dataDir:   e.DataDir,
+		}
+		if step.codec == "" {
+			step.codec = compressionFromKey(e.Key)
+		}
+		if step.checksum, err = entryChecksum(ctx, store, e.Key, &e.ManifestEntry); err != nil {
+			return nil, nil, fmt.Errorf("读取备份校验和失败: %v", err)
+		}
+		log(2, "备份文件 %s 的压缩算法: %s", step.key, step.codec)
+		steps = append(steps, step)
+	}
+	return steps, removed, nil
+}
+
+func restorePod(ctx context.Context, executor PodExecutor, store Storage, enc *encryption, pod v1.Pod, steps []restoreStep, removed []string) error {
 	containerList := strings.Split(containers, ",")
-	var wg sync.WaitGroup // 使用 WaitGroup 等待所有 goroutine 完成
+	// 解压后的目录结构与备份时的数据目录相同，没有清单的旧备份使用 --datadir
+	backupDataDir := dataDir
+	if last := steps[len(steps)-1]; last.dataDir != "" {
+		backupDataDir = last.dataDir
+	}
 
 	for _, containerName := range containerList {
 		containerName = strings.TrimSpace(containerName)
 		fmt.Printf("正在处理 pod %s 的容器 %s\n", pod.Name, containerName)
 
-		trackStepDuration("env check", func() error {
-			return ensureOssutilAvailable(clientset, namespace, pod.Name, containerName, configPath)
-		})
-
-		// 下载文件从 OSS
-		trackStepDuration("download from oss", func() error {
-			return downloadFromOSS(clientset, pod.Name, containerName, fileName)
-		})
-
-		// 解压文件并获取 tsfile
-		restoreCmd := fmt.Sprintf("tar -xf %s && find iotdb/data/datanode/ -name \"*.tsfile\"", fileName)
-		log(2, "执行解压命令: %s", restoreCmd)
-		_, err := executePodCommand(clientset, namespace, pod.Name, containerName, []string{"sh", "-c", restoreCmd}, configPath)
-		if err != nil {
-			return fmt.Errorf("解压命令失败: %v", err)
+		// 从存储读取备份，直接通过 exec 标准输入流解压到 pod 中，校验失败时删除已解压的文件，不做任何加载
+		if err := trackStepDuration("download and extract", func() error {
+			for _, step := range steps {
+				// 解压会覆盖已解压的文件，传输中断时重新解压整个备份文件
+				err := retry(ctx, "恢复数据", func() error {
+					if step.snapshot {
+						return extractSnapshotToPod(ctx, executor, store, enc, step.key, step.checksum, pod.Name, containerName)
+					}
+					return extractToPod(ctx, executor, store, enc, step.key, step.codec, step.checksum, pod.Name, containerName)
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return removeRestoredFiles(ctx, executor, pod.Name, containerName, removed)
+		}); err != nil {
+			cleanupRestoreDir(executor, pod.Name, containerName)
+			return err
 		}
 
-		// 获取 tsfile 列表
-		tsfileCmd := "find iotdb/data/datanode/ -name \"*.tsfile\""
-		tsfileList, err := executePodCommand(clientset, namespace, pod.Name, containerName, []string{"sh", "-c", tsfileCmd}, configPath)
+		// 获取 tsfile 列表，tar 打包时去掉了开头的 /，解压后的数据目录位于 restoreDir 下
+		tsfileCmd := fmt.Sprintf("find '%s' -name \"*.tsfile\"", path.Join(restoreDir, backupDataDir))
+		tsfileList, err := executePodCommand(ctx, executor, namespace, pod.Name, containerName, []string{"sh", "-c", tsfileCmd})
 		if err != nil {
-			return fmt.Errorf("获取 tsfile 列表失败: %v", err)
+			if interrupted(ctx) {
+				cleanupRestoreDir(executor, pod.Name, containerName)
+			}
+			return fmt.Errorf("获取 tsfile 列表失败: %w", err)
 		}
 
 		// 拆分 tsfile 列表并并发执行 load 命令
+		var wg sync.WaitGroup // 使用 WaitGroup 等待所有 goroutine 完成
+		var failed int32
 		tsfiles := strings.Split(tsfileList, "\n")
 		for _, tsfile := range tsfiles {
 			if tsfile == "" {
@@ -116,69 +263,145 @@ func restorePod(clientset *kubernetes.Clientset, pod v1.Pod, fileName string, po
 				defer wg.Done() // 完成时减少计数
 				loadCmd := fmt.Sprintf("/iotdb/sbin/start-cli.sh -h %s -e \"load '%s' verify=false\";", pod.Name, tsfile)
 				log(2, "执行加载命令: %s", loadCmd)
-				_, err := executePodCommand(clientset, namespace, pod.Name, containerName, []string{"sh", "-c", loadCmd}, configPath)
+				_, err := executePodCommand(ctx, executor, namespace, pod.Name, containerName, []string{"sh", "-c", loadCmd})
 				if err != nil {
+					atomic.AddInt32(&failed, 1)
 					fmt.Printf("加载命令失败: %v\n", err)
 				}
 			}(tsfile) // 传递 tsfile
 		}
-	}
+		wg.Wait() // 等待所有 goroutine 完成
 
-	wg.Wait() // 等待所有 goroutine 完成
+		if failed > 0 {
+			// 中断时加载不完整，删除解压的文件，重新运行恢复即可；其他加载失败保留文件便于排查
+			if interrupted(ctx) {
+				cleanupRestoreDir(executor, pod.Name, containerName)
+				return fmt.Errorf("恢复被中断，%d 个 tsfile 未加载: %w", failed, ctx.Err())
+			}
+			return fmt.Errorf("%d 个 tsfile 加载失败，已解压的文件保留在 pod %s 的 %s 中", failed, pod.Name, restoreDir)
+		}
 
-	// 删除文件
-	//deleteCmd := fmt.Sprintf("rm -rf ./iotdb")
-	//_, err := executePodCommand(clientset, namespace, pod.Name, containerName, []string{"sh", "-c", deleteCmd}, configPath)
-	//if err != nil {
-	//	fmt.Printf("警告：删除下载的文件 %s 失败: %v\n", fileName, err)
-	//}
+		// 删除解压出的文件
+		cleanupRestoreDir(executor, pod.Name, containerName)
+	}
 
 	return nil
 }
 
-func downloadFromOSS(clientset *kubernetes.Clientset, podName, containerName, fileName string) error {
-	credentials, err := loadCredentials(".credentials")
+// cleanupRestoreDir 删除 pod 中的解压目录。恢复可能已被中断，使用单独的 context 保证临时文件被删除
+func cleanupRestoreDir(executor PodExecutor, podName, containerName string) {
+	ctx, cancel := cleanupContext()
+	defer cancel()
+	if _, err := executePodCommand(ctx, executor, namespace, podName, containerName, []string{"rm", "-rf", restoreDir}); err != nil {
+		fmt.Printf("警告：删除解压目录 %s 失败: %v\n", restoreDir, err)
+	}
+}
+
+// resolveBackupKey 将 --file 解析为存储中的 key。
+// 既支持完整的 key，也支持只给出备份文件名，此时在存储中按文件名查找
+func resolveBackupKey(ctx context.Context, store Storage, file string) (string, error) {
+	_, err := store.Stat(ctx, file)
+	if err == nil {
+		return file, nil
+	}
+	if err != ErrObjectNotFound {
+		return "", err
+	}
+
+	objects, err := store.List(ctx, "")
 	if err != nil {
-		log(2, "加载 OSS 凭证失败: %v", err)
-		return err
+		return "", err
+	}
+	var matches []string
+	for _, object := range objects {
+		if path.Base(object.Key) == path.Base(file) {
+			matches = append(matches, object.Key)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("存储中不存在备份文件 %s", file)
+	case 1:
+		log(2, "备份文件 %s 解析为 %s", file, matches[0])
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("存在多个名为 %s 的备份文件，请使用完整路径: %s", file, strings.Join(matches, ", "))
 	}
+}
 
-	// 创建 ossutil 配置文件
-	configContent := fmt.Sprintf(`
-[Credentials]
-language=EN
-endpoint=%s
-accessKeyID=%s
-accessKeySecret=%s
-`, credentials["ENDPOINT"], credentials["AK"], credentials["SK"])
+// removeRestoredFiles 删除解压目录中增量备份链里已被删除的文件，文件列表通过标准输入传入
+func removeRestoredFiles(ctx context.Context, executor PodExecutor, podName, containerName string, files []string) error {
+	if len(files) == 0 {
+		return nil
+	}
+	var list strings.Builder
+	for _, f := range files {
+		list.WriteString(path.Join(restoreDir, f) + "\n")
+	}
+	cmd := []string{"sh", "-c", `while IFS= read -r f; do rm -f -- "$f"; done`}
+	if err := streamPodCommand(ctx, executor, namespace, podName, containerName, cmd, strings.NewReader(list.String()), nil); err != nil {
+		return fmt.Errorf("删除已不存在的文件失败: %v", err)
+	}
+	log(1, "已删除 %d 个在最后一次备份时已不存在的文件", len(files))
+	return nil
+}
 
-	configFileName := ".ossutilconfig"
-	createConfigCmd := fmt.Sprintf(`echo '%s' > %s`, configContent, configFileName)
+// extractToPod 在本地读取存储中的备份，通过 exec 的标准输入流交给 pod 中的 tar 解压，
+// pod 中不需要 ossutil、外网访问和存储凭证，也不会保存压缩包。
+// 传输的同时计算 SHA-256，checksum 不为空且不一致时返回 checksumMismatchError。
+// .age 结尾的备份在本地解密，再按 codec 在本地解压后交给 pod 中的 tar，pod 中不需要对应的解压工具
+func extractToPod(ctx context.Context, executor PodExecutor, store Storage, enc *encryption, key, codec, checksum, podName, containerName string) error {
+	fileName := path.Base(key)
+	info, err := store.Stat(ctx, key)
+	if err != nil {
+		return fmt.Errorf("获取备份文件 %s 信息失败: %w", key, err)
+	}
 
-	_, err = executePodCommand(clientset, namespace, podName, containerName, []string{"sh", "-c", createConfigCmd}, configPath)
+	body, err := store.Get(ctx, key)
 	if err != nil {
-		return fmt.Errorf("创建 ossutil 配置文件失败: %v", err)
+		return fmt.Errorf("从存储下载文件失败: %w", err)
 	}
+	defer body.Close()
 
-	// 使用 ossutil 下载文件
-	downloadCmd := fmt.Sprintf(`
-		./ossutil64 cp oss://%s/%s %s \
-		-c %s \
-		--force
-	`, bucketName, fileName, fileName, configFileName)
+	bar := progressbar.DefaultBytes(
+		info.Size,
+		fileName+" 正在恢复",
+	)
 
-	_, stderr, err := executePodCommandWithStderr(clientset, namespace, podName, containerName, []string{"sh", "-c", downloadCmd}, configPath)
-	log(2, "下载文件命令的 downloadCmd: %s", downloadCmd)
+	hasher := sha256.New()
+	raw := io.TeeReader(body, io.MultiWriter(bar, hasher))
+	reader := raw
+	if isEncryptedKey(key) {
+		if reader, err = enc.decryptStream(reader); err != nil {
+			return err
+		}
+	}
+	decompressed, err := decompressStream(codec, reader)
 	if err != nil {
-		return fmt.Errorf("从 OSS 下载文件失败: %v, stderr: %s", err, stderr)
+		return fmt.Errorf("解压备份文件失败: %w", err)
 	}
+	defer decompressed.Close()
+	reader = decompressed
 
-	// 删除配置文件
-	deleteConfigCmd := fmt.Sprintf("rm -f %s", configFileName)
-	_, err = executePodCommand(clientset, namespace, podName, containerName, []string{"sh", "-c", deleteConfigCmd}, configPath)
+	extractCmd := fmt.Sprintf("mkdir -p '%s' && tar -xf - -C '%s'", restoreDir, restoreDir)
+	log(2, "执行解压命令: %s", extractCmd)
+	err = streamPodCommand(ctx, executor, namespace, podName, containerName, []string{"sh", "-c", extractCmd}, reader, nil)
 	if err != nil {
-		fmt.Printf("警告: 删除 ossutil 配置文件失败: %v\n", err)
+		return fmt.Errorf("解压备份文件到 pod 失败: %w", err)
+	}
+
+	// tar 读到归档结束标记后可能不再读取剩余的填充数据，读完剩余部分以计算完整的校验和，
+	// 加密时也保证最后一个数据块经过认证
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return fmt.Errorf("读取备份文件失败: %w", err)
+	}
+	if _, err := io.Copy(io.Discard, raw); err != nil {
+		return fmt.Errorf("读取备份文件失败: %w", err)
+	}
+	if actual := hex.EncodeToString(hasher.Sum(nil)); checksum != "" && actual != checksum {
+		return &checksumMismatchError{key: key, expected: checksum, actual: actual}
 	}
 
+	log(2, "文件 %s 已从 %s 解压到 pod %s 的 %s", fileName, store.URL(key), podName, restoreDir)
 	return nil
 }
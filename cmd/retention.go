@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy 描述每个 pod 要保留哪些备份，各条规则保留的备份取并集
+type RetentionPolicy struct {
+	KeepLast    int `json:"keepLast,omitempty"`
+	KeepDaily   int `json:"keepDaily,omitempty"`
+	KeepWeekly  int `json:"keepWeekly,omitempty"`
+	KeepMonthly int `json:"keepMonthly,omitempty"`
+}
+
+func (p RetentionPolicy) empty() bool {
+	return p.KeepLast <= 0 && p.KeepDaily <= 0 && p.KeepWeekly <= 0 && p.KeepMonthly <= 0
+}
+
+func (p RetentionPolicy) String() string {
+	var rules []string
+	if p.KeepLast > 0 {
+		rules = append(rules, fmt.Sprintf("最近 %d 个", p.KeepLast))
+	}
+	if p.KeepDaily > 0 {
+		rules = append(rules, fmt.Sprintf("%d 天内每天 1 个", p.KeepDaily))
+	}
+	if p.KeepWeekly > 0 {
+		rules = append(rules, fmt.Sprintf("%d 周内每周 1 个", p.KeepWeekly))
+	}
+	if p.KeepMonthly > 0 {
+		rules = append(rules, fmt.Sprintf("%d 个月内每月 1 个", p.KeepMonthly))
+	}
+	return strings.Join(rules, "，")
+}
+
+// retentionDecision 是对一个备份的保留或删除决定
+type retentionDecision struct {
+	Backup  BackupRecord
+	Keep    bool
+	Reasons []string
+}
+
+// applyRetention 按 pod 分组对备份应用保留策略，返回每个备份的决定。
+// 只有成功的备份参与保留规则，清单中记录为失败的备份总是删除。每个时间段内保留最新的一个成功备份；
+// 一个 pod 的所有成功备份都不满足策略时仍保留其最新的成功备份，保证不会删除 pod 唯一的成功备份。
+// 保留的增量备份所依赖的父备份也会保留，否则增量备份无法恢复
+func applyRetention(backups []BackupRecord, policy RetentionPolicy, now time.Time) []retentionDecision {
+	byPod := map[string][]BackupRecord{}
+	var podNames []string
+	for _, b := range backups {
+		if _, ok := byPod[b.Pod]; !ok {
+			podNames = append(podNames, b.Pod)
+		}
+		byPod[b.Pod] = append(byPod[b.Pod], b)
+	}
+	sort.Strings(podNames)
+
+	var decisions []retentionDecision
+	for _, pod := range podNames {
+		podBackups := byPod[pod]
+		sort.SliceStable(podBackups, func(i, j int) bool {
+			return podBackups[i].Time.After(podBackups[j].Time)
+		})
+
+		reasons := make([][]string, len(podBackups))
+		keepPeriods := func(name string, since time.Time, period func(time.Time) string) {
+			seen := map[string]bool{}
+			for i, b := range podBackups {
+				if b.Time.Before(since) {
+					break
+				}
+				if !b.succeeded() {
+					continue
+				}
+				p := period(b.Time)
+				if !seen[p] {
+					seen[p] = true
+					reasons[i] = append(reasons[i], name+" "+p)
+				}
+			}
+		}
+
+		last := 0
+		for i, b := range podBackups {
+			if b.succeeded() && last < policy.KeepLast {
+				last++
+				reasons[i] = append(reasons[i], fmt.Sprintf("last %d", last))
+			}
+		}
+		if policy.KeepDaily > 0 {
+			keepPeriods("daily", now.AddDate(0, 0, -policy.KeepDaily), func(t time.Time) string {
+				return t.Local().Format("2006-01-02")
+			})
+		}
+		if policy.KeepWeekly > 0 {
+			keepPeriods("weekly", now.AddDate(0, 0, -7*policy.KeepWeekly), func(t time.Time) string {
+				year, week := t.Local().ISOWeek()
+				return fmt.Sprintf("%d-W%02d", year, week)
+			})
+		}
+		if policy.KeepMonthly > 0 {
+			keepPeriods("monthly", now.AddDate(0, -policy.KeepMonthly, 0), func(t time.Time) string {
+				return t.Local().Format("2006-01")
+			})
+		}
+
+		latest, kept := -1, 0
+		for i, b := range podBackups {
+			if b.succeeded() && latest < 0 {
+				latest = i
+			}
+			if len(reasons[i]) > 0 {
+				kept++
+			}
+		}
+		if kept == 0 && latest >= 0 {
+			reasons[latest] = append(reasons[latest], "only backup")
+		}
+
+		// 从新到旧遍历，父备份总是比子备份旧，一次遍历即可保留整条备份链
+		index := map[string]int{}
+		for i, b := range podBackups {
+			index[b.Key] = i
+		}
+		for i, b := range podBackups {
+			if len(reasons[i]) == 0 || b.Parent == "" {
+				continue
+			}
+			if p, ok := index[b.Parent]; ok {
+				reasons[p] = append(reasons[p], "parent of "+path.Base(b.Key))
+			}
+		}
+
+		for i, b := range podBackups {
+			decisions = append(decisions, retentionDecision{Backup: b, Keep: len(reasons[i]) > 0, Reasons: reasons[i]})
+		}
+	}
+	return decisions
+}
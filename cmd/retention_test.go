@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApplyRetention(t *testing.T) {
+	now := time.Date(2024, 9, 20, 12, 0, 0, 0, time.Local)
+	backup := func(key string, age time.Duration, status string) BackupRecord {
+		return BackupRecord{Key: key, Pod: "iotdb-datanode-0", Time: now.Add(-age), Status: status}
+	}
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name    string
+		backups []BackupRecord
+		policy  RetentionPolicy
+		keep    []string
+	}{
+		{
+			name: "keep last",
+			backups: []BackupRecord{
+				backup("a", 3*day, backupStatusSuccess),
+				backup("b", 2*day, backupStatusSuccess),
+				backup("c", day, backupStatusSuccess),
+			},
+			policy: RetentionPolicy{KeepLast: 2},
+			keep:   []string{"c", "b"},
+		},
+		{
+			name: "keep last skips failed backups",
+			backups: []BackupRecord{
+				backup("a", 3*day, backupStatusSuccess),
+				backup("b", 2*day, backupStatusSuccess),
+				backup("c", day, backupStatusFailed),
+			},
+			policy: RetentionPolicy{KeepLast: 2},
+			keep:   []string{"b", "a"},
+		},
+		{
+			name: "keep daily keeps the latest successful backup of each day",
+			backups: []BackupRecord{
+				backup("a", day+2*time.Hour, backupStatusSuccess),
+				backup("b", day+time.Hour, backupStatusSuccess),
+				backup("c", time.Hour+time.Minute, backupStatusSuccess),
+				backup("d", time.Hour, backupStatusFailed),
+				backup("e", 5*day, backupStatusSuccess),
+			},
+			policy: RetentionPolicy{KeepDaily: 2},
+			keep:   []string{"c", "b"},
+		},
+		{
+			name: "only successful backup is kept when no rule matches",
+			backups: []BackupRecord{
+				backup("a", 30*day, backupStatusSuccess),
+				backup("b", 2*time.Hour, backupStatusFailed),
+				backup("c", time.Hour, backupStatusFailed),
+			},
+			policy: RetentionPolicy{KeepDaily: 7},
+			keep:   []string{"a"},
+		},
+		{
+			name: "legacy backups without manifest count as successful",
+			backups: []BackupRecord{
+				backup("a", 30*day, ""),
+				backup("b", 20*day, ""),
+			},
+			policy: RetentionPolicy{KeepDaily: 7},
+			keep:   []string{"b"},
+		},
+		{
+			name: "failed backups are all deleted",
+			backups: []BackupRecord{
+				backup("a", 2*time.Hour, backupStatusFailed),
+				backup("b", time.Hour, backupStatusFailed),
+			},
+			policy: RetentionPolicy{KeepLast: 5},
+			keep:   nil,
+		},
+		{
+			name: "parents of kept incremental backups are kept",
+			backups: []BackupRecord{
+				backup("full", 3*day, backupStatusSuccess),
+				{Key: "inc1", Pod: "iotdb-datanode-0", Time: now.Add(-2 * day), Status: backupStatusSuccess, Parent: "full"},
+				{Key: "inc2", Pod: "iotdb-datanode-0", Time: now.Add(-day), Status: backupStatusSuccess, Parent: "inc1"},
+			},
+			policy: RetentionPolicy{KeepLast: 1},
+			keep:   []string{"inc2", "inc1", "full"},
+		},
+		{
+			name: "pods are retained separately",
+			backups: []BackupRecord{
+				backup("a", 2*day, backupStatusSuccess),
+				backup("b", day, backupStatusSuccess),
+				{Key: "c", Pod: "iotdb-datanode-1", Time: now.Add(-10 * day), Status: backupStatusSuccess},
+			},
+			policy: RetentionPolicy{KeepLast: 1},
+			keep:   []string{"b", "c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var keep []string
+			decisions := applyRetention(tt.backups, tt.policy, now)
+			if len(decisions) != len(tt.backups) {
+				t.Fatalf("%d 个备份得到 %d 个决定", len(tt.backups), len(decisions))
+			}
+			for _, d := range decisions {
+				if d.Keep {
+					keep = append(keep, d.Backup.Key)
+				}
+			}
+			if !reflect.DeepEqual(keep, tt.keep) {
+				t.Fatalf("保留 %v，预期 %v", keep, tt.keep)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/minio/minio-go/v7"
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/exec"
+)
+
+// RetryPolicy 描述临时性错误的重试方式：第 n 次重试前等待 InitialBackoff*2^(n-1)，
+// 不超过 MaxBackoff，并加上 ±Jitter 比例的随机抖动，避免多个 pod 同时重试
+type RetryPolicy struct {
+	MaxAttempts    int           `json:"maxAttempts"`
+	InitialBackoff time.Duration `json:"initialBackoff"`
+	MaxBackoff     time.Duration `json:"maxBackoff"`
+	Jitter         float64       `json:"jitter"`
+}
+
+var retryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Second,
+	MaxBackoff:     30 * time.Second,
+	Jitter:         0.2,
+}
+
+// backoff 返回第 attempt 次失败后的等待时间
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// retryError 是重试多次后仍然失败的错误，外层不会再次重试
+type retryError struct {
+	op       string
+	attempts int
+	err      error
+}
+
+func (e *retryError) Error() string {
+	return fmt.Sprintf("%s 尝试 %d 次后仍然失败: %v", e.op, e.attempts, e.err)
+}
+
+func (e *retryError) Unwrap() error {
+	return e.err
+}
+
+// retry 执行 fn，遇到可重试的错误时按 retryPolicy 退避后重试。
+// fn 只在第一次就遇到不可重试的错误时原样返回错误，便于调用方判断 ErrObjectNotFound 等错误
+func retry(ctx context.Context, op string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			if attempt > 1 {
+				log(1, "%s 第 %d 次尝试成功", op, attempt)
+			}
+			return nil
+		}
+		if !isRetryable(err) || attempt >= retryPolicy.MaxAttempts {
+			if attempt == 1 {
+				return err
+			}
+			return &retryError{op: op, attempts: attempt, err: err}
+		}
+
+		wait := retryPolicy.backoff(attempt)
+		log(0, "%s 第 %d 次尝试失败，%v 后重试: %v", op, attempt, wait.Round(time.Millisecond), err)
+		recordRetry(ctx, op)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// httpStatusError 是 HTTP 接口返回的非 200 状态码
+type httpStatusError struct {
+	StatusCode int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("状态码: %d", e.StatusCode)
+}
+
+// retryableMessages 是没有具体错误类型、只能按错误信息判断的临时性网络错误，主要来自 SPDY exec 流
+var retryableMessages = []string{
+	"connection reset",
+	"connection refused",
+	"broken pipe",
+	"unexpected eof",
+	"i/o timeout",
+	"tls handshake timeout",
+	"error dialing backend",
+	"unable to upgrade connection",
+	"use of closed network connection",
+	"http2: ",
+}
+
+// isRetryable 判断错误是否是临时性错误：网络错误、服务端 5xx 和限流错误可以重试，
+// 命令退出码非 0、对象不存在、校验和不一致、权限错误等重试也不会成功
+func isRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var re *retryError
+	var mismatch *checksumMismatchError
+	var exitErr exec.ExitError
+	var timeoutErr *stepTimeoutError
+	switch {
+	case errors.As(err, &re), errors.As(err, &mismatch), errors.As(err, &exitErr), errors.As(err, &timeoutErr):
+		return false
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded), errors.Is(err, ErrObjectNotFound):
+		return false
+	}
+
+	var ossErr oss.ServiceError
+	if errors.As(err, &ossErr) {
+		return retryableStatus(ossErr.StatusCode)
+	}
+	var s3Err minio.ErrorResponse
+	if errors.As(err, &s3Err) {
+		return retryableStatus(s3Err.StatusCode) || s3Err.Code == "RequestTimeout" || s3Err.Code == "SlowDown"
+	}
+	var httpErr *httpStatusError
+	if errors.As(err, &httpErr) {
+		return retryableStatus(httpErr.StatusCode)
+	}
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		return apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) || apierrors.IsTooManyRequests(err) ||
+			apierrors.IsInternalError(err) || apierrors.IsServiceUnavailable(err) || apierrors.IsUnexpectedServerError(err)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	message := strings.ToLower(err.Error())
+	for _, m := range retryableMessages {
+		if strings.Contains(message, m) {
+			return true
+		}
+	}
+	return false
+}
+
+func retryableStatus(code int) bool {
+	return code >= 500 || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout
+}
+
+// retryCounter 统计一次运行中各操作的重试次数，写入备份清单并在结束时输出。
+// 备份时每个集群的运行通过 context 使用单独的 retryCounter，其他命令使用 retryCounts
+type retryCounter struct {
+	mu sync.Mutex
+	m  map[string]int
+	// metric 不为 nil 时重试次数同时计入监控指标
+	metric *prometheus.CounterVec
+}
+
+var retryCounts = &retryCounter{}
+
+type retryCounterKey struct{}
+
+// withRetryCounter 返回重试次数计入 c 的 context
+func withRetryCounter(ctx context.Context, c *retryCounter) context.Context {
+	return context.WithValue(ctx, retryCounterKey{}, c)
+}
+
+func retryCounterFromContext(ctx context.Context) *retryCounter {
+	if c, ok := ctx.Value(retryCounterKey{}).(*retryCounter); ok {
+		return c
+	}
+	return retryCounts
+}
+
+func recordRetry(ctx context.Context, op string) {
+	c := retryCounterFromContext(ctx)
+	if c.metric != nil {
+		c.metric.WithLabelValues(op).Inc()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = map[string]int{}
+	}
+	c.m[op]++
+}
+
+// summary 返回各操作的重试次数，没有重试时返回 nil
+func (c *retryCounter) summary() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.m) == 0 {
+		return nil
+	}
+	summary := make(map[string]int, len(c.m))
+	for op, n := range c.m {
+		summary[op] = n
+	}
+	return summary
+}
+
+// retrySummary 返回 retryCounts 中各操作的重试次数，没有重试时返回 nil
+func retrySummary() map[string]int {
+	return retryCounts.summary()
+}
+
+// formatRetrySummary 将重试次数格式化为 "上传分片 3 次，执行命令 1 次"
+func formatRetrySummary(summary map[string]int) string {
+	ops := make([]string, 0, len(summary))
+	for op := range summary {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	parts := make([]string, 0, len(ops))
+	for _, op := range ops {
+		parts = append(parts, fmt.Sprintf("%s %d 次", op, summary[op]))
+	}
+	return strings.Join(parts, "，")
+}
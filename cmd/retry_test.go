@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/minio/minio-go/v7"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/exec"
+)
+
+func TestIsRetryable(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"nil", nil, false},
+		{"unexpected eof", fmt.Errorf("读取失败: %w", io.ErrUnexpectedEOF), true},
+		{"connection reset", &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, true},
+		{"spdy message", errors.New("error dialing backend: dial tcp 10.0.0.1:10250: i/o timeout"), true},
+		{"http2 message", errors.New("http2: client connection lost"), true},
+		{"oss 503", oss.ServiceError{StatusCode: http.StatusServiceUnavailable}, true},
+		{"oss 403", oss.ServiceError{StatusCode: http.StatusForbidden, Code: "AccessDenied"}, false},
+		{"s3 500", minio.ErrorResponse{StatusCode: http.StatusInternalServerError}, true},
+		{"s3 slow down", minio.ErrorResponse{StatusCode: http.StatusBadRequest, Code: "SlowDown"}, true},
+		{"s3 no such bucket", minio.ErrorResponse{StatusCode: http.StatusNotFound, Code: "NoSuchBucket"}, false},
+		{"http 429", &httpStatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{"http 408", &httpStatusError{StatusCode: http.StatusRequestTimeout}, true},
+		{"http 400", &httpStatusError{StatusCode: http.StatusBadRequest}, false},
+		{"apiserver unavailable", apierrors.NewServiceUnavailable("etcd"), true},
+		{"apiserver too many requests", apierrors.NewTooManyRequests("slow down", 1), true},
+		{"pod not found", apierrors.NewNotFound(pods, "iotdb-datanode-0"), false},
+		{"forbidden", apierrors.NewForbidden(pods, "iotdb-datanode-0", errors.New("rbac")), false},
+		{"exit code", fmt.Errorf("error executing command: %w", exec.CodeExitError{Err: errors.New("command terminated with exit code 2"), Code: 2}), false},
+		{"object not found", fmt.Errorf("下载失败: %w", ErrObjectNotFound), false},
+		{"checksum mismatch", &checksumMismatchError{key: "a", expected: "1", actual: "2"}, false},
+		{"canceled", context.Canceled, false},
+		{"deadline", fmt.Errorf("上传失败: %w", context.DeadlineExceeded), false},
+		{"step timeout", &stepTimeoutError{flag: "--upload-timeout", timeout: time.Minute, err: io.ErrUnexpectedEOF}, false},
+		{"retried", &retryError{op: "上传分片", attempts: 3, err: io.ErrUnexpectedEOF}, false},
+		{"other", errors.New("permission denied"), false},
+	}
+	for _, tt := range tests {
+		if got := isRetryable(tt.err); got != tt.retryable {
+			t.Errorf("%s: isRetryable = %v，预期 %v", tt.name, got, tt.retryable)
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := p.backoff(attempt + 1); got != want {
+			t.Errorf("第 %d 次失败后等待 %v，预期 %v", attempt+1, got, want)
+		}
+	}
+
+	// 抖动不超过 ±Jitter
+	p.Jitter = 0.2
+	for i := 0; i < 100; i++ {
+		if got := p.backoff(2); got < 1600*time.Millisecond || got > 2400*time.Millisecond {
+			t.Fatalf("抖动后等待 %v，超出 2s±20%%", got)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	saved := retryPolicy
+	t.Cleanup(func() { retryPolicy = saved })
+	retryPolicy = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	// 临时性错误重试后成功，重试次数计入 context 中的 retryCounter
+	counter := &retryCounter{}
+	ctx := withRetryCounter(context.Background(), counter)
+	attempts := 0
+	err := retry(ctx, "上传分片", func() error {
+		attempts++
+		if attempts < 3 {
+			return io.ErrUnexpectedEOF
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Fatalf("尝试 %d 次: %v", attempts, err)
+	}
+	if summary := counter.summary(); summary["上传分片"] != 2 {
+		t.Fatalf("重试次数: %v", summary)
+	}
+
+	// 不可重试的错误只尝试一次并原样返回
+	attempts = 0
+	err = retry(ctx, "下载对象", func() error {
+		attempts++
+		return ErrObjectNotFound
+	})
+	if attempts != 1 || err != ErrObjectNotFound {
+		t.Fatalf("尝试 %d 次: %v", attempts, err)
+	}
+
+	// 超过最大尝试次数后返回 retryError，外层不会再次重试
+	attempts = 0
+	err = retry(ctx, "执行命令", func() error {
+		attempts++
+		return io.ErrUnexpectedEOF
+	})
+	var re *retryError
+	if attempts != 3 || !errors.As(err, &re) || re.attempts != 3 || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("尝试 %d 次: %v", attempts, err)
+	}
+	if isRetryable(err) {
+		t.Fatal("重试多次后的错误不应再次重试")
+	}
+
+	// 等待重试时 context 被取消
+	retryPolicy.InitialBackoff, retryPolicy.MaxBackoff = time.Hour, time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts = 0
+	err = retry(ctx, "上传对象", func() error {
+		attempts++
+		cancel()
+		return io.ErrUnexpectedEOF
+	})
+	if attempts != 1 || !errors.Is(err, context.Canceled) {
+		t.Fatalf("尝试 %d 次: %v", attempts, err)
+	}
+}
@@ -6,10 +6,14 @@ import (
 	"os"
 )
 
+// version 在编译时通过 -ldflags "-X iotdbbackup/cmd.version=..." 注入
+var version = "dev"
+
 var rootCmd = &cobra.Command{
-	Use:   "iotdbtools",
-	Short: "A tool for back up and restore IoTDB data for nova-ems",
-	Long:  `iotdbtools is a CLI tool to backup and restore IoTDB data in Kubernetes.`,
+	Use:     "iotdbtools",
+	Version: version,
+	Short:   "A tool for back up and restore IoTDB data for nova-ems",
+	Long:    `iotdbtools is a CLI tool to backup and restore IoTDB data in Kubernetes.`,
 }
 
 // 定义 completion 子命令
@@ -51,34 +55,40 @@ Zsh:
 }
 
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	// 收到 SIGINT/SIGTERM 时取消 context，各命令取消上传、清理 pod 中的临时文件后退出
+	ctx, cancel := signalContext()
+	defer cancel()
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 }
 
 func init() {
-	// 可以在这里添加全局命令标志，或者初始化子命令
-	rootCmd.PersistentFlags().StringP("config", "c", "/root/.kube/config", "Path to the kubeconfig file")
-	rootCmd.PersistentFlags().StringP("namespace", "n", "iotdb", "Kubernetes namespace")
-	rootCmd.PersistentFlags().StringP("pods", "p", "iotdb-datanode-0", "backup by pod name")
-	rootCmd.PersistentFlags().StringP("label", "l", "statefulset.kubernetes.io/pod-name=iotdb-datanode-0", "backup by pod label")
-	rootCmd.PersistentFlags().StringP("datadir", "d", "/iotdb/data/datanode", "iotdb data dir")
-	rootCmd.PersistentFlags().StringP("outname", "o", "iotdb-datanode-back", "backup file name")
-	rootCmd.PersistentFlags().StringP("bucketname", "b", "iotdb-backup", "oss bucket name")
-	rootCmd.PersistentFlags().StringP("verbose", "v", "0", "backup log level")
-	rootCmd.PersistentFlags().StringP("keep-local", "k", "true", "keep file to local")
-	rootCmd.PersistentFlags().StringP("chunksize", "s", "10485760", "default chunksize is 10MB")
-	rootCmd.PersistentFlags().StringP("containers", "t", "iotdb-datanode", "default container")
-	rootCmd.PersistentFlags().StringP("cluster-name", "m", "", "k8s clusterName")
-	rootCmd.PersistentFlags().StringP("uploadoss", "", "yes", "uploadoss flag，default is true")
-	rootCmd.PersistentFlags().StringP("osscong", "", ".iotdbtoolss.conf", "oss config file")
+	rootCmd.PersistentFlags().StringVar(&configFile, "config-file", "", "配置文件路径，为空时使用 IOTDBTOOLS_CONFIG_FILE 环境变量或 ~/"+defaultConfigFile+"（存在时）")
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "使用配置文件中的指定 profile，为空时使用 IOTDBTOOLS_PROFILE 环境变量或配置文件中的 profile")
+	rootCmd.PersistentFlags().IntVarP(&verbose, "verbose", "v", 0, "Verbose level (0: silent, 1: basic, 2: detailed)")
+	// 命令行没有设置的参数使用环境变量和配置文件中的值
+	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		if err := applyConfig(cmd); err != nil {
+			log(0, "%v", err)
+			os.Exit(1)
+		}
+	}
 	// 添加 completion 子命令
 	rootCmd.AddCommand(completionCmd)
-	//rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
-	//	if ossConf {
-	//		configureOSS()
-	//	}
-	//	checkOSSConfig()
-	//}
+}
+
+// addScopeFlags 添加集群名称和命名空间参数，备份按集群、命名空间保存在存储中
+func addScopeFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&clusterName, "cluster-name", "m", "", "Kubernetes 集群名称")
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "default", "Kubernetes namespace")
+}
+
+// addPodFlags 添加选择 pod、容器和数据目录的参数，backup 和 restore 共用
+func addPodFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVarP(&pods, "pods", "p", []string{}, "Comma-separated list of pod names")
+	cmd.Flags().StringVarP(&label, "label", "l", "", "Label selector to filter pods")
+	cmd.Flags().StringVarP(&containers, "containers", "t", "iotdb-datanode", "要操作的容器，多个容器用逗号分隔")
+	cmd.Flags().StringVarP(&dataDir, "datadir", "d", "/iotdb/data/datanode", "Data directory inside the pod")
 }
@@ -0,0 +1,368 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ErrObjectNotFound 表示存储中不存在指定对象
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectInfo 描述存储中的一个对象
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	Metadata     map[string]string
+}
+
+// Storage 是备份文件所在的存储后端，backup、restore 等命令都通过它读写备份。
+// key 均为相对于后端根路径（bucket 前缀）的路径。
+type Storage interface {
+	// Put 以流的方式写入对象，size 未知时传 -1
+	Put(ctx context.Context, key string, r io.Reader, size int64, meta map[string]string) error
+	// Get 返回对象内容，调用方负责关闭
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// List 列出 prefix 下的所有对象
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Stat 返回对象信息，对象不存在时返回 ErrObjectNotFound
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// Delete 删除对象，对象不存在时不报错
+	Delete(ctx context.Context, key string) error
+	// URL 返回对象的访问地址，用于日志和通知
+	URL(key string) string
+}
+
+// addStorageFlags 添加存储地址和存储凭证的参数，所有读写存储的命令共用。
+// --credentials-secret 需要访问 Kubernetes，同时添加 addKubeFlags 中的参数
+func addStorageFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&bucketName, "bucketname", "b", "iotdb-backup", "OSS bucket name")
+	cmd.Flags().StringVar(&storageURL, "storage", "", "备份存储地址，如 oss://bucket/prefix、s3://bucket/prefix?endpoint=minio:9000&path-style=true，默认使用 --bucketname 对应的 OSS")
+	cmd.Flags().Int64Var(&chunkSize, "chunksize", 10*1024*1024, "下载和上传的分片大小（字节）")
+	cmd.Flags().StringVar(&credentialsSecret, "credentials-secret", "", "保存存储凭证的 Kubernetes Secret，格式为 [命名空间/]名称，默认在 --namespace 中")
+	cmd.Flags().StringVar(&credentialsCommand, "credentials-command", "", "获取存储凭证的命令，以 KEY=VALUE 格式输出 AK、SK 等")
+	cmd.Flags().StringVar(&credentialsFile, "credentials-file", ".credentials", "KEY=VALUE 格式的存储凭证文件，不存在时跳过")
+	cmd.Flags().StringVar(&ramRole, "ram-role", "", "在阿里云 ECS 上使用 RAM 角色的临时凭证访问 OSS，auto 表示使用实例绑定的角色")
+	addKubeFlags(cmd)
+}
+
+// openStorage 根据命令行参数创建存储后端，未指定 --storage 时使用 --bucketname 对应的 OSS
+func openStorage(ctx context.Context) (Storage, error) {
+	return openStorageURL(ctx, effectiveStorageURL(), nil, namespace)
+}
+
+// effectiveStorageURL 返回实际使用的存储地址
+func effectiveStorageURL() string {
+	if storageURL != "" {
+		return storageURL
+	}
+	return "oss://" + bucketName
+}
+
+// openStorageURL 根据存储地址创建存储后端，支持的格式：
+//
+//	oss://bucket/prefix?endpoint=oss-cn-hangzhou.aliyuncs.com
+//	s3://bucket/prefix?endpoint=minio:9000&region=us-east-1&path-style=true&insecure=true
+//	file:///mnt/backups
+//
+// OSS 和 S3 的凭证按 credentialChain 的顺序查找，--credentials-secret 从 clientset 的集群中读取，
+// 默认在 namespace 中，clientset 为 nil 时使用 getKubeClients 的客户端
+func openStorageURL(ctx context.Context, rawURL string, clientset kubernetes.Interface, namespace string) (Storage, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("无法解析存储地址 %s: %v", rawURL, err)
+	}
+
+	switch u.Scheme {
+	case "oss":
+		creds, err := resolveCredentials(ctx, credentialRequest{scheme: u.Scheme, storage: rawURL, clientset: clientset, namespace: namespace})
+		if err != nil {
+			return nil, err
+		}
+		if creds == nil {
+			return nil, fmt.Errorf("没有找到 OSS 凭证，请设置环境变量 ALIBABA_CLOUD_ACCESS_KEY_ID/ALIBABA_CLOUD_ACCESS_KEY_SECRET，" +
+				"或使用 --credentials-secret、配置文件中的 credentials、--credentials-command、--credentials-file、--ram-role")
+		}
+		endpoint := u.Query().Get("endpoint")
+		if endpoint == "" {
+			endpoint = creds.current.Endpoint
+		}
+		if endpoint == "" {
+			endpoint = os.Getenv("OSS_ENDPOINT")
+		}
+		if endpoint == "" {
+			return nil, fmt.Errorf("未指定 OSS endpoint，请在存储地址中使用 ?endpoint=、在凭证中设置 ENDPOINT 或设置环境变量 OSS_ENDPOINT")
+		}
+		return newOSSStorage(u.Host+u.Path, endpoint, creds, chunkSize, uploadConcurrency)
+	case "s3":
+		creds, err := resolveCredentials(ctx, credentialRequest{scheme: u.Scheme, storage: rawURL, clientset: clientset, namespace: namespace})
+		if err != nil {
+			return nil, err
+		}
+		return newS3Storage(u, creds, chunkSize, uploadConcurrency)
+	case "file":
+		return newFileStorage(u.Host + u.Path)
+	default:
+		return nil, fmt.Errorf("不支持的存储类型: %s", rawURL)
+	}
+}
+
+// backupObjectKey 返回备份文件在存储中的 key，按 集群/命名空间/pod/日期 分目录存放
+func backupObjectKey(clusterName, namespace, podName string, t time.Time, fileName string) string {
+	if clusterName == "" {
+		clusterName = "default"
+	}
+	return path.Join(clusterName, namespace, podName, t.Format("20060102"), fileName)
+}
+
+// uploadedPart 是已上传完成的分片
+type uploadedPart struct {
+	Number int
+	ETag   string
+}
+
+// multipartUploader 是支持分片上传的后端（OSS、S3）需要实现的底层操作，
+// 分片的切分与上传流程统一由 multipartPut 完成
+type multipartUploader interface {
+	putObject(ctx context.Context, key string, data []byte, meta map[string]string) error
+	initUpload(ctx context.Context, key string, meta map[string]string) (string, error)
+	uploadPart(ctx context.Context, key, uploadID string, partNumber int, data []byte) (string, error)
+	listParts(ctx context.Context, key, uploadID string) ([]uploadedPart, error)
+	completeUpload(ctx context.Context, key, uploadID string, parts []uploadedPart) error
+	abortUpload(ctx context.Context, key, uploadID string) error
+}
+
+// pendingUpload 是存储中未完成的分片上传
+type pendingUpload struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
+
+// multipartLister 是可以列出未完成分片上传的后端，cleanup-uploads 通过它清理中断的上传
+type multipartLister interface {
+	multipartUploader
+	listUploads(ctx context.Context, prefix string) ([]pendingUpload, error)
+}
+
+// metadataUpdater 是可以在对象写入后直接更新元数据的存储后端。流式上传时校验和要到传输结束才能得到，
+// 上传完成后再写入对象元数据。OSS、S3 只能通过复制整个对象更新元数据，不实现该接口，校验和只保存在 .sha256 对象中
+type metadataUpdater interface {
+	// updateMetadata 将 meta 合并到对象已有的元数据中
+	updateMetadata(ctx context.Context, key string, meta map[string]string) error
+}
+
+// objectCopier 是可以在服务端复制对象的存储后端，数据不经过本地
+type objectCopier interface {
+	// copyObject 将 src 连同元数据复制到 dst
+	copyObject(ctx context.Context, src, dst string) error
+}
+
+// copyObject 将 src 连同元数据复制到 dst，后端不支持服务端复制时读取后重新写入
+func copyObject(ctx context.Context, store Storage, src, dst string) error {
+	if c, ok := store.(objectCopier); ok {
+		return c.copyObject(ctx, src, dst)
+	}
+	info, err := store.Stat(ctx, src)
+	if err != nil {
+		return err
+	}
+	body, err := store.Get(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return store.Put(ctx, dst, body, info.Size, info.Metadata)
+}
+
+// mergeMetadata 返回 current 与 meta 合并后的元数据，meta 中的值优先
+func mergeMetadata(current, meta map[string]string) map[string]string {
+	merged := make(map[string]string, len(current)+len(meta))
+	for k, v := range current {
+		merged[k] = v
+	}
+	for k, v := range meta {
+		merged[k] = v
+	}
+	return merged
+}
+
+// multipartPut 按 partSize 切分 r 并分片上传，不足一个分片的数据直接普通上传。
+// 最多 concurrency 个分片同时上传，需要 (concurrency+1)*partSize 的内存。
+// ctx 携带上传断点时，每上传一个分片就更新断点，失败时保留分片上传以便下次续传，否则取消分片上传
+func multipartPut(ctx context.Context, u multipartUploader, key string, r io.Reader, partSize int64, concurrency int, meta map[string]string) error {
+	cp := checkpointFromContext(ctx)
+	buf := make([]byte, partSize)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		if err := retry(ctx, "上传对象", func() error {
+			return u.putObject(ctx, key, buf[:n], meta)
+		}); err != nil {
+			return err
+		}
+		if cp != nil {
+			if cp.UploadID != "" {
+				u.abortUpload(ctx, key, cp.UploadID)
+			}
+			cp.remove()
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取上传数据失败: %w", err)
+	}
+
+	// 初始化分片上传，有断点时沿用断点中的分片上传
+	var uploadID string
+	if cp != nil && cp.resume(ctx, u, partSize) {
+		uploadID = cp.UploadID
+	} else {
+		err = retry(ctx, "初始化分片上传", func() error {
+			uploadID, err = u.initUpload(ctx, key, meta)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("初始化分片上传失败: %w", err)
+		}
+		if cp != nil {
+			cp.UploadID, cp.PartSize = uploadID, partSize
+			if err := cp.save(); err != nil {
+				log(0, "保存断点失败: %v", err)
+			}
+		}
+	}
+	fail := func(err error) error {
+		if cp != nil && interrupted(ctx) && !keepInterruptedUploads {
+			// 没有明确开启断点续传时，Ctrl-C 中断的上传不保留，避免分片一直占用存储空间
+			cp.remove()
+			cp = nil
+		}
+		if cp == nil {
+			// ctx 可能已经取消（Ctrl-C 或超时），使用单独的 context 取消分片上传
+			abortCtx, cancel := cleanupContext()
+			defer cancel()
+			if abortErr := u.abortUpload(abortCtx, key, uploadID); abortErr != nil {
+				log(0, "取消分片上传 %s 失败，可以使用 cleanup-uploads 清理: %v", key, abortErr)
+			}
+		} else {
+			log(0, "上传 %s 中断，已上传 %d 个分片，重新运行相同的备份命令将从断点继续", key, len(cp.Parts))
+		}
+		return err
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	// slots 限制同时上传的分片数，buffers 是空闲的分片缓冲区，上传 concurrency 个分片的同时可以读取下一个分片
+	slots := make(chan struct{}, concurrency)
+	buffers := make(chan []byte, concurrency)
+	for i := 0; i < concurrency; i++ {
+		buffers <- make([]byte, partSize)
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		parts     []uploadedPart
+		uploadErr error
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return uploadErr != nil
+	}
+	var readErr error
+	for partNumber := 1; n > 0 && !failed(); partNumber++ {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(partNumber int, data []byte) {
+			defer wg.Done()
+			var etag string
+			var err error
+			if cp == nil {
+				err = retry(ctx, "上传分片", func() error {
+					etag, err = u.uploadPart(ctx, key, uploadID, partNumber, data)
+					return err
+				})
+			} else {
+				etag, err = uploadCheckpointPart(ctx, u, cp, partNumber, data)
+			}
+			mu.Lock()
+			if err != nil && uploadErr == nil {
+				uploadErr = fmt.Errorf("上传分片 %d 失败: %w", partNumber, err)
+			} else if err == nil {
+				parts = append(parts, uploadedPart{Number: partNumber, ETag: etag})
+			}
+			mu.Unlock()
+			buffers <- data[:cap(data)]
+			<-slots
+		}(partNumber, buf[:n])
+
+		buf = <-buffers
+		n, err = io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			readErr = fmt.Errorf("读取上传数据失败: %w", err)
+			break
+		}
+	}
+	wg.Wait()
+	if uploadErr != nil {
+		return fail(uploadErr)
+	}
+	if readErr != nil {
+		return fail(readErr)
+	}
+
+	// 完成分片上传，分片需要按编号排序
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].Number < parts[j].Number
+	})
+	err = retry(ctx, "完成分片上传", func() error {
+		return u.completeUpload(ctx, key, uploadID, parts)
+	})
+	if err != nil {
+		return fail(fmt.Errorf("完成分片上传失败: %w", err))
+	}
+	if cp != nil {
+		cp.remove()
+	}
+	return nil
+}
+
+// uploadCheckpointPart 上传一个分片并记录到断点中，断点中已有内容相同的分片时跳过上传
+func uploadCheckpointPart(ctx context.Context, u multipartUploader, cp *uploadCheckpoint, partNumber int, data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if part, ok := cp.part(partNumber); ok {
+		if part.Size == int64(len(data)) && part.SHA256 == hash {
+			log(2, "分片 %d 已上传，跳过", partNumber)
+			return part.ETag, nil
+		}
+		log(2, "分片 %d 的内容与断点不同，重新上传", partNumber)
+	}
+
+	var etag string
+	err := retry(ctx, "上传分片", func() error {
+		var err error
+		etag, err = u.uploadPart(ctx, cp.Key, cp.UploadID, partNumber, data)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	cp.setPart(checkpointPart{Number: partNumber, ETag: etag, Size: int64(len(data)), SHA256: hash})
+	return etag, nil
+}
@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// fileStorage 是本地目录存储后端，适用于挂载的 NAS/NFS 等无云凭证的场景。
+// 对象先写入同目录下的隐藏临时文件，完成后原子重命名；对象元数据保存在隐藏的 .<name>.meta 文件中
+type fileStorage struct {
+	root string
+}
+
+func newFileStorage(root string) (*fileStorage, error) {
+	if root == "" {
+		return nil, fmt.Errorf("未指定本地存储目录")
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("创建本地存储目录 %s 失败: %v", root, err)
+	}
+	return &fileStorage{root: root}, nil
+}
+
+// filePath 将对象 key 转换为本地路径，并拒绝指向存储目录之外的 key
+func (s *fileStorage) filePath(key string) (string, error) {
+	p := filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+key)))
+	if p == s.root {
+		return "", fmt.Errorf("非法的对象 key: %q", key)
+	}
+	return p, nil
+}
+
+func metaPath(p string) string {
+	return filepath.Join(filepath.Dir(p), "."+filepath.Base(p)+".meta")
+}
+
+func (s *fileStorage) Put(ctx context.Context, key string, r io.Reader, size int64, meta map[string]string) error {
+	p, err := s.filePath(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(p)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("创建目录 %s 失败: %v", dir, err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(p)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %v", err)
+	}
+	// 任一步骤失败都删除临时文件，成功重命名后 Remove 不会有任何效果
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("写入文件 %s 失败: %v", tmp.Name(), err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("同步文件 %s 失败: %v", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	if len(meta) > 0 {
+		data, err := json.Marshal(meta)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(metaPath(p), data, 0644); err != nil {
+			return fmt.Errorf("写入元数据失败: %v", err)
+		}
+	} else {
+		os.Remove(metaPath(p))
+	}
+
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return fmt.Errorf("重命名 %s 失败: %v", tmp.Name(), err)
+	}
+	return nil
+}
+
+func (s *fileStorage) updateMetadata(ctx context.Context, key string, meta map[string]string) error {
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+	p, err := s.filePath(key)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(mergeMetadata(info.Metadata, meta))
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(metaPath(p), data, 0644); err != nil {
+		return fmt.Errorf("写入元数据失败: %v", err)
+	}
+	return nil
+}
+
+func (s *fileStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.filePath(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrObjectNotFound
+	}
+	return f, err
+}
+
+func (s *fileStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	// 从 prefix 中最深的目录开始遍历，再按字符串前缀过滤
+	start := s.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir, err := s.filePath(prefix[:i])
+		if err != nil {
+			return nil, err
+		}
+		start = dir
+	}
+
+	var objects []ObjectInfo
+	err := filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		// 跳过隐藏的临时文件和元数据文件
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          key,
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+func (s *fileStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	p, err := s.filePath(key)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, ErrObjectNotFound
+	}
+
+	info := &ObjectInfo{
+		Key:          key,
+		Size:         fi.Size(),
+		LastModified: fi.ModTime(),
+		Metadata:     map[string]string{},
+	}
+	if data, err := os.ReadFile(metaPath(p)); err == nil {
+		if err := json.Unmarshal(data, &info.Metadata); err != nil {
+			return nil, fmt.Errorf("解析元数据 %s 失败: %v", metaPath(p), err)
+		}
+	}
+	return info, nil
+}
+
+func (s *fileStorage) Delete(ctx context.Context, key string) error {
+	p, err := s.filePath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	os.Remove(metaPath(p))
+
+	// 清理删除后留下的空目录，目录非空时 Remove 会失败并停止
+	for dir := filepath.Dir(p); dir != s.root && strings.HasPrefix(dir, s.root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *fileStorage) URL(key string) string {
+	p, err := s.filePath(key)
+	if err != nil {
+		return "file://" + s.root
+	}
+	return "file://" + filepath.ToSlash(p)
+}
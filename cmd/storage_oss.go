@@ -0,0 +1,290 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+)
+
+// ossStorage 是阿里云 OSS 存储后端
+type ossStorage struct {
+	bucket   *oss.Bucket
+	name     string // bucket 名称，可带多级前缀，如 iotdb-backup/ems
+	prefix   string
+	endpoint string
+	partSize int64
+	// 同时上传的分片数
+	concurrency int
+}
+
+func newOSSStorage(bucketPath, endpoint string, creds *credentialProvider, partSize int64, concurrency int) (*ossStorage, error) {
+	if bucketPath == "" {
+		return nil, fmt.Errorf("未指定 OSS bucket 名称")
+	}
+	client, err := oss.New(endpoint, "", "", oss.SetCredentialsProvider(creds))
+	if err != nil {
+		return nil, fmt.Errorf("创建 OSS 客户端失败: %v", err)
+	}
+
+	// 支持多级 bucketname，第一级为 bucket，其余部分作为对象前缀
+	name, prefix := bucketPath, ""
+	if i := strings.Index(bucketPath, "/"); i >= 0 {
+		name, prefix = bucketPath[:i], strings.Trim(bucketPath[i+1:], "/")
+	}
+	bucket, err := client.Bucket(name)
+	if err != nil {
+		return nil, fmt.Errorf("打开 OSS bucket %s 失败: %v", name, err)
+	}
+
+	if partSize <= 0 {
+		partSize = 10 * 1024 * 1024
+	}
+	return &ossStorage{
+		bucket:      bucket,
+		name:        bucketPath,
+		prefix:      prefix,
+		endpoint:    endpoint,
+		partSize:    partSize,
+		concurrency: concurrency,
+	}, nil
+}
+
+// objectKey 返回加上前缀后的对象 key，保留 key 末尾的 /，列出 prod/ 时不会列出 production/ 下的对象
+func (s *ossStorage) objectKey(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	if strings.HasSuffix(key, "/") {
+		return path.Join(s.prefix, key) + "/"
+	}
+	return path.Join(s.prefix, key)
+}
+
+func (s *ossStorage) Put(ctx context.Context, key string, r io.Reader, size int64, meta map[string]string) error {
+	return multipartPut(ctx, s, key, r, s.partSize, s.concurrency, meta)
+}
+
+func (s *ossStorage) putOptions(ctx context.Context, meta map[string]string) []oss.Option {
+	options := []oss.Option{oss.WithContext(ctx)}
+	for k, v := range meta {
+		options = append(options, oss.Meta(k, v))
+	}
+	return options
+}
+
+func (s *ossStorage) putObject(ctx context.Context, key string, data []byte, meta map[string]string) error {
+	return s.bucket.PutObject(s.objectKey(key), uploadReader(ctx, data), s.putOptions(ctx, meta)...)
+}
+
+func (s *ossStorage) initUpload(ctx context.Context, key string, meta map[string]string) (string, error) {
+	imur, err := s.bucket.InitiateMultipartUpload(s.objectKey(key), s.putOptions(ctx, meta)...)
+	if err != nil {
+		return "", err
+	}
+	return imur.UploadID, nil
+}
+
+func (s *ossStorage) multipartUpload(key, uploadID string) oss.InitiateMultipartUploadResult {
+	return oss.InitiateMultipartUploadResult{
+		Bucket:   s.bucket.BucketName,
+		Key:      s.objectKey(key),
+		UploadID: uploadID,
+	}
+}
+
+func (s *ossStorage) uploadPart(ctx context.Context, key, uploadID string, partNumber int, data []byte) (string, error) {
+	part, err := s.bucket.UploadPart(s.multipartUpload(key, uploadID), uploadReader(ctx, data), int64(len(data)), partNumber, oss.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	return part.ETag, nil
+}
+
+func (s *ossStorage) listParts(ctx context.Context, key, uploadID string) ([]uploadedPart, error) {
+	var parts []uploadedPart
+	marker := 0
+	for {
+		result, err := s.bucket.ListUploadedParts(s.multipartUpload(key, uploadID), oss.PartNumberMarker(marker), oss.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range result.UploadedParts {
+			parts = append(parts, uploadedPart{Number: part.PartNumber, ETag: part.ETag})
+		}
+		if !result.IsTruncated {
+			return parts, nil
+		}
+		if marker, err = strconv.Atoi(result.NextPartNumberMarker); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *ossStorage) completeUpload(ctx context.Context, key, uploadID string, parts []uploadedPart) error {
+	ossParts := make([]oss.UploadPart, 0, len(parts))
+	for _, part := range parts {
+		ossParts = append(ossParts, oss.UploadPart{PartNumber: part.Number, ETag: part.ETag})
+	}
+	_, err := s.bucket.CompleteMultipartUpload(s.multipartUpload(key, uploadID), ossParts, oss.WithContext(ctx))
+	return err
+}
+
+func (s *ossStorage) abortUpload(ctx context.Context, key, uploadID string) error {
+	return s.bucket.AbortMultipartUpload(s.multipartUpload(key, uploadID), oss.WithContext(ctx))
+}
+
+func (s *ossStorage) listUploads(ctx context.Context, prefix string) ([]pendingUpload, error) {
+	listPrefix := s.objectKey(prefix)
+	if s.prefix != "" && prefix == "" {
+		listPrefix += "/"
+	}
+
+	var uploads []pendingUpload
+	keyMarker, uploadIDMarker := "", ""
+	for {
+		result, err := s.bucket.ListMultipartUploads(oss.Prefix(listPrefix), oss.KeyMarker(keyMarker),
+			oss.UploadIDMarker(uploadIDMarker), oss.MaxUploads(1000), oss.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		for _, upload := range result.Uploads {
+			key := upload.Key
+			if s.prefix != "" {
+				key = strings.TrimPrefix(key, s.prefix+"/")
+			}
+			uploads = append(uploads, pendingUpload{Key: key, UploadID: upload.UploadID, Initiated: upload.Initiated})
+		}
+		if !result.IsTruncated {
+			return uploads, nil
+		}
+		keyMarker, uploadIDMarker = result.NextKeyMarker, result.NextUploadIDMarker
+	}
+}
+
+func (s *ossStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	var body io.ReadCloser
+	err := retry(ctx, "下载对象", func() (err error) {
+		body, err = s.bucket.GetObject(s.objectKey(key), oss.WithContext(ctx))
+		return err
+	})
+	if err != nil {
+		if isOSSNotFound(err) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+	return body, nil
+}
+
+func (s *ossStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	listPrefix := s.objectKey(prefix)
+	if s.prefix != "" && prefix == "" {
+		listPrefix += "/"
+	}
+
+	var objects []ObjectInfo
+	token := ""
+	for {
+		options := []oss.Option{oss.Prefix(listPrefix), oss.MaxKeys(1000), oss.WithContext(ctx)}
+		if token != "" {
+			options = append(options, oss.ContinuationToken(token))
+		}
+		var result oss.ListObjectsResultV2
+		err := retry(ctx, "列出对象", func() (err error) {
+			result, err = s.bucket.ListObjectsV2(options...)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range result.Objects {
+			key := object.Key
+			if s.prefix != "" {
+				key = strings.TrimPrefix(key, s.prefix+"/")
+			}
+			objects = append(objects, ObjectInfo{
+				Key:          key,
+				Size:         object.Size,
+				LastModified: object.LastModified,
+			})
+		}
+		if !result.IsTruncated {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *ossStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	var header http.Header
+	err := retry(ctx, "查询对象", func() (err error) {
+		header, err = s.bucket.GetObjectDetailedMeta(s.objectKey(key), oss.WithContext(ctx))
+		return err
+	})
+	if err != nil {
+		if isOSSNotFound(err) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+
+	info := &ObjectInfo{Key: key, Metadata: map[string]string{}}
+	info.Size, _ = strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	info.LastModified, _ = http.ParseTime(header.Get("Last-Modified"))
+	for name := range header {
+		if strings.HasPrefix(name, oss.HTTPHeaderOssMetaPrefix) {
+			info.Metadata[strings.ToLower(strings.TrimPrefix(name, oss.HTTPHeaderOssMetaPrefix))] = header.Get(name)
+		}
+	}
+	return info, nil
+}
+
+// ossCopyObjectLimit 是 CopyObject 能复制的最大对象，更大的对象需要分片复制
+const ossCopyObjectLimit = 1 << 30
+
+// copyObject 在 OSS 服务端复制对象，超过 1GiB 的对象分片复制
+func (s *ossStorage) copyObject(ctx context.Context, src, dst string) error {
+	info, err := s.Stat(ctx, src)
+	if err != nil {
+		return err
+	}
+	srcKey, dstKey := s.objectKey(src), s.objectKey(dst)
+	return retry(ctx, "复制对象", func() error {
+		if info.Size <= ossCopyObjectLimit {
+			_, err := s.bucket.CopyObject(srcKey, dstKey, oss.WithContext(ctx))
+			return err
+		}
+		// 分片数不能超过 10000，分片复制不会复制元数据，需要重新指定
+		partSize := info.Size/9000 + 1
+		if partSize < 100*1024*1024 {
+			partSize = 100 * 1024 * 1024
+		}
+		options := []oss.Option{oss.WithContext(ctx), oss.Routines(s.concurrency)}
+		for k, v := range info.Metadata {
+			options = append(options, oss.Meta(k, v))
+		}
+		return s.bucket.CopyFile(s.bucket.BucketName, srcKey, dstKey, partSize, options...)
+	})
+}
+
+func (s *ossStorage) Delete(ctx context.Context, key string) error {
+	return retry(ctx, "删除对象", func() error {
+		return s.bucket.DeleteObject(s.objectKey(key), oss.WithContext(ctx))
+	})
+}
+
+func (s *ossStorage) URL(key string) string {
+	return constructOSSURL(s.endpoint, s.name, key)
+}
+
+func isOSSNotFound(err error) bool {
+	var serviceErr oss.ServiceError
+	return errors.As(err, &serviceErr) && serviceErr.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,290 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3Storage 是 S3 协议存储后端，适用于 AWS S3、MinIO、Ceph RGW 等
+type s3Storage struct {
+	client   *minio.Core
+	bucket   string
+	prefix   string
+	endpoint string
+	secure   bool
+	partSize int64
+	// 同时上传的分片数
+	concurrency int
+}
+
+// newS3Storage 根据 s3://bucket/prefix?endpoint=...&region=...&path-style=true&insecure=true 创建 S3 存储。
+// creds 为 nil 时匿名访问
+func newS3Storage(u *url.URL, creds *credentialProvider, partSize int64, concurrency int) (*s3Storage, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("未指定 S3 bucket 名称")
+	}
+	query := u.Query()
+
+	endpoint := query.Get("endpoint")
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	}
+	secure := true
+	if insecure, _ := strconv.ParseBool(query.Get("insecure")); insecure {
+		secure = false
+	}
+	// endpoint 中的协议优先于 insecure 参数
+	if strings.HasPrefix(endpoint, "http://") {
+		secure = false
+	}
+	endpoint = strings.TrimPrefix(strings.TrimPrefix(endpoint, "http://"), "https://")
+
+	lookup := minio.BucketLookupAuto
+	if pathStyle, _ := strconv.ParseBool(query.Get("path-style")); pathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	cred := credentials.NewStaticV4("", "", "")
+	if creds != nil {
+		cred = credentials.New(minioProvider{creds})
+	} else {
+		log(1, "没有找到 S3 凭证，匿名访问")
+	}
+
+	client, err := minio.NewCore(endpoint, &minio.Options{
+		Creds:        cred,
+		Secure:       secure,
+		Region:       query.Get("region"),
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("创建 S3 客户端失败: %v", err)
+	}
+
+	// S3 要求除最后一个分片外每个分片不小于 5MB
+	if partSize < 5*1024*1024 {
+		partSize = 5 * 1024 * 1024
+	}
+	return &s3Storage{
+		client:      client,
+		bucket:      u.Host,
+		prefix:      strings.Trim(u.Path, "/"),
+		endpoint:    endpoint,
+		secure:      secure,
+		partSize:    partSize,
+		concurrency: concurrency,
+	}, nil
+}
+
+// objectKey 返回加上前缀后的对象 key，保留 key 末尾的 /，列出 prod/ 时不会列出 production/ 下的对象
+func (s *s3Storage) objectKey(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	if strings.HasSuffix(key, "/") {
+		return path.Join(s.prefix, key) + "/"
+	}
+	return path.Join(s.prefix, key)
+}
+
+func (s *s3Storage) Put(ctx context.Context, key string, r io.Reader, size int64, meta map[string]string) error {
+	return multipartPut(ctx, s, key, r, s.partSize, s.concurrency, meta)
+}
+
+func (s *s3Storage) putObject(ctx context.Context, key string, data []byte, meta map[string]string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, s.objectKey(key), uploadReader(ctx, data), int64(len(data)), "", "",
+		minio.PutObjectOptions{UserMetadata: meta})
+	return err
+}
+
+func (s *s3Storage) initUpload(ctx context.Context, key string, meta map[string]string) (string, error) {
+	return s.client.NewMultipartUpload(ctx, s.bucket, s.objectKey(key), minio.PutObjectOptions{UserMetadata: meta})
+}
+
+func (s *s3Storage) uploadPart(ctx context.Context, key, uploadID string, partNumber int, data []byte) (string, error) {
+	part, err := s.client.PutObjectPart(ctx, s.bucket, s.objectKey(key), uploadID, partNumber,
+		uploadReader(ctx, data), int64(len(data)), minio.PutObjectPartOptions{})
+	if err != nil {
+		return "", err
+	}
+	return part.ETag, nil
+}
+
+func (s *s3Storage) listParts(ctx context.Context, key, uploadID string) ([]uploadedPart, error) {
+	var parts []uploadedPart
+	marker := 0
+	for {
+		result, err := s.client.ListObjectParts(ctx, s.bucket, s.objectKey(key), uploadID, marker, 1000)
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range result.ObjectParts {
+			parts = append(parts, uploadedPart{Number: part.PartNumber, ETag: part.ETag})
+		}
+		if !result.IsTruncated {
+			return parts, nil
+		}
+		marker = result.NextPartNumberMarker
+	}
+}
+
+func (s *s3Storage) completeUpload(ctx context.Context, key, uploadID string, parts []uploadedPart) error {
+	completeParts := make([]minio.CompletePart, 0, len(parts))
+	for _, part := range parts {
+		completeParts = append(completeParts, minio.CompletePart{PartNumber: part.Number, ETag: part.ETag})
+	}
+	_, err := s.client.CompleteMultipartUpload(ctx, s.bucket, s.objectKey(key), uploadID, completeParts, minio.PutObjectOptions{})
+	return err
+}
+
+func (s *s3Storage) abortUpload(ctx context.Context, key, uploadID string) error {
+	return s.client.AbortMultipartUpload(ctx, s.bucket, s.objectKey(key), uploadID)
+}
+
+func (s *s3Storage) listUploads(ctx context.Context, prefix string) ([]pendingUpload, error) {
+	listPrefix := s.objectKey(prefix)
+	if s.prefix != "" && prefix == "" {
+		listPrefix += "/"
+	}
+
+	var uploads []pendingUpload
+	keyMarker, uploadIDMarker := "", ""
+	for {
+		result, err := s.client.ListMultipartUploads(ctx, s.bucket, listPrefix, keyMarker, uploadIDMarker, "", 1000)
+		if err != nil {
+			return nil, err
+		}
+		for _, upload := range result.Uploads {
+			key := upload.Key
+			if s.prefix != "" {
+				key = strings.TrimPrefix(key, s.prefix+"/")
+			}
+			uploads = append(uploads, pendingUpload{Key: key, UploadID: upload.UploadID, Initiated: upload.Initiated})
+		}
+		if !result.IsTruncated {
+			return uploads, nil
+		}
+		keyMarker, uploadIDMarker = result.NextKeyMarker, result.NextUploadIDMarker
+	}
+}
+
+func (s *s3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	var body io.ReadCloser
+	err := retry(ctx, "下载对象", func() (err error) {
+		body, _, _, err = s.client.GetObject(ctx, s.bucket, s.objectKey(key), minio.GetObjectOptions{})
+		return err
+	})
+	if err != nil {
+		if isS3NotFound(err) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+	return body, nil
+}
+
+func (s *s3Storage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := retry(ctx, "列出对象", func() (err error) {
+		objects, err = s.list(ctx, prefix)
+		return err
+	})
+	return objects, err
+}
+
+func (s *s3Storage) list(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	listPrefix := s.objectKey(prefix)
+	if s.prefix != "" && prefix == "" {
+		listPrefix += "/"
+	}
+
+	var objects []ObjectInfo
+	for object := range s.client.Client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: listPrefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		key := object.Key
+		if s.prefix != "" {
+			key = strings.TrimPrefix(key, s.prefix+"/")
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          key,
+			Size:         object.Size,
+			LastModified: object.LastModified,
+		})
+	}
+	return objects, nil
+}
+
+func (s *s3Storage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	var object minio.ObjectInfo
+	err := retry(ctx, "查询对象", func() (err error) {
+		object, err = s.client.StatObject(ctx, s.bucket, s.objectKey(key), minio.StatObjectOptions{})
+		return err
+	})
+	if err != nil {
+		if isS3NotFound(err) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+
+	info := &ObjectInfo{
+		Key:          key,
+		Size:         object.Size,
+		LastModified: object.LastModified,
+		Metadata:     map[string]string{},
+	}
+	for name, value := range object.UserMetadata {
+		info.Metadata[strings.ToLower(name)] = value
+	}
+	return info, nil
+}
+
+// s3CopyObjectLimit 是 CopyObject 能复制的最大对象，更大的对象需要分片复制
+const s3CopyObjectLimit = 5 << 30
+
+// copyObject 在服务端复制对象，超过 5GiB 的对象由 ComposeObject 分片复制
+func (s *s3Storage) copyObject(ctx context.Context, src, dst string) error {
+	info, err := s.Stat(ctx, src)
+	if err != nil {
+		return err
+	}
+	dstOptions := minio.CopyDestOptions{Bucket: s.bucket, Object: s.objectKey(dst)}
+	srcOptions := minio.CopySrcOptions{Bucket: s.bucket, Object: s.objectKey(src)}
+	return retry(ctx, "复制对象", func() error {
+		if info.Size <= s3CopyObjectLimit {
+			_, err := s.client.Client.CopyObject(ctx, dstOptions, srcOptions)
+			return err
+		}
+		_, err := s.client.ComposeObject(ctx, dstOptions, srcOptions)
+		return err
+	})
+}
+
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	return retry(ctx, "删除对象", func() error {
+		return s.client.RemoveObject(ctx, s.bucket, s.objectKey(key), minio.RemoveObjectOptions{})
+	})
+}
+
+func (s *s3Storage) URL(key string) string {
+	scheme := "https"
+	if !s.secure {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/%s/%s", scheme, s.endpoint, s.bucket, s.objectKey(key))
+}
+
+func isS3NotFound(err error) bool {
+	return minio.ToErrorResponse(err).StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,357 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const fakeS3Bucket = "iotdb-backup"
+
+type fakeS3Object struct {
+	data    []byte
+	meta    map[string]string
+	modTime time.Time
+}
+
+type fakeS3Upload struct {
+	key   string
+	meta  map[string]string
+	parts map[int][]byte
+}
+
+// fakeS3 是一个只支持 path-style 和 s3Storage 用到的接口的内存 S3 服务，不校验签名
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]*fakeS3Object
+	uploads map[string]*fakeS3Upload
+	nextID  int
+	// completed 记录完成的分片上传的分片数
+	completed []int
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{objects: map[string]*fakeS3Object{}, uploads: map[string]*fakeS3Upload{}}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func userMetadata(header http.Header) map[string]string {
+	meta := map[string]string{}
+	for name := range header {
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") {
+			meta[strings.ToLower(strings.TrimPrefix(strings.ToLower(name), "x-amz-meta-"))] = header.Get(name)
+		}
+	}
+	return meta
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(v)
+}
+
+type fakeS3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string
+	Message string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != fakeS3Bucket {
+		writeXML(w, http.StatusNotFound, fakeS3Error{Code: "NoSuchBucket", Message: bucket})
+		return
+	}
+	query := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		f.listObjects(w, query.Get("prefix"))
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.nextID++
+		id := strconv.Itoa(f.nextID)
+		f.uploads[id] = &fakeS3Upload{key: key, meta: userMetadata(r.Header), parts: map[int][]byte{}}
+		writeXML(w, http.StatusOK, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: bucket, Key: key, UploadId: id})
+	case query.Has("uploadId"):
+		f.multipart(w, r, key, query, body)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		source, _ := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+		src, ok := f.objects[strings.TrimPrefix(strings.TrimPrefix(source, "/"), fakeS3Bucket+"/")]
+		if !ok {
+			writeXML(w, http.StatusNotFound, fakeS3Error{Code: "NoSuchKey", Message: source})
+			return
+		}
+		meta := src.meta
+		if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+			meta = userMetadata(r.Header)
+		}
+		f.objects[key] = &fakeS3Object{data: src.data, meta: meta, modTime: time.Now().UTC()}
+		writeXML(w, http.StatusOK, struct {
+			XMLName      xml.Name `xml:"CopyObjectResult"`
+			ETag         string
+			LastModified string
+		}{ETag: etag(src.data), LastModified: time.Now().UTC().Format(time.RFC3339)})
+	case r.Method == http.MethodPut:
+		f.objects[key] = &fakeS3Object{data: body, meta: userMetadata(r.Header), modTime: time.Now().UTC()}
+		w.Header().Set("ETag", etag(body))
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		object, ok := f.objects[key]
+		if !ok {
+			writeXML(w, http.StatusNotFound, fakeS3Error{Code: "NoSuchKey", Message: key})
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+		w.Header().Set("Last-Modified", object.modTime.Format(http.TimeFormat))
+		w.Header().Set("ETag", etag(object.data))
+		for k, v := range object.meta {
+			w.Header().Set("X-Amz-Meta-"+k, v)
+		}
+		if r.Method == http.MethodGet {
+			w.Write(object.data)
+		}
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeXML(w, http.StatusNotImplemented, fakeS3Error{Code: "NotImplemented", Message: r.Method + " " + r.URL.String()})
+	}
+}
+
+func (f *fakeS3) listObjects(w http.ResponseWriter, prefix string) {
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+		StorageClass string
+	}
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var contents []content
+	for _, key := range keys {
+		object := f.objects[key]
+		contents = append(contents, content{
+			Key:          key,
+			LastModified: object.modTime.Format("2006-01-02T15:04:05.000Z"),
+			ETag:         etag(object.data),
+			Size:         len(object.data),
+			StorageClass: "STANDARD",
+		})
+	}
+	writeXML(w, http.StatusOK, struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		MaxKeys     int
+		IsTruncated bool
+		Contents    []content
+	}{Name: fakeS3Bucket, Prefix: prefix, KeyCount: len(contents), MaxKeys: 1000, Contents: contents})
+}
+
+func (f *fakeS3) multipart(w http.ResponseWriter, r *http.Request, key string, query url.Values, body []byte) {
+	upload, ok := f.uploads[query.Get("uploadId")]
+	if !ok || upload.key != key {
+		writeXML(w, http.StatusNotFound, fakeS3Error{Code: "NoSuchUpload", Message: query.Get("uploadId")})
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		upload.parts[number] = body
+		w.Header().Set("ETag", etag(body))
+	case http.MethodGet:
+		type part struct {
+			PartNumber int
+			ETag       string
+			Size       int
+		}
+		var parts []part
+		for number, data := range upload.parts {
+			parts = append(parts, part{PartNumber: number, ETag: etag(data), Size: len(data)})
+		}
+		sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+		writeXML(w, http.StatusOK, struct {
+			XMLName     xml.Name `xml:"ListPartsResult"`
+			Bucket      string
+			Key         string
+			UploadId    string
+			IsTruncated bool
+			Part        []part
+		}{Bucket: fakeS3Bucket, Key: key, UploadId: query.Get("uploadId"), Part: parts})
+	case http.MethodPost:
+		var complete struct {
+			Part []struct {
+				PartNumber int
+				ETag       string
+			}
+		}
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			writeXML(w, http.StatusBadRequest, fakeS3Error{Code: "MalformedXML", Message: err.Error()})
+			return
+		}
+		var data bytes.Buffer
+		for _, p := range complete.Part {
+			part, ok := upload.parts[p.PartNumber]
+			if !ok || strings.Trim(etag(part), `"`) != strings.Trim(p.ETag, `"`) {
+				writeXML(w, http.StatusBadRequest, fakeS3Error{Code: "InvalidPart", Message: strconv.Itoa(p.PartNumber)})
+				return
+			}
+			data.Write(part)
+		}
+		f.objects[key] = &fakeS3Object{data: data.Bytes(), meta: upload.meta, modTime: time.Now().UTC()}
+		f.completed = append(f.completed, len(complete.Part))
+		delete(f.uploads, query.Get("uploadId"))
+		writeXML(w, http.StatusOK, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+			ETag    string
+		}{Bucket: fakeS3Bucket, Key: key, ETag: etag(data.Bytes())})
+	case http.MethodDelete:
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// newTestS3Storage 返回以 fakeS3 为后端、前缀为 backups 的 s3Storage
+func newTestS3Storage(t *testing.T) (*s3Storage, *fakeS3) {
+	f, server := newFakeS3(t)
+	endpoint := strings.TrimPrefix(server.URL, "http://")
+	u, err := url.Parse(fmt.Sprintf("s3://%s/backups?endpoint=%s&insecure=true&path-style=true&region=us-east-1", fakeS3Bucket, endpoint))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := newS3Storage(u, nil, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, f
+}
+
+func TestS3Storage(t *testing.T) {
+	savedRetry := retryPolicy
+	t.Cleanup(func() { retryPolicy = savedRetry })
+	retryPolicy.MaxAttempts = 1
+	ctx := context.Background()
+	store, f := newTestS3Storage(t)
+
+	// 大于分片大小（最小 5MB）的对象分片上传，大小未知时也按分片切分
+	large := bytes.Repeat([]byte("0123456789abcdef"), (2*int(store.partSize)+1024)/16)
+	if err := store.Put(ctx, "prod/iotdb/large.tar.gz", bytes.NewReader(large), -1, map[string]string{metaSHA256: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.completed) != 1 || f.completed[0] != 3 {
+		t.Fatalf("预期 1 次 3 个分片的分片上传，实际: %v", f.completed)
+	}
+	if _, ok := f.objects["backups/prod/iotdb/large.tar.gz"]; !ok {
+		t.Fatal("对象没有写入 --storage 中的前缀下")
+	}
+	if err := store.Put(ctx, "prod/iotdb/small.json", strings.NewReader("{}"), 2, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.completed) != 1 {
+		t.Fatalf("小对象不应分片上传: %v", f.completed)
+	}
+	if err := store.Put(ctx, "production/iotdb/other.json", strings.NewReader("{}"), 2, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := store.Get(ctx, "prod/iotdb/large.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || !bytes.Equal(data, large) {
+		t.Fatalf("读取的内容与写入的不同: %d 字节, %v", len(data), err)
+	}
+
+	info, err := store.Stat(ctx, "prod/iotdb/large.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len(large)) || info.Metadata[metaSHA256] != "abc" || info.LastModified.IsZero() {
+		t.Fatalf("对象信息: %+v", info)
+	}
+
+	// 只列出 prod/ 下的对象，不包括 production/
+	objects, err := store.List(ctx, "prod/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	if strings.Join(keys, ",") != "prod/iotdb/large.tar.gz,prod/iotdb/small.json" {
+		t.Fatalf("列出的对象: %v", keys)
+	}
+
+	if err := store.Delete(ctx, "prod/iotdb/large.tar.gz"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Stat(ctx, "prod/iotdb/large.tar.gz"); err != ErrObjectNotFound {
+		t.Fatalf("删除后 Stat 预期 ErrObjectNotFound，实际: %v", err)
+	}
+	if _, err := store.Get(ctx, "prod/iotdb/large.tar.gz"); err != ErrObjectNotFound {
+		t.Fatalf("删除后 Get 预期 ErrObjectNotFound，实际: %v", err)
+	}
+	// 删除不存在的对象不报错
+	if err := store.Delete(ctx, "prod/iotdb/missing"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestS3StorageCopyObject(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestS3Storage(t)
+	if err := store.Put(ctx, "blobs/tmp/a", strings.NewReader("blob"), 4, map[string]string{blobMetaCompression: compressionZstd}); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyObject(ctx, store, "blobs/tmp/a", "blobs/a"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := store.Stat(ctx, "blobs/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 4 || info.Metadata[blobMetaCompression] != compressionZstd {
+		t.Fatalf("复制后的对象信息: %+v", info)
+	}
+}
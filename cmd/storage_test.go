@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// saveStorageFlags 在测试结束后恢复存储和凭证相关的全局参数
+func saveStorageFlags(t *testing.T) {
+	savedStorage, savedBucket, savedChunkSize, savedConcurrency := storageURL, bucketName, chunkSize, uploadConcurrency
+	savedSecret, savedCommand, savedFile, savedRAMRole := credentialsSecret, credentialsCommand, credentialsFile, ramRole
+	savedConfigPath, savedContext, savedLoaded := configPath, kubeContext, loadedConfig
+	t.Cleanup(func() {
+		storageURL, bucketName, chunkSize, uploadConcurrency = savedStorage, savedBucket, savedChunkSize, savedConcurrency
+		credentialsSecret, credentialsCommand, credentialsFile, ramRole = savedSecret, savedCommand, savedFile, savedRAMRole
+		configPath, kubeContext, loadedConfig = savedConfigPath, savedContext, savedLoaded
+	})
+}
+
+func TestStorageFlagsRegistered(t *testing.T) {
+	// 所有读写存储的命令都有存储、凭证和 kubeconfig 参数，--credentials-secret 需要访问 Kubernetes
+	names := []string{"storage", "bucketname", "chunksize", "credentials-secret", "credentials-command",
+		"credentials-file", "ram-role", "kubeconfig", "context", "as", "kube-qps"}
+	for _, use := range []string{"backup", "daemon", "restore", "list", "prune", "verify", "cleanup-uploads"} {
+		cmd, _, err := rootCmd.Find([]string{use})
+		if err != nil || cmd.Name() != use {
+			t.Fatalf("没有找到命令 %s: %v", use, err)
+		}
+		for _, name := range names {
+			if cmd.Flags().Lookup(name) == nil {
+				t.Errorf("%s 没有 --%s 参数", use, name)
+			}
+		}
+	}
+}
+
+func TestStorageFlagsParse(t *testing.T) {
+	saveStorageFlags(t)
+	cmd := &cobra.Command{Use: "test"}
+	addStorageFlags(cmd)
+	if effectiveStorageURL() != "oss://iotdb-backup" {
+		t.Fatalf("默认存储地址: %s", effectiveStorageURL())
+	}
+
+	err := cmd.ParseFlags([]string{"-b", "prod-backup", "--chunksize", "1048576", "--credentials-secret", "ops/backup",
+		"--kubeconfig", "/etc/prod.kubeconfig", "--context", "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bucketName != "prod-backup" || chunkSize != 1<<20 || credentialsSecret != "ops/backup" ||
+		configPath != "/etc/prod.kubeconfig" || kubeContext != "prod" {
+		t.Fatalf("参数没有写入全局变量: %s %d %s %s %s", bucketName, chunkSize, credentialsSecret, configPath, kubeContext)
+	}
+	// 未指定 --storage 时使用 --bucketname 对应的 OSS
+	if effectiveStorageURL() != "oss://prod-backup" {
+		t.Fatalf("存储地址: %s", effectiveStorageURL())
+	}
+	if err := cmd.ParseFlags([]string{"--storage", "s3://backup/prod"}); err != nil {
+		t.Fatal(err)
+	}
+	if effectiveStorageURL() != "s3://backup/prod" {
+		t.Fatalf("--storage 应优先于 --bucketname: %s", effectiveStorageURL())
+	}
+}
+
+func TestOpenStorageURL(t *testing.T) {
+	saveStorageFlags(t)
+	credentialsSecret, credentialsCommand, ramRole, loadedConfig = "", "", "", nil
+	credentialsFile = filepath.Join(t.TempDir(), "missing")
+	chunkSize, uploadConcurrency = 1<<20, 4
+	for _, env := range []string{"ALIBABA_CLOUD_ACCESS_KEY_ID", "OSS_ACCESS_KEY_ID", "OSS_ENDPOINT"} {
+		t.Setenv(env, "")
+	}
+	t.Setenv("AWS_ACCESS_KEY_ID", "ak")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "sk")
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	store, err := openStorageURL(ctx, "file://"+dir, nil, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs, ok := store.(*fileStorage); !ok || fs.root != dir {
+		t.Fatalf("file 存储: %#v", store)
+	}
+
+	store, err = openStorageURL(ctx, "s3://backup/prod/?endpoint=http://minio:9000&path-style=true", nil, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3, ok := store.(*s3Storage)
+	if !ok {
+		t.Fatalf("s3 存储: %#v", store)
+	}
+	// 分片大小不小于 S3 的下限，并发数来自 --upload-concurrency
+	if s3.bucket != "backup" || s3.prefix != "prod" || s3.endpoint != "minio:9000" || s3.secure ||
+		s3.partSize != 5<<20 || s3.concurrency != 4 {
+		t.Fatalf("s3 存储的配置: %+v", s3)
+	}
+
+	tests := []struct {
+		url string
+		err string
+	}{
+		{"oss://backup", "OSS 凭证"},
+		{"gs://backup", "不支持的存储类型"},
+		{"s3://", "bucket"},
+		{"://backup", "无法解析"},
+	}
+	for _, tt := range tests {
+		if _, err := openStorageURL(ctx, tt.url, nil, namespace); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: 预期包含 %q 的错误，实际: %v", tt.url, tt.err, err)
+		}
+	}
+
+	// 有 OSS 凭证但没有 endpoint
+	t.Setenv("ALIBABA_CLOUD_ACCESS_KEY_ID", "ak")
+	t.Setenv("ALIBABA_CLOUD_ACCESS_KEY_SECRET", "sk")
+	if _, err := openStorageURL(ctx, "oss://backup", nil, namespace); err == nil || !strings.Contains(err.Error(), "endpoint") {
+		t.Fatalf("预期缺少 endpoint 的错误，实际: %v", err)
+	}
+}
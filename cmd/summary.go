@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+)
+
+// RunSummary 汇总一次备份运行中所有 pod 的结果，用于运行结束后的通知，也作为通知模板的数据
+type RunSummary struct {
+	RunID     string      `json:"runId,omitempty"`
+	Cluster   string      `json:"cluster"`
+	Namespace string      `json:"namespace"`
+	Storage   string      `json:"storage,omitempty"`
+	Status    string      `json:"status"`
+	Error     string      `json:"error,omitempty"`
+	StartTime time.Time   `json:"startTime"`
+	EndTime   time.Time   `json:"endTime"`
+	Succeeded int         `json:"succeeded"`
+	Failed    int         `json:"failed"`
+	Size      int64       `json:"size"`
+	Pods      []PodResult `json:"pods"`
+}
+
+// PodResult 是一个 pod 容器的备份结果
+type PodResult struct {
+	Pod       string        `json:"pod"`
+	Container string        `json:"container,omitempty"`
+	Status    string        `json:"status"`
+	Size      int64         `json:"size"`
+	Duration  time.Duration `json:"duration"`
+	URL       string        `json:"url,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// newRunSummary 根据备份清单汇总运行结果，m 为 nil 时（运行没有开始备份）只包含集群和命名空间
+func newRunSummary(m *BackupManifest, runErr error) *RunSummary {
+	s := &RunSummary{Cluster: clusterName, Namespace: namespace, Pods: []PodResult{}}
+	if m != nil {
+		s.RunID, s.Cluster, s.Namespace, s.Storage = m.RunID, m.Cluster, m.Namespace, m.Storage
+		s.StartTime, s.EndTime = m.StartTime, m.EndTime
+		for _, e := range m.Entries {
+			pod := PodResult{
+				Pod:       e.Pod,
+				Container: e.Container,
+				Status:    e.Status,
+				Size:      e.Size,
+				URL:       e.URL,
+				Error:     e.Error,
+			}
+			if !e.StartTime.IsZero() && !e.EndTime.IsZero() {
+				pod.Duration = e.EndTime.Sub(e.StartTime)
+			}
+			if e.Status == backupStatusSuccess {
+				s.Succeeded++
+				s.Size += e.Size
+			} else {
+				s.Failed++
+			}
+			s.Pods = append(s.Pods, pod)
+		}
+	}
+	s.Status, s.Error = runStatus(s.Succeeded, s.Failed, runErr)
+	return s
+}
+
+// Duration 是运行的总耗时
+func (s *RunSummary) Duration() time.Duration {
+	if s.StartTime.IsZero() || s.EndTime.IsZero() {
+		return 0
+	}
+	return s.EndTime.Sub(s.StartTime)
+}
+
+// runStatus 根据成功、失败的 pod 数和运行错误确定运行的状态和错误信息，部分 pod 失败时为 partial
+func runStatus(succeeded, failed int, runErr error) (string, string) {
+	errText := ""
+	if runErr != nil {
+		errText = runErr.Error()
+	}
+	switch {
+	case succeeded == 0 && failed == 0:
+		if errText == "" {
+			errText = "没有备份任何 pod"
+		}
+		return backupStatusFailed, errText
+	case failed == 0 && runErr == nil:
+		return backupStatusSuccess, errText
+	case succeeded == 0:
+		return backupStatusFailed, errText
+	default:
+		return backupStatusPartial, errText
+	}
+}
+
+// fields 将运行结果展开为通知字段，每个 pod 一个字段
+func (s *RunSummary) fields() []NotificationField {
+	fields := []NotificationField{
+		field("集群", s.Cluster),
+		field("命名空间", s.Namespace),
+	}
+	if s.RunID != "" {
+		fields = append(fields, field("运行 ID", s.RunID))
+	}
+	if s.Storage != "" {
+		fields = append(fields, field("存储", s.Storage))
+	}
+	fields = append(fields, field("结果", fmt.Sprintf("成功 %d，失败 %d，共 %s", s.Succeeded, s.Failed, formatSize(s.Size))))
+	if d := s.Duration(); d > 0 {
+		fields = append(fields, field("耗时", formatSeconds(d)))
+	}
+	if s.Error != "" {
+		fields = append(fields, field("错误信息", s.Error))
+	}
+	for _, p := range s.Pods {
+		fields = append(fields, field(p.name(), p.result()))
+	}
+	return fields
+}
+
+func (p PodResult) name() string {
+	if p.Container == "" {
+		return p.Pod
+	}
+	return p.Pod + "/" + p.Container
+}
+
+// result 是 pod 结果的一行描述：成功时为大小、耗时和下载地址，失败时为错误信息
+func (p PodResult) result() string {
+	if p.Status != backupStatusSuccess {
+		return "失败，" + p.Error
+	}
+	text := fmt.Sprintf("成功，%s，%s", formatSize(p.Size), formatSeconds(p.Duration))
+	if p.URL != "" {
+		text += "，" + p.URL
+	}
+	return text
+}
+
+// formatSeconds 以秒展示耗时
+func formatSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.2f 秒", d.Seconds())
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+var (
+	uploadConcurrency int
+	bandwidthLimit    string
+)
+
+// uploadLimiter 限制所有 pod 上传到存储的总带宽，为 nil 时不限速
+var uploadLimiter *rate.Limiter
+
+// throttleBurst 是每次从限速器申请的最大字节数，越小速率越平滑
+const throttleBurst = 256 * 1024
+
+// setBandwidthLimit 按 --bandwidth-limit 设置上传限速，limit 为每秒字节数，如 50MB、512KiB，为空或 0 时不限速
+func setBandwidthLimit(limit string) error {
+	if limit == "" {
+		uploadLimiter = nil
+		return nil
+	}
+	bytesPerSecond, err := parseSize(limit)
+	if err != nil {
+		return fmt.Errorf("无法解析带宽限制 %s: %v", limit, err)
+	}
+	if bytesPerSecond <= 0 {
+		uploadLimiter = nil
+		return nil
+	}
+	uploadLimiter = rate.NewLimiter(rate.Limit(bytesPerSecond), throttleBurst)
+	return nil
+}
+
+// sizeUnits 同时支持十进制（KB、MB）和二进制（KiB、MiB）单位，K、M、G 按二进制处理
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	{"B", 1},
+}
+
+// parseSize 解析 10MB、512KiB、1048576 这样的字节数
+func parseSize(s string) (int64, error) {
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	factor := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper, factor = strings.TrimSpace(strings.TrimSuffix(upper, unit.suffix)), unit.factor
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(upper, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("无效的大小 %s", s)
+	}
+	return int64(n * float64(factor)), nil
+}
+
+// uploadReader 返回上传 data 使用的 Reader，设置了限速时按 uploadLimiter 的速率读取。
+// 返回值实现了 io.Seeker，存储 SDK 重试时可以从头重新读取
+func uploadReader(ctx context.Context, data []byte) io.ReadSeeker {
+	if uploadLimiter == nil {
+		return bytes.NewReader(data)
+	}
+	return &throttledReader{ctx: ctx, r: bytes.NewReader(data)}
+}
+
+type throttledReader struct {
+	ctx context.Context
+	r   *bytes.Reader
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleBurst {
+		p = p[:throttleBurst]
+	}
+	if remaining := t.r.Len(); remaining > 0 && remaining < len(p) {
+		p = p[:remaining]
+	}
+	if t.r.Len() > 0 {
+		if err := uploadLimiter.WaitN(t.ctx, len(p)); err != nil {
+			return 0, err
+		}
+	}
+	return t.r.Read(p)
+}
+
+func (t *throttledReader) Seek(offset int64, whence int) (int64, error) {
+	return t.r.Seek(offset, whence)
+}
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		size int64
+	}{
+		{"1048576", 1 << 20},
+		{"512KiB", 512 << 10},
+		{"512K", 512 << 10},
+		{"10MB", 10 * 1000 * 1000},
+		{"10 mib", 10 << 20},
+		{"1.5G", 3 << 29},
+		{"100B", 100},
+		{"0", 0},
+	}
+	for _, tt := range tests {
+		size, err := parseSize(tt.s)
+		if err != nil || size != tt.size {
+			t.Errorf("parseSize(%q) = %d, %v，预期 %d", tt.s, size, err, tt.size)
+		}
+	}
+	for _, s := range []string{"", "MB", "-1MB", "ten"} {
+		if _, err := parseSize(s); err == nil {
+			t.Errorf("parseSize(%q) 应返回错误", s)
+		}
+	}
+}
+
+func TestSetBandwidthLimit(t *testing.T) {
+	t.Cleanup(func() { uploadLimiter = nil })
+	for _, limit := range []string{"", "0"} {
+		if err := setBandwidthLimit(limit); err != nil || uploadLimiter != nil {
+			t.Fatalf("%q 应不限速: %v", limit, err)
+		}
+	}
+	if err := setBandwidthLimit("50MB"); err != nil || uploadLimiter == nil || uploadLimiter.Limit() != 50*1000*1000 {
+		t.Fatalf("50MB 的限速: %v", err)
+	}
+	if err := setBandwidthLimit("fast"); err == nil {
+		t.Fatal("无法解析的带宽限制应返回错误")
+	}
+}
+
+func TestThrottledReader(t *testing.T) {
+	t.Cleanup(func() { uploadLimiter = nil })
+	data := bytes.Repeat([]byte("x"), 3*throttleBurst)
+
+	// 不限速时直接读取
+	uploadLimiter = nil
+	if _, ok := uploadReader(context.Background(), data).(*bytes.Reader); !ok {
+		t.Fatal("不限速时不应包装 Reader")
+	}
+
+	// 每秒 4 个 burst：第一个 burst 立即可用，剩下的 2 个需要约 0.5 秒
+	uploadLimiter = rate.NewLimiter(rate.Limit(4*throttleBurst), throttleBurst)
+	r := uploadReader(context.Background(), data)
+	start := time.Now()
+	read, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("限速后读取 %d 字节只用了 %v", len(read), elapsed)
+	}
+	if !bytes.Equal(read, data) {
+		t.Fatal("限速读取的数据不一致")
+	}
+
+	// 存储 SDK 重试时从头重新读取
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := io.ReadAll(uploadReader(ctx, data)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("取消后读取应返回 context.Canceled，实际: %v", err)
+	}
+}
+
+// countingUploader 记录同时上传的分片数，每个分片上传需要 delay
+type countingUploader struct {
+	delay time.Duration
+
+	mu        sync.Mutex
+	active    int
+	maxActive int
+	parts     map[int][]byte
+	completed []uploadedPart
+	put       []byte
+}
+
+func (u *countingUploader) putObject(ctx context.Context, key string, data []byte, meta map[string]string) error {
+	u.put = append([]byte(nil), data...)
+	return nil
+}
+
+func (u *countingUploader) initUpload(ctx context.Context, key string, meta map[string]string) (string, error) {
+	u.parts = map[int][]byte{}
+	return "upload-1", nil
+}
+
+func (u *countingUploader) uploadPart(ctx context.Context, key, uploadID string, partNumber int, data []byte) (string, error) {
+	u.mu.Lock()
+	u.active++
+	if u.active > u.maxActive {
+		u.maxActive = u.active
+	}
+	u.mu.Unlock()
+	time.Sleep(u.delay)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.active--
+	u.parts[partNumber] = append([]byte(nil), data...)
+	return fmt.Sprintf("etag-%d", partNumber), nil
+}
+
+func (u *countingUploader) listParts(ctx context.Context, key, uploadID string) ([]uploadedPart, error) {
+	return nil, nil
+}
+
+func (u *countingUploader) completeUpload(ctx context.Context, key, uploadID string, parts []uploadedPart) error {
+	u.completed = parts
+	return nil
+}
+
+func (u *countingUploader) abortUpload(ctx context.Context, key, uploadID string) error {
+	return nil
+}
+
+func TestMultipartPutConcurrency(t *testing.T) {
+	const partSize = 1024
+	data := make([]byte, 10*partSize+100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	for _, concurrency := range []int{1, 3} {
+		u := &countingUploader{delay: 20 * time.Millisecond}
+		if err := multipartPut(context.Background(), u, "key", bytes.NewReader(data), partSize, concurrency, nil); err != nil {
+			t.Fatal(err)
+		}
+		if u.maxActive != concurrency {
+			t.Fatalf("并发数 %d 时最多同时上传了 %d 个分片", concurrency, u.maxActive)
+		}
+		// 分片按编号排序后完成上传，拼接后与原数据一致
+		if len(u.completed) != 11 {
+			t.Fatalf("完成上传的分片数: %d", len(u.completed))
+		}
+		var joined []byte
+		for i, part := range u.completed {
+			if part.Number != i+1 || part.ETag != fmt.Sprintf("etag-%d", i+1) {
+				t.Fatalf("分片 %d: %+v", i, part)
+			}
+			joined = append(joined, u.parts[part.Number]...)
+		}
+		if !bytes.Equal(joined, data) {
+			t.Fatal("分片拼接后的数据不一致")
+		}
+	}
+
+	// 不足一个分片时普通上传
+	u := &countingUploader{}
+	if err := multipartPut(context.Background(), u, "key", bytes.NewReader(data[:100]), partSize, 3, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(u.put, data[:100]) || u.parts != nil {
+		t.Fatal("不足一个分片时应普通上传")
+	}
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var verifyRunID string
+
+func init() {
+	verifyCmd.Flags().StringVar(&restoreFile, "file", "", "要校验的备份文件，可以是存储中的完整路径，也可以只是备份文件名")
+	verifyCmd.Flags().StringVar(&verifyRunID, "run", "", "校验指定运行 ID 的备份清单中的所有备份文件")
+	addScopeFlags(verifyCmd)
+	addStorageFlags(verifyCmd)
+	rootCmd.AddCommand(verifyCmd)
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "verify backup checksums in storage",
+	Long:  `重新读取存储中的备份文件并计算 SHA-256，与备份时记录的校验和比对，不需要访问 Kubernetes。`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		if (restoreFile == "") == (verifyRunID == "") {
+			fmt.Println("错误：必须且只能指定 --file 或 --run 其中之一")
+			os.Exit(1)
+		}
+
+		store, err := openStorage(ctx)
+		if err != nil {
+			fmt.Printf("打开存储失败: %v\n", err)
+			os.Exit(1)
+		}
+
+		failed := 0
+		if restoreFile != "" {
+			key, err := resolveBackupKey(ctx, store, restoreFile)
+			if err != nil {
+				fmt.Printf("查找备份文件失败: %v\n", err)
+				os.Exit(1)
+			}
+			expected, err := expectedChecksum(ctx, store, key)
+			if err != nil {
+				fmt.Printf("读取备份校验和失败: %v\n", err)
+				os.Exit(1)
+			}
+			if !verifyBackup(ctx, store, key, expected) {
+				failed++
+			}
+		} else {
+			m, err := loadManifest(ctx, store, manifestKey(clusterName, namespace, verifyRunID))
+			if err != nil {
+				fmt.Printf("读取备份清单失败: %v\n", err)
+				os.Exit(1)
+			}
+			for _, entry := range m.Entries {
+				if entry.Status != backupStatusSuccess || entry.Key == "" {
+					continue
+				}
+				if !verifyBackup(ctx, store, entry.Key, entry.SHA256) {
+					failed++
+				}
+			}
+		}
+
+		if failed > 0 {
+			fmt.Printf("%d 个备份文件校验失败\n", failed)
+			os.Exit(1)
+		}
+	},
+}
+
+// verifyBackup 校验单个备份文件并输出结果，返回是否通过
+func verifyBackup(ctx context.Context, store Storage, key, expected string) bool {
+	actual, err := verifyObject(ctx, store, key, expected)
+	var mismatch *checksumMismatchError
+	switch {
+	case errors.As(err, &mismatch):
+		fmt.Printf("FAILED  %s\n  预期: %s\n  实际: %s\n", key, mismatch.expected, mismatch.actual)
+		return false
+	case err != nil:
+		fmt.Printf("ERROR   %s: %v\n", key, err)
+		return false
+	case expected == "":
+		fmt.Printf("UNKNOWN %s: 没有记录校验和，SHA-256: %s\n", key, actual)
+		return true
+	default:
+		fmt.Printf("OK      %s  %s\n", key, actual)
+		return true
+	}
+}